@@ -12,6 +12,7 @@ import (
 	"github.com/Ryan-Gosusluging/forum/internal/storage"
 	"github.com/Ryan-Gosusluging/forum/pkg/config"
 	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/Ryan-Gosusluging/forum/pkg/mailer"
 	"google.golang.org/grpc"
 )
 
@@ -34,9 +35,31 @@ func main() {
 
 	// Create repositories
 	userRepo := storage.NewUserRepository(db)
+	tokenRepo := storage.NewTokenRepository(db)
+	throttleRepo := storage.NewLoginThrottleRepository(db)
+	mfaRepo := storage.NewMFARepository(db)
+	identityRepo := storage.NewIdentityRepository(db)
+	accessTokenRepo := storage.NewAccessTokenRepository(db)
+
+	// Create mailer
+	mail, err := mailer.New(cfg)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create mailer")
+	}
+
+	// Load password policy
+	policy, err := auth.LoadPasswordPolicy(cfg)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to load password policy")
+	}
 
 	// Create auth service
-	authService := auth.NewService(userRepo, cfg)
+	throttler := auth.NewLoginThrottler(throttleRepo, cfg)
+	go throttler.RunCleanup(context.Background())
+	oidc := auth.NewOIDCConnector(identityRepo, cfg)
+	go oidc.RunCleanup(context.Background())
+	authService := auth.NewService(userRepo, tokenRepo, mfaRepo, accessTokenRepo, mail, policy, throttler, oidc, cfg)
+	go authService.RunTokenCleanup(context.Background())
 
 	// Create gRPC server
 	grpcServer := grpc.NewServer()
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"expvar"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,9 +11,15 @@ import (
 
 	"github.com/Ryan-Gosusluging/forum/internal/auth"
 	"github.com/Ryan-Gosusluging/forum/internal/chat"
+	"github.com/Ryan-Gosusluging/forum/internal/events"
+	"github.com/Ryan-Gosusluging/forum/internal/forum"
+	"github.com/Ryan-Gosusluging/forum/internal/notify"
 	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/internal/upload"
+	"github.com/Ryan-Gosusluging/forum/internal/webhook"
 	"github.com/Ryan-Gosusluging/forum/pkg/config"
 	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/Ryan-Gosusluging/forum/pkg/mailer"
 	"google.golang.org/grpc"
 )
 
@@ -35,6 +42,22 @@ func main() {
 
 	// Create repositories
 	chatRepo := storage.NewChatRepository(db)
+	roomRepo := storage.NewRoomRepository(db)
+	convRepo := storage.NewConversationRepository(db)
+	blockRepo := storage.NewBlockRepository(db)
+	presenceRepo := storage.NewPresenceRepository(db)
+	auditRepo := storage.NewAuditRepository(db)
+	sanctionRepo := storage.NewSanctionRepository(db)
+	userRepo := storage.NewUserRepository(db)
+	postRepo := storage.NewPostRepository(db)
+	commentRepo := storage.NewCommentRepository(db)
+	attachmentRepo := storage.NewAttachmentRepository(db)
+	accessTokenRepo := storage.NewAccessTokenRepository(db)
+	notificationRepo := storage.NewNotificationRepository(db)
+	subscriptionRepo := storage.NewSubscriptionRepository(db)
+	webhookRepo := storage.NewWebhookRepository(db)
+	outboxRepo := storage.NewOutboxRepository(db)
+	reportRepo := storage.NewReportRepository(db)
 
 	// Create auth service connection
 	conn, err := grpc.Dial("localhost:"+strconv.Itoa(cfg.AuthServicePort), grpc.WithInsecure())
@@ -44,20 +67,119 @@ func main() {
 	defer conn.Close()
 
 	authClient := proto.NewAuthServiceClient(conn)
-	authService := auth.NewService(nil, cfg) // We don't need user repo here
+	authService := auth.NewService(userRepo, nil, nil, accessTokenRepo, nil, nil, nil, nil, cfg) // Only used to validate tokens
+
+	// Create upload service
+	blobStore, err := upload.NewBlobStore(cfg)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create blob store")
+	}
+	urlSigner := upload.NewURLSigner(cfg.UploadURLSecret, cfg.UploadURLTTL)
+	uploadService := upload.NewService(blobStore, attachmentRepo, urlSigner, cfg.UploadMaxBytes)
+
+	// Create mailer
+	mail, err := mailer.New(cfg)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create mailer")
+	}
+
+	// Create notification service, new notifications are pushed over the chat hub
+	notifier := notify.NewService(notificationRepo, userRepo, postRepo, commentRepo, blockRepo)
+	unsubscribeSigner := notify.NewUnsubscribeSigner(cfg.UnsubscribeSecret, cfg.PublicBaseURL)
+	digest := notify.NewDigest(subscriptionRepo, mail, unsubscribeSigner, cfg)
+	go digest.Run(context.Background())
+
+	// Create webhook dispatcher
+	webhookDispatcher := webhook.NewDispatcher(webhookRepo, cfg)
+	go webhookDispatcher.Run(context.Background())
 
 	// Create chat hub
-	chatHub := chat.NewHub(chatRepo, cfg)
+	chatBroker, err := chat.NewBroker(cfg, db)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create chat broker")
+	}
+	defer chatBroker.Close()
+	var chatArchive chat.ArchiveStore
+	switch cfg.ChatArchive {
+	case "none":
+	case "blob":
+		chatArchive = blobStore
+	default:
+		logger.Fatal().Str("archive", cfg.ChatArchive).Msg("Unknown chat archive")
+	}
+	chatHub := chat.NewHub(chatRepo, roomRepo, convRepo, blockRepo, presenceRepo, auditRepo, sanctionRepo, userRepo, notifier, chatBroker, chatArchive, cfg)
+	notifier.SetPusher(chatHub)
 	go chatHub.Run(context.Background())
 
+	// Relay domain events from the outbox to their subscribers
+	eventBus := events.NewBus()
+	eventBus.Subscribe("notifications", notifier.HandleCommentCreated, events.CommentCreated)
+	webhook.Subscribe(eventBus, webhookRepo)
+	relay := events.NewRelay(outboxRepo, eventBus, cfg)
+	go relay.Run(context.Background())
+
 	// Create HTTP handlers
 	chatHandler := chat.NewHandler(chatHub, authService)
-	messagesHandler := chat.NewMessagesHandler(chatHub)
+	messagesHandler := chat.NewMessagesHandler(chatHub, authService)
+	roomsHandler := chat.NewRoomsHandler(chatHub, authService)
+	conversationsHandler := chat.NewConversationsHandler(chatHub, authService)
+	blocksHandler := chat.NewBlocksHandler(chatHub, authService)
+	onlineHandler := chat.NewOnlineHandler(chatHub, authService)
+	sanctionsHandler := chat.NewSanctionsHandler(chatHub, authService)
+	postHandler := forum.NewPostHandler(postRepo, userRepo, subscriptionRepo, authService, authClient)
+	commentHandler := forum.NewCommentHandler(commentRepo, subscriptionRepo, authService)
+	reportHandler := forum.NewReportHandler(reportRepo, authService)
+	notificationsHandler := notify.NewHandler(notificationRepo, subscriptionRepo, authService)
+	unsubscribeHandler := notify.NewUnsubscribeHandler(subscriptionRepo, unsubscribeSigner)
+	userHandler := forum.NewUserHandler(authClient, postRepo, commentRepo)
+	meHandler := forum.NewMeHandler(authClient)
+	accountHandler := forum.NewAccountHandler(authClient)
+	oidcHandler := forum.NewOIDCHandler(authClient)
+	tokenHandler := forum.NewTokenHandler(authClient)
+	adminHandler := forum.NewAdminHandler(authClient)
+	webhooksHandler := webhook.NewHandler(webhookRepo, authService)
+	uploadHandler := upload.NewHandler(uploadService, attachmentRepo, postRepo, commentRepo, authService, authClient)
+	fileHandler := upload.NewFileHandler(blobStore, urlSigner)
 
 	// Create HTTP server
 	mux := http.NewServeMux()
 	mux.Handle("/ws", chatHandler)
 	mux.Handle("/api/chat/messages", messagesHandler)
+	mux.Handle("/api/chat/rooms", roomsHandler)
+	mux.Handle("/api/chat/rooms/", roomsHandler)
+	mux.Handle("/api/chat/conversations", conversationsHandler)
+	mux.Handle("/api/chat/conversations/", conversationsHandler)
+	mux.Handle("/api/chat/blocks", blocksHandler)
+	mux.Handle("/api/chat/blocks/", blocksHandler)
+	mux.Handle("/api/chat/online", onlineHandler)
+	mux.Handle("/api/posts", postHandler)
+	mux.Handle("/api/posts/", postHandler)
+	mux.Handle("/api/comments", commentHandler)
+	mux.Handle("/api/reports", reportHandler)
+	mux.Handle("/api/notifications", notificationsHandler)
+	mux.Handle("/api/notifications/", notificationsHandler)
+	mux.Handle(notify.UnsubscribePath, unsubscribeHandler)
+	mux.Handle("/api/users/", userHandler)
+	mux.Handle("/api/me", meHandler)
+	mux.Handle("/api/me/password", accountHandler)
+	mux.Handle("/api/me/mfa", accountHandler)
+	mux.Handle("/api/me/mfa/", accountHandler)
+	mux.Handle("/api/auth/", accountHandler)
+	mux.Handle("/api/auth/oidc/", oidcHandler)
+	mux.Handle("/api/me/identities", oidcHandler)
+	mux.Handle("/api/me/identities/", oidcHandler)
+	mux.Handle("/api/me/tokens", tokenHandler)
+	mux.Handle("/api/me/tokens/", tokenHandler)
+	mux.Handle("/api/admin/", adminHandler)
+	mux.Handle("/api/admin/chat/sanctions", sanctionsHandler)
+	mux.Handle("/api/admin/chat/sanctions/", sanctionsHandler)
+	mux.Handle("/api/admin/webhooks", webhooksHandler)
+	mux.Handle("/api/admin/webhooks/", webhooksHandler)
+	mux.Handle("/api/uploads", uploadHandler)
+	mux.Handle("/api/attachments", uploadHandler)
+	mux.Handle("/api/attachments/", uploadHandler)
+	mux.Handle(upload.FilesPrefix, fileHandler)
+	mux.Handle("/debug/vars", expvar.Handler())
 
 	server := &http.Server{
 		Addr:    ":" + strconv.Itoa(cfg.ForumServicePort),
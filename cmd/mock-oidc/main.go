@@ -0,0 +1,201 @@
+// Command mock-oidc runs a minimal OpenID Connect issuer for local
+// development. It approves every authorization request without a login page,
+// so the forum's OIDC flow can be exercised end to end without a real
+// provider. The signed-in subject can be chosen with the login_hint
+// parameter of the authorization request.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"math/big"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "mock-key"
+
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+	subject       string
+	expiresAt     time.Time
+}
+
+type issuer struct {
+	url  string
+	key  *rsa.PrivateKey
+	mu   sync.Mutex
+	auth map[string]authorization
+}
+
+func main() {
+	logger.Init()
+
+	addr := flag.String("addr", ":9000", "listen address")
+	issuerURL := flag.String("issuer", "http://localhost:9000", "issuer URL announced in discovery and tokens")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to generate signing key")
+	}
+
+	iss := &issuer{url: *issuerURL, key: key, auth: make(map[string]authorization)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", iss.handleDiscovery)
+	mux.HandleFunc("/authorize", iss.handleAuthorize)
+	mux.HandleFunc("/token", iss.handleToken)
+	mux.HandleFunc("/jwks", iss.handleJWKS)
+
+	logger.Info().Str("addr", *addr).Str("issuer", *issuerURL).Msg("Starting mock OIDC issuer")
+	if err := http.ListenAndServe(*addr, mux); err != nil {
+		logger.Fatal().Err(err).Msg("Mock OIDC issuer failed")
+	}
+}
+
+func (i *issuer) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                i.url,
+		"authorization_endpoint":                i.url + "/authorize",
+		"token_endpoint":                        i.url + "/token",
+		"jwks_uri":                              i.url + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (i *issuer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("redirect_uri") == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "PKCE with S256 is required", http.StatusBadRequest)
+		return
+	}
+
+	subject := q.Get("login_hint")
+	if subject == "" {
+		subject = "mock-user"
+	}
+
+	code := randomString()
+	i.mu.Lock()
+	i.auth[code] = authorization{
+		clientID:      q.Get("client_id"),
+		redirectURI:   q.Get("redirect_uri"),
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		subject:       subject,
+		expiresAt:     time.Now().Add(time.Minute),
+	}
+	i.mu.Unlock()
+
+	params := redirectURI.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirectURI.RawQuery = params.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (i *issuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	i.mu.Lock()
+	auth, ok := i.auth[code]
+	delete(i.auth, code)
+	i.mu.Unlock()
+
+	if !ok || time.Now().After(auth.expiresAt) || r.PostForm.Get("redirect_uri") != auth.redirectURI {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != auth.codeChallenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":                i.url,
+		"sub":                auth.subject,
+		"aud":                auth.clientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              auth.nonce,
+		"email":              auth.subject + "@mock-oidc.local",
+		"email_verified":     true,
+		"preferred_username": auth.subject,
+	})
+	token.Header["kid"] = keyID
+
+	idToken, err := token.SignedString(i.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (i *issuer) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	pub := i.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
+
+func randomString() string {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to read random bytes")
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
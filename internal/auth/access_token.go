@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/Ryan-Gosusluging/forum/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Scopes that can be granted to personal access tokens
+const (
+	ScopePostsRead    = "posts:read"
+	ScopePostsWrite   = "posts:write"
+	ScopeChatRead     = "chat:read"
+	ScopeChatWrite    = "chat:write"
+	ScopeProfileRead  = "profile:read"
+	ScopeProfileWrite = "profile:write"
+)
+
+const (
+	// AccessTokenPrefix starts every personal access token, which tells them
+	// apart from JWTs and makes leaked tokens easy to scan for
+	AccessTokenPrefix = "fpat_"
+
+	accessTokenIDLength      = 8
+	accessTokenIDAlphabet    = "abcdefghijklmnopqrstuvwxyz0123456789"
+	maxAccessTokensPerUser   = 50
+	maxAccessTokenNameLength = 100
+	defaultAccessTokenTTL    = 90 * 24 * time.Hour
+	maxAccessTokenTTL        = 365 * 24 * time.Hour
+	// accessTokenTouchInterval limits how often last-used times are written
+	accessTokenTouchInterval = time.Minute
+)
+
+var knownScopes = map[string]bool{
+	ScopePostsRead:    true,
+	ScopePostsWrite:   true,
+	ScopeChatRead:     true,
+	ScopeChatWrite:    true,
+	ScopeProfileRead:  true,
+	ScopeProfileWrite: true,
+}
+
+var (
+	ErrMissingToken = errors.New("missing authorization token")
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrMissingScope is returned when an API token lacks the required scope
+	ErrMissingScope = errors.New("token lacks the required scope")
+	// ErrSessionRequired is returned when an API token is used where only
+	// sign-in sessions are accepted
+	ErrSessionRequired = errors.New("API tokens are not accepted")
+)
+
+// HasScope reports whether a validated token may be used for the scope.
+// Session tokens carry every scope; API tokens only the granted ones.
+func HasScope(claims *proto.ValidateTokenResponse, scope string) bool {
+	if !claims.IsApiToken || scope == "" {
+		return true
+	}
+	for _, granted := range claims.Scopes {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate validates the token in the Authorization header and checks
+// that it carries the scope. An empty scope accepts any valid token.
+func (s *Service) Authenticate(r *http.Request, scope string) (*proto.ValidateTokenResponse, error) {
+	token := TokenFromRequest(r)
+	if token == "" {
+		return nil, ErrMissingToken
+	}
+
+	claims, err := s.ValidateToken(r.Context(), &proto.ValidateTokenRequest{Token: token})
+	if err != nil || !claims.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	if !HasScope(claims, scope) {
+		return nil, ErrMissingScope
+	}
+
+	return claims, nil
+}
+
+// AuthenticateSession is Authenticate for endpoints that API tokens must not
+// reach whatever their scopes, such as administration and moderation
+func (s *Service) AuthenticateSession(r *http.Request) (*proto.ValidateTokenResponse, error) {
+	claims, err := s.Authenticate(r, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.IsApiToken {
+		return nil, ErrSessionRequired
+	}
+
+	return claims, nil
+}
+
+// CreateAccessToken issues a personal access token. The secret is returned
+// only in this response.
+func (s *Service) CreateAccessToken(ctx context.Context, req *proto.CreateAccessTokenRequest) (*proto.CreateAccessTokenResponse, error) {
+	user, err := s.authenticatedUser(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxAccessTokenNameLength {
+		return nil, status.Errorf(codes.InvalidArgument, "name must be between 1 and %d characters", maxAccessTokenNameLength)
+	}
+
+	scopes, err := normalizeScopes(req.Scopes)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ttl := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	if ttl == 0 {
+		ttl = defaultAccessTokenTTL
+	}
+	if ttl < 0 || ttl > maxAccessTokenTTL {
+		return nil, status.Error(codes.InvalidArgument, "expires_in_days must be between 1 and 365")
+	}
+	expiresAt := time.Now().Add(ttl)
+
+	count, err := s.accessTokenRepo.CountActiveAccessTokens(ctx, user.ID)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to count access tokens")
+		return nil, status.Error(codes.Internal, "failed to create token")
+	}
+	if count >= maxAccessTokensPerUser {
+		return nil, status.Errorf(codes.ResourceExhausted, "at most %d active tokens are allowed", maxAccessTokensPerUser)
+	}
+
+	prefix, secret, err := generateAccessToken()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to generate access token")
+		return nil, status.Error(codes.Internal, "failed to create token")
+	}
+
+	token, err := s.accessTokenRepo.CreateAccessToken(ctx, user.ID, name, prefix, hashToken(secret), scopes, &expiresAt)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to store access token")
+		return nil, status.Error(codes.Internal, "failed to create token")
+	}
+
+	logger.Info().Int64("user_id", user.ID).Int64("token_id", token.ID).Strs("scopes", scopes).Msg("Personal access token created")
+	return &proto.CreateAccessTokenResponse{
+		AccessToken: secret,
+		Info:        toAccessTokenInfo(token),
+	}, nil
+}
+
+// ListAccessTokens returns the unrevoked tokens of the authenticated user
+func (s *Service) ListAccessTokens(ctx context.Context, req *proto.ListAccessTokensRequest) (*proto.ListAccessTokensResponse, error) {
+	user, err := s.authenticatedUser(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := s.accessTokenRepo.GetAccessTokensByUserID(ctx, user.ID)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to list access tokens")
+		return nil, status.Error(codes.Internal, "failed to list tokens")
+	}
+
+	resp := &proto.ListAccessTokensResponse{}
+	for i := range tokens {
+		resp.Tokens = append(resp.Tokens, toAccessTokenInfo(&tokens[i]))
+	}
+	return resp, nil
+}
+
+// RevokeAccessToken revokes one of the authenticated user's tokens
+func (s *Service) RevokeAccessToken(ctx context.Context, req *proto.RevokeAccessTokenRequest) (*proto.RevokeAccessTokenResponse, error) {
+	user, err := s.authenticatedUser(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	revoked, err := s.accessTokenRepo.RevokeAccessToken(ctx, user.ID, req.Id)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to revoke access token")
+		return nil, status.Error(codes.Internal, "failed to revoke token")
+	}
+	if !revoked {
+		return nil, status.Error(codes.NotFound, "token not found")
+	}
+
+	logger.Info().Int64("user_id", user.ID).Int64("token_id", req.Id).Msg("Personal access token revoked")
+	return &proto.RevokeAccessTokenResponse{}, nil
+}
+
+// validateAccessToken checks a personal access token and records its use
+func (s *Service) validateAccessToken(ctx context.Context, secret string) *proto.ValidateTokenResponse {
+	if s.accessTokenRepo == nil {
+		return &proto.ValidateTokenResponse{Valid: false}
+	}
+
+	token, err := s.accessTokenRepo.GetActiveAccessToken(ctx, hashToken(secret))
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to look up access token")
+		return &proto.ValidateTokenResponse{Valid: false}
+	}
+	if token == nil {
+		return &proto.ValidateTokenResponse{Valid: false}
+	}
+
+	now := time.Now()
+	if err := s.accessTokenRepo.TouchAccessToken(ctx, token.ID, now, now.Add(-accessTokenTouchInterval)); err != nil {
+		logger.Error().Err(err).Int64("token_id", token.ID).Msg("Failed to record access token use")
+	}
+
+	return &proto.ValidateTokenResponse{
+		Valid:      true,
+		UserId:     token.UserID,
+		Username:   token.Username,
+		Role:       token.Role,
+		Scopes:     token.Scopes,
+		IsApiToken: true,
+	}
+}
+
+// generateAccessToken returns the display prefix and the full token. The
+// prefix holds a random identifier, the rest is a 256-bit secret.
+func generateAccessToken() (string, string, error) {
+	raw := make([]byte, accessTokenIDLength)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+
+	id := make([]byte, accessTokenIDLength)
+	for i, c := range raw {
+		id[i] = accessTokenIDAlphabet[int(c)%len(accessTokenIDAlphabet)]
+	}
+
+	secret, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	prefix := AccessTokenPrefix + string(id)
+	return prefix, prefix + "_" + secret, nil
+}
+
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+
+	seen := make(map[string]bool, len(scopes))
+	var normalized []string
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !knownScopes[scope] {
+			return nil, errors.New("unknown scope: " + scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+func toAccessTokenInfo(token *storage.AccessToken) *proto.AccessTokenInfo {
+	info := &proto.AccessTokenInfo{
+		Id:        token.ID,
+		Name:      token.Name,
+		Prefix:    token.TokenPrefix,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt.Unix(),
+	}
+	if token.ExpiresAt != nil {
+		info.ExpiresAt = token.ExpiresAt.Unix()
+	}
+	if token.LastUsedAt != nil {
+		info.LastUsedAt = token.LastUsedAt.Unix()
+	}
+	return info
+}
@@ -0,0 +1,267 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/Ryan-Gosusluging/forum/pkg/mailer"
+	"github.com/Ryan-Gosusluging/forum/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// passwordResetMailTimeout bounds the delivery of a reset email, which runs
+// after the request has been answered
+const passwordResetMailTimeout = 30 * time.Second
+
+// RequestPasswordReset emails a reset link to the owner of the address. It
+// always succeeds so that callers cannot probe which emails are registered;
+// the email is sent in the background so that the response time does not
+// tell either.
+func (s *Service) RequestPasswordReset(ctx context.Context, req *proto.RequestPasswordResetRequest) (*proto.RequestPasswordResetResponse, error) {
+	user, err := s.userRepo.GetUserByEmail(ctx, req.Email)
+	if err != nil {
+		logger.Info().Msg("Password reset requested for unknown email")
+		return &proto.RequestPasswordResetResponse{}, nil
+	}
+
+	go s.sendPasswordReset(context.WithoutCancel(ctx), user)
+
+	logger.Info().Int64("user_id", user.ID).Msg("Password reset requested")
+	return &proto.RequestPasswordResetResponse{}, nil
+}
+
+// sendPasswordReset creates a reset token for the user and emails the link.
+// Failures are only logged, the caller has already been answered.
+func (s *Service) sendPasswordReset(ctx context.Context, user *storage.User) {
+	ctx, cancel := context.WithTimeout(ctx, passwordResetMailTimeout)
+	defer cancel()
+
+	token, err := s.createOneTimeToken(ctx, user.ID, storage.TokenPurposePasswordReset, s.cfg.PasswordResetTTL)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to create password reset token")
+		return
+	}
+
+	link := s.cfg.PublicBaseURL + "/reset-password?token=" + url.QueryEscape(token)
+	err = s.send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your forum account. "+
+			"If it was you, open the link below within %s:\n\n%s\n\n"+
+			"If you did not request a reset, you can ignore this email.\n",
+			user.Username, s.cfg.PasswordResetTTL, link),
+	})
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to send password reset email")
+	}
+}
+
+// ResetPassword sets a new password using a reset token and revokes all sessions
+func (s *Service) ResetPassword(ctx context.Context, req *proto.ResetPasswordRequest) (*proto.ResetPasswordResponse, error) {
+	tokenHash := hashToken(req.ResetToken)
+
+	// Validate the new password before the token is consumed, so a rejected
+	// password does not burn the reset link
+	token, err := s.tokenRepo.GetActiveToken(ctx, storage.TokenPurposePasswordReset, tokenHash)
+	if err != nil {
+		return nil, tokenError(err, "failed to reset password")
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, token.UserID)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+
+	if err := s.policy.Validate(user.Username, user.Email, req.NewPassword); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	passwordHash, err := s.hasher.Hash(req.NewPassword)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to hash password")
+		return nil, status.Error(codes.Internal, "failed to reset password")
+	}
+
+	if _, err := s.tokenRepo.ConsumeToken(ctx, storage.TokenPurposePasswordReset, tokenHash); err != nil {
+		return nil, tokenError(err, "failed to reset password")
+	}
+
+	if _, err := s.userRepo.UpdatePassword(ctx, token.UserID, passwordHash); err != nil {
+		logger.Error().Err(err).Int64("user_id", token.UserID).Msg("Failed to update password")
+		return nil, status.Error(codes.Internal, "failed to reset password")
+	}
+
+	logger.Info().Int64("user_id", token.UserID).Msg("Password reset completed")
+	return &proto.ResetPasswordResponse{}, nil
+}
+
+// SendEmailVerification sends a new verification link to the authenticated user
+func (s *Service) SendEmailVerification(ctx context.Context, req *proto.SendEmailVerificationRequest) (*proto.SendEmailVerificationResponse, error) {
+	claims, err := s.validateSession(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, claims.UserId)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+
+	if user.EmailVerified {
+		return nil, status.Error(codes.FailedPrecondition, "email is already verified")
+	}
+
+	if err := s.sendEmailVerification(ctx, user); err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to send verification email")
+		return nil, status.Error(codes.Internal, "failed to send verification email")
+	}
+
+	return &proto.SendEmailVerificationResponse{}, nil
+}
+
+// VerifyEmail confirms the email address of the user the token was issued to
+func (s *Service) VerifyEmail(ctx context.Context, req *proto.VerifyEmailRequest) (*proto.VerifyEmailResponse, error) {
+	token, err := s.tokenRepo.ConsumeToken(ctx, storage.TokenPurposeEmailVerification, hashToken(req.VerificationToken))
+	if err != nil {
+		return nil, tokenError(err, "failed to verify email")
+	}
+
+	if err := s.userRepo.MarkEmailVerified(ctx, token.UserID); err != nil {
+		logger.Error().Err(err).Int64("user_id", token.UserID).Msg("Failed to mark email verified")
+		return nil, status.Error(codes.Internal, "failed to verify email")
+	}
+
+	logger.Info().Int64("user_id", token.UserID).Msg("Email verified")
+	return &proto.VerifyEmailResponse{UserId: token.UserID}, nil
+}
+
+// ChangePassword replaces the password of the authenticated user. All existing
+// sessions are revoked and a fresh token is returned for the caller.
+func (s *Service) ChangePassword(ctx context.Context, req *proto.ChangePasswordRequest) (*proto.ChangePasswordResponse, error) {
+	claims, err := s.validateSession(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, claims.UserId)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+
+	// A stolen session must not allow unlimited password guesses
+	ip := clientIP(ctx, req.ClientIp)
+	if err := s.throttler.Check(ctx, user.Username, ip); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.hasher.Verify(user.PasswordHash, req.CurrentPassword); err != nil {
+		s.loginFailed(ctx, user.Username, ip, user)
+		return nil, status.Error(codes.PermissionDenied, "current password is incorrect")
+	}
+	s.throttler.RecordSuccess(ctx, user.Username)
+
+	if err := s.policy.Validate(user.Username, user.Email, req.NewPassword); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	passwordHash, err := s.hasher.Hash(req.NewPassword)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to hash password")
+		return nil, status.Error(codes.Internal, "failed to change password")
+	}
+
+	user, err = s.userRepo.UpdatePassword(ctx, user.ID, passwordHash)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", claims.UserId).Msg("Failed to update password")
+		return nil, status.Error(codes.Internal, "failed to change password")
+	}
+
+	token, err := s.issueToken(user)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to generate token")
+		return nil, status.Error(codes.Internal, "failed to generate token")
+	}
+
+	logger.Info().Int64("user_id", user.ID).Msg("Password changed, existing sessions revoked")
+	return &proto.ChangePasswordResponse{Token: token}, nil
+}
+
+// RunTokenCleanup periodically removes expired password reset and email
+// verification tokens
+func (s *Service) RunTokenCleanup(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.tokenRepo.DeleteExpiredTokens(ctx, time.Now()); err != nil {
+				logger.Error().Err(err).Msg("Failed to cleanup expired tokens")
+			}
+		}
+	}
+}
+
+func (s *Service) sendEmailVerification(ctx context.Context, user *storage.User) error {
+	token, err := s.createOneTimeToken(ctx, user.ID, storage.TokenPurposeEmailVerification, s.cfg.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := s.cfg.PublicBaseURL + "/verify-email?token=" + url.QueryEscape(token)
+	return s.send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below within %s:\n\n%s\n",
+			user.Username, s.cfg.EmailVerificationTTL, link),
+	})
+}
+
+// createOneTimeToken generates a random token and stores only its hash
+func (s *Service) createOneTimeToken(ctx context.Context, userID int64, purpose string, ttl time.Duration) (string, error) {
+	if s.tokenRepo == nil {
+		return "", errors.New("token repository is not configured")
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.tokenRepo.CreateToken(ctx, userID, purpose, hashToken(token), time.Now().Add(ttl)); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (s *Service) send(ctx context.Context, msg mailer.Message) error {
+	if s.mailer == nil {
+		return errors.New("mailer is not configured")
+	}
+	return s.mailer.Send(ctx, msg)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenError converts a one-time token lookup failure to a gRPC status
+func tokenError(err error, msg string) error {
+	if errors.Is(err, storage.ErrTokenInvalid) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	logger.Error().Err(err).Msg("Failed to look up one-time token")
+	return status.Error(codes.Internal, msg)
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Ryan-Gosusluging/forum/pkg/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	HashAlgorithmArgon2id = "argon2id"
+	HashAlgorithmBcrypt   = "bcrypt"
+
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// ErrPasswordMismatch is returned when a password does not match its hash
+var ErrPasswordMismatch = errors.New("password does not match")
+
+// PasswordHasher hashes new passwords with the configured algorithm and
+// verifies both argon2id and legacy bcrypt hashes.
+type PasswordHasher struct {
+	algorithm   string
+	bcryptCost  int
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+func NewPasswordHasher(cfg *config.Config) *PasswordHasher {
+	return &PasswordHasher{
+		algorithm:   cfg.PasswordHashAlgorithm,
+		bcryptCost:  cfg.BcryptCost,
+		memory:      uint32(cfg.Argon2MemoryKiB),
+		iterations:  uint32(cfg.Argon2Iterations),
+		parallelism: uint8(cfg.Argon2Parallelism),
+	}
+}
+
+// Hash returns an encoded hash of the password
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	if h.algorithm == HashAlgorithmBcrypt {
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hashed), nil
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.iterations, h.memory, h.parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.memory, h.iterations, h.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify checks the password against the encoded hash. needsRehash is true when
+// the password matched but the hash uses an outdated algorithm or parameters.
+func (h *PasswordHasher) Verify(encoded, password string) (needsRehash bool, err error) {
+	if strings.HasPrefix(encoded, "$argon2id$") {
+		params, salt, key, err := decodeArgon2id(encoded)
+		if err != nil {
+			return false, err
+		}
+
+		computed := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return false, ErrPasswordMismatch
+		}
+
+		return h.algorithm != HashAlgorithmArgon2id ||
+			params.memory != h.memory ||
+			params.iterations != h.iterations ||
+			params.parallelism != h.parallelism, nil
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)); err != nil {
+		return false, ErrPasswordMismatch
+	}
+
+	if h.algorithm != HashAlgorithmBcrypt {
+		return true, nil
+	}
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err == nil && cost < h.bcryptCost, nil
+}
+
+// VerifyDummy spends the time of a real Verify without a stored hash, so that
+// sign-ins with unknown usernames cannot be told apart by their duration
+func (h *PasswordHasher) VerifyDummy(password string) {
+	h.dummyOnce.Do(func() {
+		h.dummyHash, _ = h.Hash("dummy password")
+	})
+	h.Verify(h.dummyHash, password)
+}
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+}
+
+func decodeArgon2id(encoded string) (*argon2Params, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return nil, nil, nil, errors.New("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, errors.New("unsupported argon2id version")
+	}
+
+	params := &argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.iterations, &params.parallelism); err != nil {
+		return nil, nil, nil, errors.New("invalid argon2id parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, errors.New("invalid argon2id salt")
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, errors.New("invalid argon2id key")
+	}
+
+	return params, salt, key, nil
+}
@@ -0,0 +1,372 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/config"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/Ryan-Gosusluging/forum/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const maxGeneratedUsernameLength = 32
+
+// OIDCConnector holds the configured identity providers and the pending
+// authorization code flows
+type OIDCConnector struct {
+	repo      *storage.IdentityRepository
+	providers map[string]*OIDCProvider
+	names     []string
+	stateTTL  time.Duration
+}
+
+func NewOIDCConnector(repo *storage.IdentityRepository, cfg *config.Config) *OIDCConnector {
+	c := &OIDCConnector{
+		repo:      repo,
+		providers: make(map[string]*OIDCProvider, len(cfg.OIDCProviders)),
+		stateTTL:  cfg.OIDCStateTTL,
+	}
+
+	for _, providerCfg := range cfg.OIDCProviders {
+		if providerCfg.Issuer == "" || providerCfg.ClientID == "" || providerCfg.RedirectURL == "" {
+			logger.Warn().Str("provider", providerCfg.Name).Msg("Skipping incompletely configured OIDC provider")
+			continue
+		}
+		c.providers[providerCfg.Name] = NewOIDCProvider(providerCfg, nil)
+		c.names = append(c.names, providerCfg.Name)
+	}
+
+	return c
+}
+
+func (c *OIDCConnector) provider(name string) (*OIDCProvider, error) {
+	if c == nil {
+		return nil, status.Error(codes.NotFound, "unknown identity provider")
+	}
+	p, ok := c.providers[name]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "unknown identity provider")
+	}
+	return p, nil
+}
+
+// RunCleanup periodically removes abandoned login flows
+func (c *OIDCConnector) RunCleanup(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.repo.DeleteExpiredLoginStates(ctx); err != nil {
+				logger.Error().Err(err).Msg("Failed to cleanup OIDC login states")
+			}
+		}
+	}
+}
+
+// ListOIDCProviders returns the names of the providers users can sign in with
+func (s *Service) ListOIDCProviders(ctx context.Context, req *proto.ListOIDCProvidersRequest) (*proto.ListOIDCProvidersResponse, error) {
+	if s.oidc == nil {
+		return &proto.ListOIDCProvidersResponse{}, nil
+	}
+	return &proto.ListOIDCProvidersResponse{Providers: s.oidc.names}, nil
+}
+
+// StartOIDCLogin begins an authorization code flow. With a session token the
+// flow links the provider to that user instead of signing in.
+func (s *Service) StartOIDCLogin(ctx context.Context, req *proto.StartOIDCLoginRequest) (*proto.StartOIDCLoginResponse, error) {
+	provider, err := s.oidc.provider(req.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	var linkUserID *int64
+	if req.Token != "" {
+		user, err := s.authenticatedUser(ctx, req.Token)
+		if err != nil {
+			return nil, err
+		}
+		linkUserID = &user.ID
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to generate OIDC state")
+		return nil, status.Error(codes.Internal, "failed to start sign-in")
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to generate OIDC nonce")
+		return nil, status.Error(codes.Internal, "failed to start sign-in")
+	}
+	codeVerifier, err := randomToken()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to generate PKCE verifier")
+		return nil, status.Error(codes.Internal, "failed to start sign-in")
+	}
+
+	authURL, err := provider.AuthorizationURL(ctx, state, nonce, codeVerifier)
+	if err != nil {
+		logger.Error().Err(err).Str("provider", req.Provider).Msg("Failed to build OIDC authorization URL")
+		return nil, status.Error(codes.Unavailable, "identity provider is unavailable")
+	}
+
+	err = s.oidc.repo.CreateLoginState(ctx, hashToken(state), &storage.OIDCLoginState{
+		Provider:     req.Provider,
+		CodeVerifier: codeVerifier,
+		Nonce:        nonce,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(s.oidc.stateTTL),
+	})
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to store OIDC login state")
+		return nil, status.Error(codes.Internal, "failed to start sign-in")
+	}
+
+	return &proto.StartOIDCLoginResponse{
+		AuthorizationUrl: authURL,
+		State:            state,
+	}, nil
+}
+
+// CompleteOIDCLogin redeems the authorization code. Known identities sign in
+// to their user, unknown ones get a new account. Existing accounts are never
+// linked implicitly, not even by a matching email address.
+func (s *Service) CompleteOIDCLogin(ctx context.Context, req *proto.CompleteOIDCLoginRequest) (*proto.CompleteOIDCLoginResponse, error) {
+	provider, err := s.oidc.provider(req.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := s.oidc.repo.ConsumeLoginState(ctx, hashToken(req.State))
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to load OIDC login state")
+		return nil, status.Error(codes.Internal, "failed to complete sign-in")
+	}
+	if state == nil || state.Provider != req.Provider {
+		return nil, status.Error(codes.InvalidArgument, "invalid or expired sign-in state")
+	}
+
+	claims, err := provider.Exchange(ctx, req.Code, state.CodeVerifier, state.Nonce)
+	if err != nil {
+		logger.Warn().Err(err).Str("provider", req.Provider).Msg("OIDC code exchange failed")
+		return nil, status.Error(codes.Unauthenticated, "identity provider sign-in failed")
+	}
+
+	if state.LinkUserID != nil {
+		return s.linkIdentity(ctx, req, *state.LinkUserID, claims)
+	}
+
+	identity, err := s.oidc.repo.GetIdentity(ctx, req.Provider, claims.Subject)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to look up identity")
+		return nil, status.Error(codes.Internal, "failed to complete sign-in")
+	}
+
+	var user *storage.User
+	if identity != nil {
+		user, err = s.userRepo.GetUserByID(ctx, identity.UserID)
+		if err != nil {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+	} else {
+		user, err = s.createOIDCUser(ctx, req.Provider, claims)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	login, err := s.completeLogin(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &proto.CompleteOIDCLoginResponse{Login: login}, nil
+}
+
+// ListIdentities returns the providers linked to the authenticated user
+func (s *Service) ListIdentities(ctx context.Context, req *proto.ListIdentitiesRequest) (*proto.ListIdentitiesResponse, error) {
+	user, err := s.authenticatedUser(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := s.oidc.repo.GetIdentitiesByUserID(ctx, user.ID)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to list identities")
+		return nil, status.Error(codes.Internal, "failed to list identities")
+	}
+
+	resp := &proto.ListIdentitiesResponse{HasPassword: user.PasswordHash != ""}
+	for _, identity := range identities {
+		resp.Identities = append(resp.Identities, &proto.Identity{
+			Provider:  identity.Provider,
+			Email:     identity.Email,
+			CreatedAt: identity.CreatedAt.Unix(),
+		})
+	}
+
+	return resp, nil
+}
+
+// UnlinkIdentity removes a linked provider. The last sign-in method of an
+// account without a password cannot be removed.
+func (s *Service) UnlinkIdentity(ctx context.Context, req *proto.UnlinkIdentityRequest) (*proto.UnlinkIdentityResponse, error) {
+	user, err := s.authenticatedUser(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.PasswordHash == "" {
+		identities, err := s.oidc.repo.GetIdentitiesByUserID(ctx, user.ID)
+		if err != nil {
+			logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to list identities")
+			return nil, status.Error(codes.Internal, "failed to unlink identity")
+		}
+		if len(identities) <= 1 {
+			return nil, status.Error(codes.FailedPrecondition, "set a password before removing your only sign-in method")
+		}
+	}
+
+	removed, err := s.oidc.repo.UnlinkIdentity(ctx, user.ID, req.Provider)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to unlink identity")
+		return nil, status.Error(codes.Internal, "failed to unlink identity")
+	}
+	if !removed {
+		return nil, status.Error(codes.NotFound, "identity not linked")
+	}
+
+	logger.Info().Int64("user_id", user.ID).Str("provider", req.Provider).Msg("Identity unlinked")
+	return &proto.UnlinkIdentityResponse{}, nil
+}
+
+// linkIdentity finishes a linking flow. The caller must present a session of
+// the same user that started it, so a flow cannot be completed in another
+// user's browser.
+func (s *Service) linkIdentity(ctx context.Context, req *proto.CompleteOIDCLoginRequest, userID int64, claims *OIDCClaims) (*proto.CompleteOIDCLoginResponse, error) {
+	user, err := s.authenticatedUser(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+	if user.ID != userID {
+		return nil, status.Error(codes.PermissionDenied, "sign-in state belongs to another user")
+	}
+
+	existing, err := s.oidc.repo.GetIdentity(ctx, req.Provider, claims.Subject)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to look up identity")
+		return nil, status.Error(codes.Internal, "failed to link identity")
+	}
+	if existing != nil {
+		if existing.UserID == user.ID {
+			return &proto.CompleteOIDCLoginResponse{Linked: true}, nil
+		}
+		return nil, status.Error(codes.AlreadyExists, storage.ErrIdentityTaken.Error())
+	}
+
+	if err := s.oidc.repo.LinkIdentity(ctx, user.ID, req.Provider, claims.Subject, claims.Email); err != nil {
+		if errors.Is(err, storage.ErrIdentityTaken) {
+			return nil, status.Error(codes.AlreadyExists, "another identity of this provider is already linked")
+		}
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to link identity")
+		return nil, status.Error(codes.Internal, "failed to link identity")
+	}
+
+	logger.Info().Int64("user_id", user.ID).Str("provider", req.Provider).Msg("Identity linked")
+	return &proto.CompleteOIDCLoginResponse{Linked: true}, nil
+}
+
+// createOIDCUser registers a password-less account for a new identity
+func (s *Service) createOIDCUser(ctx context.Context, provider string, claims *OIDCClaims) (*storage.User, error) {
+	if claims.Email == "" {
+		return nil, status.Error(codes.FailedPrecondition, "the identity provider did not share an email address")
+	}
+
+	if _, err := s.userRepo.GetUserByEmail(ctx, claims.Email); err == nil {
+		return nil, status.Error(codes.FailedPrecondition,
+			"an account with this email already exists, sign in to it and link the provider from your account settings")
+	}
+
+	username, err := s.availableUsername(ctx, claims)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to choose username")
+		return nil, status.Error(codes.Internal, "failed to create user")
+	}
+
+	user, err := s.oidc.repo.CreateUserWithIdentity(ctx, username, claims.Email, claims.EmailVerified, provider, claims.Subject)
+	if err != nil {
+		logger.Error().Err(err).Str("provider", provider).Msg("Failed to create user for identity")
+		return nil, status.Error(codes.Internal, "failed to create user")
+	}
+
+	logger.Info().Int64("user_id", user.ID).Str("provider", provider).Msg("User created from external identity")
+	return user, nil
+}
+
+// availableUsername derives a username from the identity claims and adds a
+// numeric suffix until it is free
+func (s *Service) availableUsername(ctx context.Context, claims *OIDCClaims) (string, error) {
+	base := sanitizeUsername(claims.PreferredUsername)
+	if base == "" {
+		local, _, _ := strings.Cut(claims.Email, "@")
+		base = sanitizeUsername(local)
+	}
+	if len(base) < 3 {
+		base = "user" + base
+	}
+
+	candidate := base
+	for i := 0; i < 10; i++ {
+		_, err := s.userRepo.GetUserByUsername(ctx, candidate)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		n, err := rand.Int(rand.Reader, big.NewInt(10000))
+		if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s%d", base, n.Int64())
+	}
+
+	return "", errors.New("no free username found")
+}
+
+func sanitizeUsername(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_') {
+			b.WriteRune(r)
+		}
+		if b.Len() >= maxGeneratedUsernameLength-4 {
+			break
+		}
+	}
+	return b.String()
+}
+
+// randomToken returns 32 random bytes encoded as base64url
+func randomToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
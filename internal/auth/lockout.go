@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/Ryan-Gosusluging/forum/pkg/mailer"
+	"github.com/Ryan-Gosusluging/forum/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	RoleAdmin = "admin"
+	// RoleModerator may moderate the chat
+	RoleModerator = "moderator"
+)
+
+// IsModerator reports whether the role may moderate the chat
+func IsModerator(role string) bool {
+	return role == RoleAdmin || role == RoleModerator
+}
+
+// loginFailed records a failed sign-in and reports any lockout it triggered
+func (s *Service) loginFailed(ctx context.Context, username, ip string, user *storage.User) {
+	logger.Info().Str("username", username).Str("ip", ip).Msg("Failed sign-in attempt")
+
+	for _, l := range s.throttler.RecordFailure(ctx, username, ip) {
+		logger.Warn().
+			Str("key", l.Key).
+			Str("username", username).
+			Str("ip", ip).
+			Time("locked_until", l.Until).
+			Msg("Sign-in locked out after repeated failures")
+
+		if user != nil && strings.HasPrefix(l.Key, "user:") {
+			s.sendLockoutNotice(ctx, user, l.Until)
+		}
+	}
+}
+
+func (s *Service) sendLockoutNotice(ctx context.Context, user *storage.User, until time.Time) {
+	err := s.send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Your account has been temporarily locked",
+		Body: fmt.Sprintf("Hi %s,\n\nWe noticed several failed attempts to sign in to your forum account, "+
+			"so sign-in has been locked until %s.\n\n"+
+			"If this was not you, consider resetting your password once the lock expires.\n",
+			user.Username, until.UTC().Format(time.RFC1123)),
+	})
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to send lockout notification")
+	}
+}
+
+// UnlockAccount lets an admin clear the failed attempts and lockout of a username
+func (s *Service) UnlockAccount(ctx context.Context, req *proto.UnlockAccountRequest) (*proto.UnlockAccountResponse, error) {
+	admin, err := s.requireAdmin(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.userRepo.GetUserByUsername(ctx, req.Username); err != nil {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+
+	if err := s.throttler.Unlock(ctx, req.Username); err != nil {
+		logger.Error().Err(err).Str("username", req.Username).Msg("Failed to unlock account")
+		return nil, status.Error(codes.Internal, "failed to unlock account")
+	}
+
+	logger.Warn().
+		Str("username", req.Username).
+		Int64("admin_id", admin.UserId).
+		Msg("Account unlocked by admin")
+	return &proto.UnlockAccountResponse{}, nil
+}
+
+// requireAdmin validates the token and checks that it belongs to an admin
+func (s *Service) requireAdmin(ctx context.Context, token string) (*proto.ValidateTokenResponse, error) {
+	claims, err := s.validateSession(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Role != RoleAdmin {
+		return nil, status.Error(codes.PermissionDenied, "admin role required")
+	}
+
+	return claims, nil
+}
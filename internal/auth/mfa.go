@@ -0,0 +1,478 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/Ryan-Gosusluging/forum/pkg/proto"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// purposeMFAChallenge marks a token proving the password step of a login
+	purposeMFAChallenge = "mfa"
+	// purposeMFAEnroll marks a restricted token that can only enrol MFA
+	purposeMFAEnroll = "mfa_enroll"
+
+	recoveryCodeCount    = 10
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// completeLogin finishes a sign-in whose first factor was accepted. Users
+// with MFA get a challenge token, users whose role requires MFA but who have
+// not enrolled get an enrolment-only token, everyone else gets a session.
+func (s *Service) completeLogin(ctx context.Context, user *storage.User) (*proto.LoginResponse, error) {
+	mfa, err := s.mfaRepo.GetMFA(ctx, user.ID)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to load MFA settings")
+		return nil, status.Error(codes.Internal, "failed to sign in")
+	}
+
+	if mfa != nil && mfa.Enabled {
+		token, err := s.issuePurposeToken(user, purposeMFAChallenge)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to generate MFA challenge token")
+			return nil, status.Error(codes.Internal, "failed to generate token")
+		}
+
+		return &proto.LoginResponse{
+			UserId:      user.ID,
+			Username:    user.Username,
+			MfaRequired: true,
+			MfaToken:    token,
+		}, nil
+	}
+
+	s.throttler.RecordSuccess(ctx, user.Username)
+
+	if s.mfaRequired(user.Role) {
+		token, err := s.issuePurposeToken(user, purposeMFAEnroll)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to generate MFA enrolment token")
+			return nil, status.Error(codes.Internal, "failed to generate token")
+		}
+
+		logger.Info().Int64("user_id", user.ID).Msg("Sign-in restricted until two-factor authentication is enabled")
+		return &proto.LoginResponse{
+			Token:                 token,
+			UserId:                user.ID,
+			Username:              user.Username,
+			Role:                  user.Role,
+			MfaEnrollmentRequired: true,
+		}, nil
+	}
+
+	return s.sessionResponse(user)
+}
+
+// VerifyMFA completes a two-step login with a TOTP or recovery code
+func (s *Service) VerifyMFA(ctx context.Context, req *proto.VerifyMFARequest) (*proto.LoginResponse, error) {
+	userID, err := s.parsePurposeToken(ctx, req.MfaToken, purposeMFAChallenge)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid or expired MFA token")
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid or expired MFA token")
+	}
+
+	// Code guessing counts against the same limits as password guessing
+	ip := clientIP(ctx, req.ClientIp)
+	if err := s.throttler.Check(ctx, user.Username, ip); err != nil {
+		return nil, err
+	}
+
+	ok, err := s.verifySecondFactor(ctx, user.ID, req.Code, true)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to verify MFA code")
+		return nil, status.Error(codes.Internal, "failed to verify code")
+	}
+	if !ok {
+		s.loginFailed(ctx, user.Username, ip, user)
+		return nil, status.Error(codes.Unauthenticated, "invalid code")
+	}
+	s.throttler.RecordSuccess(ctx, user.Username)
+
+	return s.sessionResponse(user)
+}
+
+// EnrollMFA starts enrolment by generating a new secret. It stays inactive
+// until confirmed with a code, so a half-finished enrolment cannot lock the
+// user out.
+func (s *Service) EnrollMFA(ctx context.Context, req *proto.EnrollMFARequest) (*proto.EnrollMFAResponse, error) {
+	user, _, err := s.authenticateForEnrolment(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, err := s.mfaRepo.GetMFA(ctx, user.ID)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to load MFA settings")
+		return nil, status.Error(codes.Internal, "failed to enrol")
+	}
+	if mfa != nil && mfa.Enabled {
+		return nil, status.Error(codes.FailedPrecondition, "two-factor authentication is already enabled")
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to generate TOTP secret")
+		return nil, status.Error(codes.Internal, "failed to enrol")
+	}
+
+	sealed, err := s.secrets.Seal(secret)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to encrypt TOTP secret")
+		return nil, status.Error(codes.Internal, "failed to enrol")
+	}
+
+	if err := s.mfaRepo.SavePendingMFA(ctx, user.ID, sealed); err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to store TOTP secret")
+		return nil, status.Error(codes.Internal, "failed to enrol")
+	}
+
+	return &proto.EnrollMFAResponse{
+		Secret:          secret,
+		ProvisioningUri: provisioningURI(s.cfg.MFAIssuer, user.Username, secret),
+	}, nil
+}
+
+// ConfirmMFA activates a pending enrolment and returns one-time recovery codes
+func (s *Service) ConfirmMFA(ctx context.Context, req *proto.ConfirmMFARequest) (*proto.ConfirmMFAResponse, error) {
+	user, restricted, err := s.authenticateForEnrolment(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, err := s.mfaRepo.GetMFA(ctx, user.ID)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to load MFA settings")
+		return nil, status.Error(codes.Internal, "failed to confirm enrolment")
+	}
+	if mfa == nil {
+		return nil, status.Error(codes.FailedPrecondition, "two-factor enrolment has not been started")
+	}
+	if mfa.Enabled {
+		return nil, status.Error(codes.FailedPrecondition, "two-factor authentication is already enabled")
+	}
+
+	secret, err := s.secrets.Open(mfa.SecretCiphertext)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to decrypt TOTP secret")
+		return nil, status.Error(codes.Internal, "failed to confirm enrolment")
+	}
+
+	step, ok := validateTOTP(secret, req.Code, time.Now())
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "invalid code")
+	}
+
+	recoveryCodes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to generate recovery codes")
+		return nil, status.Error(codes.Internal, "failed to confirm enrolment")
+	}
+
+	if err := s.mfaRepo.EnableMFA(ctx, user.ID, step, hashes); err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to enable MFA")
+		return nil, status.Error(codes.Internal, "failed to confirm enrolment")
+	}
+
+	resp := &proto.ConfirmMFAResponse{RecoveryCodes: recoveryCodes}
+
+	// An enrolment-only token is exchanged for a full session once MFA is on
+	if restricted {
+		token, err := s.issueToken(user)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to generate token")
+			return nil, status.Error(codes.Internal, "failed to generate token")
+		}
+		resp.Token = token
+	}
+
+	logger.Info().Int64("user_id", user.ID).Msg("Two-factor authentication enabled")
+	return resp, nil
+}
+
+// DisableMFA turns two-factor authentication off after checking a current code
+func (s *Service) DisableMFA(ctx context.Context, req *proto.DisableMFARequest) (*proto.DisableMFAResponse, error) {
+	user, err := s.authenticatedUser(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.mfaRequired(user.Role) {
+		return nil, status.Error(codes.FailedPrecondition, "two-factor authentication is required for your role")
+	}
+
+	// Like VerifyMFA, so that a hijacked session cannot guess codes
+	ip := clientIP(ctx, req.ClientIp)
+	if err := s.throttler.Check(ctx, user.Username, ip); err != nil {
+		return nil, err
+	}
+
+	ok, err := s.verifySecondFactor(ctx, user.ID, req.Code, true)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to verify MFA code")
+		return nil, status.Error(codes.Internal, "failed to disable two-factor authentication")
+	}
+	if !ok {
+		s.loginFailed(ctx, user.Username, ip, user)
+		return nil, status.Error(codes.InvalidArgument, "invalid code")
+	}
+	s.throttler.RecordSuccess(ctx, user.Username)
+
+	if err := s.mfaRepo.DisableMFA(ctx, user.ID); err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to disable MFA")
+		return nil, status.Error(codes.Internal, "failed to disable two-factor authentication")
+	}
+
+	logger.Info().Int64("user_id", user.ID).Msg("Two-factor authentication disabled")
+	return &proto.DisableMFAResponse{}, nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a TOTP code
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, req *proto.RegenerateRecoveryCodesRequest) (*proto.ConfirmMFAResponse, error) {
+	user, err := s.authenticatedUser(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Throttled like DisableMFA
+	ip := clientIP(ctx, req.ClientIp)
+	if err := s.throttler.Check(ctx, user.Username, ip); err != nil {
+		return nil, err
+	}
+
+	ok, err := s.verifySecondFactor(ctx, user.ID, req.Code, false)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to verify MFA code")
+		return nil, status.Error(codes.Internal, "failed to regenerate recovery codes")
+	}
+	if !ok {
+		s.loginFailed(ctx, user.Username, ip, user)
+		return nil, status.Error(codes.InvalidArgument, "invalid code")
+	}
+	s.throttler.RecordSuccess(ctx, user.Username)
+
+	recoveryCodes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to generate recovery codes")
+		return nil, status.Error(codes.Internal, "failed to regenerate recovery codes")
+	}
+
+	if err := s.mfaRepo.ReplaceRecoveryCodes(ctx, user.ID, hashes); err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to store recovery codes")
+		return nil, status.Error(codes.Internal, "failed to regenerate recovery codes")
+	}
+
+	logger.Info().Int64("user_id", user.ID).Msg("Recovery codes regenerated")
+	return &proto.ConfirmMFAResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+// GetMFAStatus reports whether two-factor authentication is enabled and how
+// many recovery codes are left, so users can regenerate them before they run out
+func (s *Service) GetMFAStatus(ctx context.Context, req *proto.GetMFAStatusRequest) (*proto.GetMFAStatusResponse, error) {
+	user, err := s.authenticatedUser(ctx, req.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, err := s.mfaRepo.GetMFA(ctx, user.ID)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to load MFA settings")
+		return nil, status.Error(codes.Internal, "failed to get two-factor authentication status")
+	}
+
+	resp := &proto.GetMFAStatusResponse{
+		Enabled:  mfa != nil && mfa.Enabled,
+		Required: s.mfaRequired(user.Role),
+	}
+	if resp.Enabled {
+		remaining, err := s.mfaRepo.CountRecoveryCodes(ctx, user.ID)
+		if err != nil {
+			logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to count recovery codes")
+			return nil, status.Error(codes.Internal, "failed to get two-factor authentication status")
+		}
+		resp.RecoveryCodesRemaining = int32(remaining)
+	}
+
+	return resp, nil
+}
+
+// verifySecondFactor checks a TOTP code, or a recovery code when allowed.
+// It reports false without an error for wrong, reused or malformed codes and
+// when the user has no active enrolment.
+func (s *Service) verifySecondFactor(ctx context.Context, userID int64, code string, allowRecovery bool) (bool, error) {
+	mfa, err := s.mfaRepo.GetMFA(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if mfa == nil || !mfa.Enabled {
+		return false, nil
+	}
+
+	code = strings.TrimSpace(code)
+	if len(code) > totpDigits {
+		if !allowRecovery {
+			return false, nil
+		}
+		return s.mfaRepo.ConsumeRecoveryCode(ctx, userID, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	secret, err := s.secrets.Open(mfa.SecretCiphertext)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := validateTOTP(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	// Each code is accepted once, even within its validity window
+	return s.mfaRepo.UseStep(ctx, userID, step)
+}
+
+func (s *Service) mfaRequired(role string) bool {
+	for _, required := range s.cfg.MFARequiredRoles {
+		if role == required {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionResponse issues a full session token for the user
+func (s *Service) sessionResponse(user *storage.User) (*proto.LoginResponse, error) {
+	tokenString, err := s.issueToken(user)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to generate token")
+		return nil, errors.New("failed to generate token")
+	}
+
+	return &proto.LoginResponse{
+		Token:    tokenString,
+		UserId:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+	}, nil
+}
+
+// issuePurposeToken signs a short-lived token that is only accepted for the
+// given purpose and rejected by ValidateToken
+func (s *Service) issuePurposeToken(user *storage.User, purpose string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": user.ID,
+		"purpose": purpose,
+		"ver":     user.TokenVersion,
+		"exp":     time.Now().Add(s.cfg.MFAChallengeTTL).Unix(),
+	})
+
+	return token.SignedString([]byte(s.cfg.JWTSecret))
+}
+
+// parsePurposeToken validates a purpose token and returns its user ID
+func (s *Service) parsePurposeToken(ctx context.Context, tokenString, purpose string) (int64, error) {
+	claims, err := s.parseClaims(tokenString)
+	if err != nil {
+		return 0, err
+	}
+
+	if p, _ := claims["purpose"].(string); p != purpose {
+		return 0, errors.New("unexpected token purpose")
+	}
+	if !s.tokenVersionCurrent(ctx, claims) {
+		return 0, errors.New("token has been revoked")
+	}
+
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, errors.New("invalid token claims")
+	}
+	return int64(userID), nil
+}
+
+// authenticateForEnrolment accepts a session token or an enrolment-only
+// token and reports whether the latter was used
+func (s *Service) authenticateForEnrolment(ctx context.Context, token string) (*storage.User, bool, error) {
+	if userID, err := s.parsePurposeToken(ctx, token, purposeMFAEnroll); err == nil {
+		user, err := s.userRepo.GetUserByID(ctx, userID)
+		if err != nil {
+			return nil, false, status.Error(codes.NotFound, "user not found")
+		}
+		return user, true, nil
+	}
+
+	user, err := s.authenticatedUser(ctx, token)
+	return user, false, err
+}
+
+// authenticatedUser validates a session token and loads its user
+func (s *Service) authenticatedUser(ctx context.Context, token string) (*storage.User, error) {
+	claims, err := s.validateSession(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetUserByID(ctx, claims.UserId)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	return user, nil
+}
+
+// generateRecoveryCodes returns the codes to show the user and their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	recoveryCodes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	raw := make([]byte, 10)
+	for i := 0; i < recoveryCodeCount; i++ {
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+
+		var b strings.Builder
+		for j, c := range raw {
+			if j == 5 {
+				b.WriteByte('-')
+			}
+			b.WriteByte(recoveryCodeAlphabet[int(c)%len(recoveryCodeAlphabet)])
+		}
+
+		code := b.String()
+		recoveryCodes = append(recoveryCodes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	return recoveryCodes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// validateSession validates a token and rejects personal access tokens, which
+// must not be usable to manage the account they belong to
+func (s *Service) validateSession(ctx context.Context, token string) (*proto.ValidateTokenResponse, error) {
+	claims, err := s.ValidateToken(ctx, &proto.ValidateTokenRequest{Token: token})
+	if err != nil || !claims.Valid {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	if claims.IsApiToken {
+		return nil, status.Error(codes.PermissionDenied, "API tokens cannot be used to manage the account")
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/pkg/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID     = "forum"
+	testClientSecret = "secret"
+	testRedirectURL  = "https://forum.example/api/auth/oidc/test/callback"
+	testCode         = "auth-code"
+	testKeyID        = "key-1"
+)
+
+// testIssuer is an OpenID Connect provider that signs ID tokens with an RSA
+// key and redeems a single authorization code
+type testIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	// challenge is the PKCE challenge the authorization code was issued for
+	challenge string
+	// claims and kid make up the next ID token
+	claims jwt.MapClaims
+	kid    string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	issuer := &testIssuer{key: key, kid: testKeyID}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", issuer.handleDiscovery)
+	mux.HandleFunc("/token", issuer.handleToken)
+	mux.HandleFunc("/jwks", issuer.handleJWKS)
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+func (i *testIssuer) provider() *OIDCProvider {
+	return NewOIDCProvider(config.OIDCProviderConfig{
+		Name:         "test",
+		Issuer:       i.server.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+	}, i.server.Client())
+}
+
+// validClaims returns the claims of an ID token the forum should accept
+func (i *testIssuer) validClaims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":                i.server.URL,
+		"sub":                "user-42",
+		"aud":                testClientID,
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              nonce,
+		"email":              "alice@example.com",
+		"email_verified":     "true",
+		"preferred_username": "alice",
+		"name":               "Alice",
+	}
+}
+
+func (i *testIssuer) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 i.server.URL,
+		"authorization_endpoint": i.server.URL + "/authorize",
+		"token_endpoint":         i.server.URL + "/token",
+		"jwks_uri":               i.server.URL + "/jwks",
+	})
+}
+
+func (i *testIssuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	clientID, clientSecret, _ := r.BasicAuth()
+	if r.Method != http.MethodPost || clientID != testClientID || clientSecret != testClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" ||
+		r.PostFormValue("code") != testCode ||
+		r.PostFormValue("redirect_uri") != testRedirectURL ||
+		pkceChallenge(r.PostFormValue("code_verifier")) != i.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, i.claims)
+	token.Header["kid"] = i.kid
+	signed, err := token.SignedString(i.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"id_token":     signed,
+	})
+}
+
+func (i *testIssuer) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testKeyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(i.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(i.key.E)).Bytes()),
+		}},
+	})
+}
+
+func TestOIDCAuthorizationURL(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	authURL, err := issuer.provider().AuthorizationURL(context.Background(), "state-1", "nonce-1", "verifier-1")
+	if err != nil {
+		t.Fatalf("AuthorizationURL: %v", err)
+	}
+
+	if !strings.HasPrefix(authURL, issuer.server.URL+"/authorize?") {
+		t.Fatalf("URL %q does not use the discovered authorization endpoint", authURL)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parse URL: %v", err)
+	}
+
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"scope":                 "openid email profile",
+		"state":                 "state-1",
+		"nonce":                 "nonce-1",
+		"code_challenge":        pkceChallenge("verifier-1"),
+		"code_challenge_method": "S256",
+	}
+	query := parsed.Query()
+	for name, value := range want {
+		if got := query.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	provider := issuer.provider()
+	provider.cfg.Issuer = issuer.server.URL + "/other"
+
+	if _, err := provider.AuthorizationURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Fatal("expected an error for a discovery document of another issuer")
+	}
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// Example from RFC 7636, appendix B
+	got := pkceChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Fatalf("pkceChallenge = %q, want %q", got, want)
+	}
+}
+
+func TestOIDCExchange(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.challenge = pkceChallenge("verifier")
+	issuer.claims = issuer.validClaims("nonce")
+
+	claims, err := issuer.provider().Exchange(context.Background(), testCode, "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	want := OIDCClaims{
+		Subject:           "user-42",
+		Email:             "alice@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "alice",
+		Name:              "Alice",
+	}
+	if *claims != want {
+		t.Fatalf("claims = %+v, want %+v", *claims, want)
+	}
+}
+
+func TestOIDCExchangeRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		verifier string
+		kid      string
+		modify   func(claims jwt.MapClaims)
+		wantErr  string
+	}{
+		{
+			name:     "wrong code verifier",
+			verifier: "other-verifier",
+			wantErr:  "token endpoint returned 400",
+		},
+		{
+			name:    "wrong nonce",
+			modify:  func(claims jwt.MapClaims) { claims["nonce"] = "other-nonce" },
+			wantErr: "nonce mismatch",
+		},
+		{
+			name:    "missing nonce",
+			modify:  func(claims jwt.MapClaims) { delete(claims, "nonce") },
+			wantErr: "nonce mismatch",
+		},
+		{
+			name:    "wrong audience",
+			modify:  func(claims jwt.MapClaims) { claims["aud"] = "other-client" },
+			wantErr: "invalid audience",
+		},
+		{
+			name:    "several audiences without azp",
+			modify:  func(claims jwt.MapClaims) { claims["aud"] = []string{testClientID, "other-client"} },
+			wantErr: "issued to another client",
+		},
+		{
+			name: "several audiences with another azp",
+			modify: func(claims jwt.MapClaims) {
+				claims["aud"] = []string{testClientID, "other-client"}
+				claims["azp"] = "other-client"
+			},
+			wantErr: "issued to another client",
+		},
+		{
+			name:    "wrong issuer",
+			modify:  func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example" },
+			wantErr: "invalid issuer",
+		},
+		{
+			name:    "expired",
+			modify:  func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+			wantErr: "token is expired",
+		},
+		{
+			name:    "missing subject",
+			modify:  func(claims jwt.MapClaims) { delete(claims, "sub") },
+			wantErr: "no subject",
+		},
+		{
+			name:    "unknown key ID",
+			kid:     "key-2",
+			wantErr: "unknown signing key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuer := newTestIssuer(t)
+			issuer.challenge = pkceChallenge("verifier")
+			issuer.claims = issuer.validClaims("nonce")
+			if tt.modify != nil {
+				tt.modify(issuer.claims)
+			}
+			if tt.kid != "" {
+				issuer.kid = tt.kid
+			}
+			verifier := "verifier"
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+
+			claims, err := issuer.provider().Exchange(context.Background(), testCode, verifier, "nonce")
+			if err == nil {
+				t.Fatalf("Exchange accepted the token: %+v", claims)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %q, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOIDCExchangeAcceptsAuthorizedParty(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.challenge = pkceChallenge("verifier")
+	issuer.claims = issuer.validClaims("nonce")
+	issuer.claims["aud"] = []string{testClientID, "other-client"}
+	issuer.claims["azp"] = testClientID
+
+	if _, err := issuer.provider().Exchange(context.Background(), testCode, "verifier", "nonce"); err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+}
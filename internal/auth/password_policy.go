@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/Ryan-Gosusluging/forum/pkg/config"
+)
+
+// commonPasswords is a small built-in blocklist used even when no list file is configured
+var commonPasswords = []string{
+	"123456", "123456789", "12345678", "1234567890", "qwerty", "qwerty123",
+	"password", "password1", "password123", "111111", "123123", "abc123",
+	"iloveyou", "admin", "admin123", "welcome", "welcome1", "letmein",
+	"monkey", "dragon", "football", "baseball", "sunshine", "princess",
+	"qwertyuiop", "1q2w3e4r", "1q2w3e4r5t", "zaq12wsx", "passw0rd", "p@ssw0rd",
+	"changeme", "trustno1", "superman", "starwars", "master", "whatever",
+	"йцукен", "пароль", "qwerty12345", "forum", "forum123",
+}
+
+// PasswordPolicy validates new passwords against length, character class,
+// username similarity and breached password rules.
+type PasswordPolicy struct {
+	minLength  int
+	maxLength  int
+	minClasses int
+	// blocked holds upper-case hex SHA-1 digests of blocked passwords
+	blocked map[string]struct{}
+}
+
+// LoadPasswordPolicy builds the policy from the configuration. The optional
+// blocklist file holds one password per line, or SHA-1 hashes in the
+// "HASH" or "HASH:count" format used by offline breach corpora.
+func LoadPasswordPolicy(cfg *config.Config) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{
+		minLength:  cfg.PasswordMinLength,
+		maxLength:  cfg.PasswordMaxLength,
+		minClasses: cfg.PasswordMinClasses,
+		blocked:    make(map[string]struct{}, len(commonPasswords)),
+	}
+
+	for _, password := range commonPasswords {
+		policy.blocked[sha1Hex(password)] = struct{}{}
+	}
+
+	if cfg.PasswordBlocklistPath != "" {
+		if err := policy.loadBlocklist(cfg.PasswordBlocklistPath); err != nil {
+			return nil, fmt.Errorf("failed to load password blocklist: %w", err)
+		}
+	}
+
+	return policy, nil
+}
+
+func (p *PasswordPolicy) loadBlocklist(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if hash, _, _ := strings.Cut(line, ":"); isSHA1Hex(hash) {
+			p.blocked[strings.ToUpper(hash)] = struct{}{}
+			continue
+		}
+		p.blocked[sha1Hex(line)] = struct{}{}
+	}
+
+	return scanner.Err()
+}
+
+// Validate returns an error describing the first rule the password breaks
+func (p *PasswordPolicy) Validate(username, email, password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.minLength {
+		return fmt.Errorf("password must be at least %d characters long", p.minLength)
+	}
+	if p.maxLength > 0 && length > p.maxLength {
+		return fmt.Errorf("password must be at most %d characters long", p.maxLength)
+	}
+
+	if classes := characterClasses(password); classes < p.minClasses {
+		return fmt.Errorf("password must contain at least %d of: lowercase letters, uppercase letters, digits, symbols", p.minClasses)
+	}
+
+	if similarTo(password, username) {
+		return errors.New("password is too similar to the username")
+	}
+	if local, _, ok := strings.Cut(email, "@"); ok && similarTo(password, local) {
+		return errors.New("password is too similar to the email address")
+	}
+
+	if p.isBlocked(password) {
+		return errors.New("password is too common or has appeared in a data breach")
+	}
+
+	return nil
+}
+
+func (p *PasswordPolicy) isBlocked(password string) bool {
+	if _, ok := p.blocked[sha1Hex(password)]; ok {
+		return true
+	}
+	_, ok := p.blocked[sha1Hex(strings.ToLower(password))]
+	return ok
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	count := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			count++
+		}
+	}
+	return count
+}
+
+// similarTo reports whether the password contains the identifier, is contained
+// in it, or is within a small edit distance of it, ignoring case
+func similarTo(password, identifier string) bool {
+	pw := strings.ToLower(password)
+	id := strings.ToLower(identifier)
+	if utf8.RuneCountInString(id) < 3 {
+		return false
+	}
+
+	if strings.Contains(pw, id) || strings.Contains(id, pw) || strings.Contains(pw, reverse(id)) {
+		return true
+	}
+	return levenshtein(pw, id) <= 2
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func isSHA1Hex(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Ryan-Gosusluging/forum/pkg/config"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	blocklist := filepath.Join(t.TempDir(), "blocklist.txt")
+	content := strings.Join([]string{
+		"# plain passwords and SHA-1 hashes with counts",
+		"Correct-Horse-7x",
+		"",
+		"2088d12adfdd637816fb5632c54593a1ef7a9a2d:42", // Tr0ub4dor&3y
+	}, "\n")
+	if err := os.WriteFile(blocklist, []byte(content), 0o600); err != nil {
+		t.Fatalf("write blocklist: %v", err)
+	}
+
+	policy, err := LoadPasswordPolicy(&config.Config{
+		PasswordMinLength:     10,
+		PasswordMaxLength:     64,
+		PasswordMinClasses:    3,
+		PasswordBlocklistPath: blocklist,
+	})
+	if err != nil {
+		t.Fatalf("LoadPasswordPolicy: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantErr  string // empty means the password is accepted
+	}{
+		{name: "strong", username: "alice", password: "Tr0ub4dor&3x"},
+		{name: "non-ASCII", username: "alice", password: "Пароль-Сильный1"},
+		{name: "short identifiers are not compared", username: "al", password: "Al-Strong-Pass9"},
+		{name: "too short", username: "alice", password: "Sh0rt!", wantErr: "at least 10 characters"},
+		{name: "length counts characters, not bytes", username: "alice", password: "пароль12Ж", wantErr: "at least 10 characters"},
+		{name: "too long", username: "alice", password: strings.Repeat("Aa1!", 17), wantErr: "at most 64 characters"},
+		{name: "too few classes", username: "alice", password: "alllowercase1", wantErr: "at least 3 of"},
+		{name: "contains the username", username: "alice", password: "Alice-2024xyz", wantErr: "similar to the username"},
+		{name: "contains the reversed username", username: "alice", password: "ecila-2024XYZ", wantErr: "similar to the username"},
+		{name: "close to the username", username: "dragonfly42", password: "Dragonfly4!", wantErr: "similar to the username"},
+		{name: "contains the email", username: "alice", password: "Bob.Smith99!", wantErr: "similar to the email address"},
+		{name: "built-in list ignores case", username: "alice", password: "Password123", wantErr: "too common"},
+		{name: "plain password from the file", username: "alice", password: "Correct-Horse-7x", wantErr: "too common"},
+		{name: "hash from the file", username: "alice", password: "Tr0ub4dor&3y", wantErr: "too common"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Validate(tt.username, "bob.smith@example.com", tt.password)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("Validate rejected the password: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("Validate = %v, want an error mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadPasswordPolicyMissingBlocklist(t *testing.T) {
+	_, err := LoadPasswordPolicy(&config.Config{
+		PasswordMinLength:     10,
+		PasswordBlocklistPath: filepath.Join(t.TempDir(), "missing.txt"),
+	})
+	if err == nil {
+		t.Fatal("expected an error for a missing blocklist file")
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "", b: "", want: 0},
+		{a: "abc", b: "", want: 3},
+		{a: "kitten", b: "sitting", want: 3},
+		{a: "forum", b: "forum", want: 0},
+		{a: "пароль", b: "парол", want: 1},
+	}
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/Ryan-Gosusluging/forum/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	maxDisplayNameLength = 64
+	maxBioLength         = 2000
+	maxLocationLength    = 100
+	maxWebsiteLength     = 255
+	maxSignatureLength   = 500
+	maxSignatureLines    = 5
+	maxAvatarURLLength   = 512
+	maxUsersPerLookup    = 100
+)
+
+func (s *Service) GetUser(ctx context.Context, req *proto.GetUserRequest) (*proto.GetUserResponse, error) {
+	var (
+		user *storage.User
+		err  error
+	)
+
+	switch {
+	case req.UserId != 0:
+		user, err = s.userRepo.GetUserByID(ctx, req.UserId)
+	case req.Username != "":
+		user, err = s.userRepo.GetUserByUsername(ctx, req.Username)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "user_id or username is required")
+	}
+
+	if errors.Is(err, storage.ErrUserNotFound) {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get user")
+		return nil, status.Error(codes.Internal, "failed to get user")
+	}
+
+	return &proto.GetUserResponse{User: toProfile(user)}, nil
+}
+
+func (s *Service) GetUsersByIDs(ctx context.Context, req *proto.GetUsersByIDsRequest) (*proto.GetUsersByIDsResponse, error) {
+	if len(req.UserIds) > maxUsersPerLookup {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d users can be requested at once", maxUsersPerLookup)
+	}
+
+	users, err := s.userRepo.GetUsersByIDs(ctx, req.UserIds)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get users")
+		return nil, status.Error(codes.Internal, "failed to get users")
+	}
+
+	profiles := make([]*proto.UserProfile, len(users))
+	for i, user := range users {
+		profiles[i] = toProfile(user)
+	}
+
+	return &proto.GetUsersByIDsResponse{Users: profiles}, nil
+}
+
+func (s *Service) UpdateProfile(ctx context.Context, req *proto.UpdateProfileRequest) (*proto.UpdateProfileResponse, error) {
+	claims, err := s.ValidateToken(ctx, &proto.ValidateTokenRequest{Token: req.Token})
+	if err != nil || !claims.Valid {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if !HasScope(claims, ScopeProfileWrite) {
+		return nil, status.Error(codes.PermissionDenied, ErrMissingScope.Error())
+	}
+
+	update := storage.ProfileUpdate{
+		DisplayName: trimmed(req.DisplayName),
+		Bio:         trimmed(req.Bio),
+		Location:    trimmed(req.Location),
+		Website:     trimmed(req.Website),
+		Signature:   trimmed(req.Signature),
+		AvatarURL:   trimmed(req.AvatarUrl),
+	}
+	if err := validateProfile(update); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	user, err := s.userRepo.UpdateProfile(ctx, claims.UserId, update)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", claims.UserId).Msg("Failed to update profile")
+		return nil, status.Error(codes.Internal, "failed to update profile")
+	}
+
+	return &proto.UpdateProfileResponse{User: toProfile(user)}, nil
+}
+
+func toProfile(user *storage.User) *proto.UserProfile {
+	return &proto.UserProfile{
+		UserId:      user.ID,
+		Username:    user.Username,
+		Role:        user.Role,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		Location:    user.Location,
+		Website:     user.Website,
+		Signature:   user.Signature,
+		AvatarUrl:   user.AvatarURL,
+		CreatedAt:   user.CreatedAt.Unix(),
+	}
+}
+
+func trimmed(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	t := strings.TrimSpace(*s)
+	return &t
+}
+
+func validateProfile(update storage.ProfileUpdate) error {
+	if err := checkLength("display_name", update.DisplayName, maxDisplayNameLength); err != nil {
+		return err
+	}
+	if err := checkLength("bio", update.Bio, maxBioLength); err != nil {
+		return err
+	}
+	if err := checkLength("location", update.Location, maxLocationLength); err != nil {
+		return err
+	}
+	if err := checkLength("signature", update.Signature, maxSignatureLength); err != nil {
+		return err
+	}
+	if update.Signature != nil && strings.Count(*update.Signature, "\n") >= maxSignatureLines {
+		return errors.New("signature is limited to 5 lines")
+	}
+	if err := checkLength("website", update.Website, maxWebsiteLength); err != nil {
+		return err
+	}
+	if update.Website != nil && *update.Website != "" && !isHTTPURL(*update.Website) {
+		return errors.New("website must be an http or https URL")
+	}
+	if err := checkLength("avatar_url", update.AvatarURL, maxAvatarURLLength); err != nil {
+		return err
+	}
+	if update.AvatarURL != nil && *update.AvatarURL != "" && !isAvatarURL(*update.AvatarURL) {
+		return errors.New("avatar_url must be an https URL or a local path")
+	}
+	return nil
+}
+
+func checkLength(field string, value *string, max int) error {
+	if value != nil && utf8.RuneCountInString(*value) > max {
+		return errors.New(field + " is too long")
+	}
+	return nil
+}
+
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isAvatarURL accepts https URLs and paths on this host. Protocol-relative
+// values such as "//evil.example/x.png" point at another host; browsers
+// treat a backslash after the slash the same way.
+func isAvatarURL(raw string) bool {
+	if strings.HasPrefix(raw, "/") {
+		return !strings.HasPrefix(raw, "//") && !strings.HasPrefix(raw, "/\\")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "https" && u.Host != ""
+}
@@ -3,25 +3,53 @@ package auth
 import (
 	"context"
 	"errors"
+	"net/http"
+	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/Ryan-Gosusluging/forum/internal/storage"
 	"github.com/Ryan-Gosusluging/forum/pkg/config"
 	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/Ryan-Gosusluging/forum/pkg/mailer"
 	"github.com/Ryan-Gosusluging/forum/pkg/proto"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type Service struct {
 	proto.UnimplementedAuthServiceServer
-	userRepo *storage.UserRepository
-	cfg      *config.Config
+	userRepo        *storage.UserRepository
+	tokenRepo       *storage.TokenRepository
+	mfaRepo         *storage.MFARepository
+	accessTokenRepo *storage.AccessTokenRepository
+	mailer          mailer.Mailer
+	policy          *PasswordPolicy
+	hasher          *PasswordHasher
+	throttler       *LoginThrottler
+	oidc            *OIDCConnector
+	secrets         *secretBox
+	cfg             *config.Config
 }
 
-func NewService(userRepo *storage.UserRepository, cfg *config.Config) *Service {
+func NewService(userRepo *storage.UserRepository, tokenRepo *storage.TokenRepository, mfaRepo *storage.MFARepository, accessTokenRepo *storage.AccessTokenRepository, mailer mailer.Mailer, policy *PasswordPolicy, throttler *LoginThrottler, oidc *OIDCConnector, cfg *config.Config) *Service {
+	secrets, err := newSecretBox(cfg.MFAEncryptionKey)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to initialize MFA secret encryption")
+	}
+
 	return &Service{
-		userRepo: userRepo,
-		cfg:      cfg,
+		userRepo:        userRepo,
+		tokenRepo:       tokenRepo,
+		mfaRepo:         mfaRepo,
+		accessTokenRepo: accessTokenRepo,
+		mailer:          mailer,
+		policy:          policy,
+		hasher:          NewPasswordHasher(cfg),
+		throttler:       throttler,
+		oidc:            oidc,
+		secrets:         secrets,
+		cfg:             cfg,
 	}
 }
 
@@ -32,13 +60,28 @@ func (s *Service) Register(ctx context.Context, req *proto.RegisterRequest) (*pr
 		return nil, errors.New("username already exists")
 	}
 
+	// Check the password against the policy
+	if err := s.policy.Validate(req.Username, req.Email, req.Password); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	passwordHash, err := s.hasher.Hash(req.Password)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to hash password")
+		return nil, errors.New("failed to create user")
+	}
+
 	// Create new user
-	user, err := s.userRepo.CreateUser(ctx, req.Username, req.Email, req.Password)
+	user, err := s.userRepo.CreateUser(ctx, req.Username, req.Email, passwordHash)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to create user")
 		return nil, errors.New("failed to create user")
 	}
 
+	if err := s.sendEmailVerification(ctx, user); err != nil {
+		logger.Error().Err(err).Int64("user_id", user.ID).Msg("Failed to send verification email")
+	}
+
 	return &proto.RegisterResponse{
 		UserId:   user.ID,
 		Username: user.Username,
@@ -47,58 +90,151 @@ func (s *Service) Register(ctx context.Context, req *proto.RegisterRequest) (*pr
 }
 
 func (s *Service) Login(ctx context.Context, req *proto.LoginRequest) (*proto.LoginResponse, error) {
+	// Refuse attempts while the username or the client IP is throttled
+	ip := clientIP(ctx, req.ClientIp)
+	if err := s.throttler.Check(ctx, req.Username, ip); err != nil {
+		return nil, err
+	}
+
 	// Get user by username
 	user, err := s.userRepo.GetUserByUsername(ctx, req.Username)
 	if err != nil {
+		s.hasher.VerifyDummy(req.Password)
+		s.loginFailed(ctx, req.Username, ip, nil)
 		return nil, errors.New("invalid username or password")
 	}
 
 	// Verify password
-	if err := s.userRepo.VerifyPassword(user.PasswordHash, req.Password); err != nil {
+	needsRehash, err := s.hasher.Verify(user.PasswordHash, req.Password)
+	if err != nil {
+		s.loginFailed(ctx, req.Username, ip, user)
 		return nil, errors.New("invalid username or password")
 	}
 
-	// Generate JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  user.ID,
-		"username": user.Username,
-		"role":     user.Role,
-		"exp":      time.Now().Add(24 * time.Hour).Unix(),
-	})
+	// Transparently upgrade outdated hashes now that the plain password is known
+	if needsRehash {
+		s.rehashPassword(ctx, user.ID, req.Password)
+	}
+
+	return s.completeLogin(ctx, user)
+}
+
+func (s *Service) ValidateToken(ctx context.Context, req *proto.ValidateTokenRequest) (*proto.ValidateTokenResponse, error) {
+	if strings.HasPrefix(req.Token, AccessTokenPrefix) {
+		return s.validateAccessToken(ctx, req.Token), nil
+	}
 
-	tokenString, err := token.SignedString([]byte(s.cfg.JWTSecret))
+	claims, err := s.parseClaims(req.Token)
 	if err != nil {
-		logger.Error().Err(err).Msg("Failed to generate token")
-		return nil, errors.New("failed to generate token")
+		return &proto.ValidateTokenResponse{Valid: false}, nil
 	}
 
-	return &proto.LoginResponse{
-		Token:    tokenString,
-		UserId:   user.ID,
-		Username: user.Username,
-		Role:     user.Role,
+	// MFA challenge and enrolment tokens are not sessions
+	if _, ok := claims["purpose"]; ok {
+		return &proto.ValidateTokenResponse{Valid: false}, nil
+	}
+
+	if !s.tokenVersionCurrent(ctx, claims) {
+		return &proto.ValidateTokenResponse{Valid: false}, nil
+	}
+
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return &proto.ValidateTokenResponse{Valid: false}, nil
+	}
+	username, _ := claims["username"].(string)
+	role, _ := claims["role"].(string)
+
+	return &proto.ValidateTokenResponse{
+		Valid:    true,
+		UserId:   int64(userID),
+		Username: username,
+		Role:     role,
 	}, nil
 }
 
-func (s *Service) ValidateToken(ctx context.Context, req *proto.ValidateTokenRequest) (*proto.ValidateTokenResponse, error) {
-	token, err := jwt.Parse(req.Token, func(token *jwt.Token) (interface{}, error) {
+// parseClaims verifies the signature and expiry of a JWT and returns its claims
+func (s *Service) parseClaims(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
 		}
 		return []byte(s.cfg.JWTSecret), nil
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
 
+func (s *Service) rehashPassword(ctx context.Context, userID int64, password string) {
+	passwordHash, err := s.hasher.Hash(password)
 	if err != nil {
-		return &proto.ValidateTokenResponse{Valid: false}, nil
+		logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to rehash password")
+		return
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return &proto.ValidateTokenResponse{
-			Valid:    true,
-			UserId:   int64(claims["user_id"].(float64)),
-			Username: claims["username"].(string),
-		}, nil
+	if err := s.userRepo.UpdatePasswordHash(ctx, userID, passwordHash); err != nil {
+		logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to store upgraded password hash")
+		return
 	}
 
-	return &proto.ValidateTokenResponse{Valid: false}, nil
+	logger.Info().Int64("user_id", userID).Msg("Password hash upgraded")
+}
+
+// issueToken signs a session JWT for the user
+func (s *Service) issueToken(user *storage.User) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"role":     user.Role,
+		"ver":      user.TokenVersion,
+		"exp":      time.Now().Add(24 * time.Hour).Unix(),
+	})
+
+	return token.SignedString([]byte(s.cfg.JWTSecret))
+}
+
+// tokenVersionCurrent reports whether the token was issued after the user's
+// last password change. Without a user repository the check is skipped.
+func (s *Service) tokenVersionCurrent(ctx context.Context, claims jwt.MapClaims) bool {
+	if s.userRepo == nil {
+		return true
+	}
+
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return false
+	}
+	version, _ := claims["ver"].(float64)
+
+	current, err := s.userRepo.GetTokenVersion(ctx, int64(userID))
+	if err != nil {
+		return false
+	}
+
+	return int(version) == current
+}
+
+// GetUserIDFromRequest validates the token in the Authorization header and
+// returns the ID of the authenticated user.
+func (s *Service) GetUserIDFromRequest(r *http.Request) (int64, error) {
+	resp, err := s.Authenticate(r, "")
+	if err != nil {
+		return 0, err
+	}
+
+	return resp.UserId, nil
+}
+
+// TokenFromRequest extracts the token from the Authorization header,
+// accepting both raw tokens and the "Bearer <token>" form.
+func TokenFromRequest(r *http.Request) string {
+	token := r.Header.Get("Authorization")
+	return strings.TrimSpace(strings.TrimPrefix(token, "Bearer "))
 }
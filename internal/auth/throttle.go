@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/config"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// LoginThrottler slows down and eventually locks out repeated failed sign-ins,
+// tracking failures per username and per client IP.
+type LoginThrottler struct {
+	repo *storage.LoginThrottleRepository
+	cfg  *config.Config
+	now  func() time.Time
+}
+
+func NewLoginThrottler(repo *storage.LoginThrottleRepository, cfg *config.Config) *LoginThrottler {
+	return &LoginThrottler{
+		repo: repo,
+		cfg:  cfg,
+		now:  time.Now,
+	}
+}
+
+// lockout describes a lockout that was just applied
+type lockout struct {
+	Key   string
+	Until time.Time
+}
+
+func userThrottleKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Check returns a ResourceExhausted status when either the username or the IP
+// is currently blocked
+func (t *LoginThrottler) Check(ctx context.Context, username, ip string) error {
+	now := t.now()
+
+	for _, key := range t.keys(username, ip) {
+		throttle, err := t.repo.GetThrottle(ctx, key)
+		if err != nil {
+			// Fail open: a throttle storage problem must not block every sign-in
+			logger.Error().Err(err).Str("key", key).Msg("Failed to read login throttle")
+			continue
+		}
+		if throttle == nil {
+			continue
+		}
+
+		if throttle.LockedUntil != nil && throttle.LockedUntil.After(now) {
+			return retryError("too many failed sign-in attempts, the account is temporarily locked", throttle.LockedUntil.Sub(now))
+		}
+		if throttle.BlockedUntil != nil && throttle.BlockedUntil.After(now) {
+			return retryError("too many failed sign-in attempts, try again later", throttle.BlockedUntil.Sub(now))
+		}
+	}
+
+	return nil
+}
+
+// RecordFailure counts a failed attempt and applies backoff or lockout. It
+// returns the lockouts that were started by this failure.
+func (t *LoginThrottler) RecordFailure(ctx context.Context, username, ip string) []lockout {
+	now := t.now()
+	resetBefore := now.Add(-t.cfg.LoginFailureWindow)
+
+	var started []lockout
+	for _, key := range t.keys(username, ip) {
+		failures, locked, err := t.repo.RecordFailure(ctx, key, now, resetBefore)
+		if err != nil {
+			logger.Error().Err(err).Str("key", key).Msg("Failed to record login failure")
+			continue
+		}
+
+		blockedUntil := t.backoffUntil(now, failures)
+
+		threshold := t.cfg.LoginLockoutThreshold
+		if strings.HasPrefix(key, "ip:") {
+			threshold = t.cfg.LoginIPLockoutThreshold
+		}
+
+		// The counter restarts once a lockout expires, so reaching the
+		// threshold again locks again
+		var lockedUntil *time.Time
+		if threshold > 0 && failures >= threshold && !locked {
+			until := now.Add(t.cfg.LoginLockoutDuration)
+			lockedUntil = &until
+			started = append(started, lockout{Key: key, Until: until})
+		}
+
+		if err := t.repo.SetBlocks(ctx, key, blockedUntil, lockedUntil); err != nil {
+			logger.Error().Err(err).Str("key", key).Msg("Failed to update login throttle")
+		}
+	}
+
+	return started
+}
+
+// RecordSuccess clears the failures of the username. IP failures are kept,
+// so that one valid account cannot be used to reset an IP-wide guessing run.
+func (t *LoginThrottler) RecordSuccess(ctx context.Context, username string) {
+	if err := t.repo.Reset(ctx, userThrottleKey(username)); err != nil {
+		logger.Error().Err(err).Str("username", username).Msg("Failed to reset login throttle")
+	}
+}
+
+// Unlock clears the failures and lockout of a username
+func (t *LoginThrottler) Unlock(ctx context.Context, username string) error {
+	return t.repo.Reset(ctx, userThrottleKey(username))
+}
+
+// RunCleanup periodically removes throttle entries that no longer matter
+func (t *LoginThrottler) RunCleanup(ctx context.Context) {
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.repo.DeleteStale(ctx, t.now().Add(-t.cfg.LoginFailureWindow)); err != nil {
+				logger.Error().Err(err).Msg("Failed to cleanup login throttles")
+			}
+		}
+	}
+}
+
+// backoffUntil returns the exponential backoff deadline after the given number of failures
+func (t *LoginThrottler) backoffUntil(now time.Time, failures int) *time.Time {
+	over := failures - t.cfg.LoginBackoffThreshold
+	if over < 0 {
+		return nil
+	}
+
+	delay := time.Duration(float64(t.cfg.LoginBackoffBase) * math.Pow(2, float64(min(over, 30))))
+	if delay > t.cfg.LoginBackoffMax {
+		delay = t.cfg.LoginBackoffMax
+	}
+
+	until := now.Add(delay)
+	return &until
+}
+
+func (t *LoginThrottler) keys(username, ip string) []string {
+	keys := []string{userThrottleKey(username)}
+	if ip != "" {
+		keys = append(keys, ipThrottleKey(ip))
+	}
+	return keys
+}
+
+// retryError builds a ResourceExhausted status carrying RetryInfo
+func retryError(msg string, retryAfter time.Duration) error {
+	retryAfter = retryAfter.Round(time.Second)
+	if retryAfter < time.Second {
+		retryAfter = time.Second
+	}
+
+	st := status.New(codes.ResourceExhausted, fmt.Sprintf("%s (retry in %s)", msg, retryAfter))
+	detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// clientIP prefers the address forwarded by the calling service and falls
+// back to the address of the gRPC peer
+func clientIP(ctx context.Context, forwarded string) string {
+	if ip := net.ParseIP(strings.TrimSpace(forwarded)); ip != nil {
+		return ip.String()
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err == nil {
+			return host
+		}
+	}
+	return ""
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/config"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// testDatabaseEnv names the variable with the URL of a migrated database.
+// Tests that need Postgres are skipped when it is not set.
+const testDatabaseEnv = "FORUM_TEST_DATABASE_URL"
+
+// testDB connects to the test database or skips the test
+func testDB(t *testing.T) *storage.DB {
+	t.Helper()
+
+	connStr := os.Getenv(testDatabaseEnv)
+	if connStr == "" {
+		t.Skip(testDatabaseEnv + " is not set")
+	}
+
+	db, err := storage.NewDB(connStr)
+	if err != nil {
+		t.Fatalf("connect to test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func testThrottleConfig() *config.Config {
+	return &config.Config{
+		LoginBackoffThreshold:   3,
+		LoginBackoffBase:        time.Second,
+		LoginBackoffMax:         time.Minute,
+		LoginLockoutThreshold:   5,
+		LoginIPLockoutThreshold: 8,
+		LoginLockoutDuration:    30 * time.Minute,
+		LoginFailureWindow:      time.Hour,
+	}
+}
+
+func TestBackoffUntil(t *testing.T) {
+	throttler := &LoginThrottler{cfg: testThrottleConfig()}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		failures int
+		want     time.Duration // 0 means no backoff
+	}{
+		{failures: 1},
+		{failures: 2},
+		{failures: 3, want: time.Second},
+		{failures: 4, want: 2 * time.Second},
+		{failures: 6, want: 8 * time.Second},
+		{failures: 9, want: time.Minute},
+		{failures: 1000, want: time.Minute},
+	}
+	for _, tt := range tests {
+		got := throttler.backoffUntil(now, tt.failures)
+		switch {
+		case tt.want == 0 && got != nil:
+			t.Errorf("backoffUntil after %d failures = %s, want none", tt.failures, got)
+		case tt.want != 0 && (got == nil || got.Sub(now) != tt.want):
+			t.Errorf("backoffUntil after %d failures = %v, want %s from now", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestRetryError(t *testing.T) {
+	tests := []struct {
+		retryAfter time.Duration
+		want       time.Duration
+	}{
+		{retryAfter: 90 * time.Second, want: 90 * time.Second},
+		{retryAfter: 2400 * time.Millisecond, want: 2 * time.Second},
+		// Clients must not be told to retry right away
+		{retryAfter: 100 * time.Millisecond, want: time.Second},
+		{retryAfter: 0, want: time.Second},
+	}
+	for _, tt := range tests {
+		st := status.Convert(retryError("locked", tt.retryAfter))
+		if st.Code() != codes.ResourceExhausted {
+			t.Fatalf("code = %s, want ResourceExhausted", st.Code())
+		}
+
+		var got time.Duration
+		for _, detail := range st.Details() {
+			if info, ok := detail.(*errdetails.RetryInfo); ok {
+				got = info.RetryDelay.AsDuration()
+			}
+		}
+		if got != tt.want {
+			t.Errorf("retry delay for %s = %s, want %s", tt.retryAfter, got, tt.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	peerCtx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.7"), Port: 51234},
+	})
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		forwarded string
+		want      string
+	}{
+		{name: "forwarded", ctx: peerCtx, forwarded: "203.0.113.9", want: "203.0.113.9"},
+		{name: "forwarded IPv6 is normalized", ctx: peerCtx, forwarded: " 2001:DB8::1 ", want: "2001:db8::1"},
+		{name: "invalid forwarded falls back to the peer", ctx: peerCtx, forwarded: "not an ip", want: "10.0.0.7"},
+		{name: "peer", ctx: peerCtx, want: "10.0.0.7"},
+		{name: "none", ctx: context.Background(), want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clientIP(tt.ctx, tt.forwarded); got != tt.want {
+				t.Fatalf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoginThrottlerLockout(t *testing.T) {
+	db := testDB(t)
+	ctx := context.Background()
+	cfg := testThrottleConfig()
+
+	throttler := NewLoginThrottler(storage.NewLoginThrottleRepository(db), cfg)
+	now := time.Now()
+	throttler.now = func() time.Time { return now }
+
+	nanos := time.Now().UnixNano()
+	username := "throttle_" + strconv.FormatInt(nanos, 36)
+	ip := "198.51.100." + strconv.FormatInt(nanos%250+1, 10)
+	t.Cleanup(func() {
+		throttler.repo.Reset(ctx, userThrottleKey(username))
+		throttler.repo.Reset(ctx, ipThrottleKey(ip))
+	})
+
+	// fail records a failure after any backoff of the previous one ran out
+	fail := func() []lockout {
+		now = now.Add(cfg.LoginBackoffMax)
+		return throttler.RecordFailure(ctx, username, ip)
+	}
+
+	for i := 1; i < cfg.LoginLockoutThreshold; i++ {
+		if started := fail(); len(started) != 0 {
+			t.Fatalf("failure %d started a lockout: %+v", i, started)
+		}
+	}
+	now = now.Add(cfg.LoginBackoffMax)
+	if err := throttler.Check(ctx, username, ip); err != nil {
+		t.Fatalf("Check once the backoff ran out: %v", err)
+	}
+
+	// Reaching the threshold locks the username; the IP stays below its own
+	started := throttler.RecordFailure(ctx, username, ip)
+	if len(started) != 1 || started[0].Key != userThrottleKey(username) || !started[0].Until.Equal(now.Add(cfg.LoginLockoutDuration)) {
+		t.Fatalf("lockouts = %+v, want one of the username until %s", started, now.Add(cfg.LoginLockoutDuration))
+	}
+
+	err := throttler.Check(ctx, username, ip)
+	if status.Code(err) != codes.ResourceExhausted || !strings.Contains(err.Error(), "temporarily locked") {
+		t.Fatalf("Check while locked = %v, want a lockout", err)
+	}
+	// Another username from the same IP is not locked yet
+	if err := throttler.Check(ctx, username+"_other", ""); err != nil {
+		t.Fatalf("Check of another username: %v", err)
+	}
+
+	// Failures during the lockout do not start it again
+	if started := fail(); len(started) != 0 {
+		t.Fatalf("failure during the lockout started another: %+v", started)
+	}
+
+	// Once the lockout expired the counter restarts
+	now = now.Add(cfg.LoginLockoutDuration)
+	if err := throttler.Check(ctx, username, ""); err != nil {
+		t.Fatalf("Check after the lockout: %v", err)
+	}
+	if started := fail(); len(started) != 0 {
+		t.Fatalf("first failure after the lockout started another: %+v", started)
+	}
+	throttle, err := throttler.repo.GetThrottle(ctx, userThrottleKey(username))
+	if err != nil || throttle == nil || throttle.Failures != 1 {
+		t.Fatalf("throttle after the lockout = %+v (%v), want 1 failure", throttle, err)
+	}
+
+	// A successful sign-in clears the username, but not the IP
+	throttler.RecordSuccess(ctx, username)
+	if throttle, err := throttler.repo.GetThrottle(ctx, userThrottleKey(username)); err != nil || throttle != nil {
+		t.Fatalf("username throttle after success = %+v (%v), want none", throttle, err)
+	}
+	if throttle, err := throttler.repo.GetThrottle(ctx, ipThrottleKey(ip)); err != nil || throttle == nil {
+		t.Fatalf("IP throttle after success = %+v (%v), want it kept", throttle, err)
+	}
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 key of the RFC 6238 test vectors,
+// "12345678901234567890", in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238, appendix B, cut to the last 6 of the 8 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(rfc6238Secret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatalf("totpCode at %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+	code := func(step int64) string {
+		c, err := totpCode(rfc6238Secret, step)
+		if err != nil {
+			t.Fatalf("totpCode: %v", err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current step", secret: rfc6238Secret, code: code(current), wantStep: current, wantOK: true},
+		{name: "previous step", secret: rfc6238Secret, code: code(current - 1), wantStep: current - 1, wantOK: true},
+		{name: "next step", secret: rfc6238Secret, code: code(current + 1), wantStep: current + 1, wantOK: true},
+		{name: "two steps old", secret: rfc6238Secret, code: code(current - 2)},
+		{name: "two steps ahead", secret: rfc6238Secret, code: code(current + 2)},
+		{name: "spaces are ignored", secret: rfc6238Secret, code: " " + code(current)[:3] + " " + code(current)[3:] + " ", wantStep: current, wantOK: true},
+		{name: "lower case secret", secret: strings.ToLower(rfc6238Secret), code: code(current), wantStep: current, wantOK: true},
+		{name: "wrong code", secret: rfc6238Secret, code: "000000"},
+		{name: "too short", secret: rfc6238Secret, code: code(current)[:5]},
+		{name: "too long", secret: rfc6238Secret, code: code(current) + "0"},
+		{name: "empty", secret: rfc6238Secret},
+		{name: "other secret", secret: "JBSWY3DPEHPK3PXP", code: code(current)},
+		{name: "invalid secret", secret: "not base32!", code: code(current)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := validateTOTP(tt.secret, tt.code, now)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Fatalf("validateTOTP = %d, %v, want %d, %v", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatalf("generateTOTPSecret: %v", err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != totpSecretSize {
+		t.Fatalf("secret %q decodes to %d bytes (%v), want %d", secret, len(key), err, totpSecretSize)
+	}
+
+	other, _ := generateTOTPSecret()
+	if other == secret {
+		t.Fatal("two secrets are equal")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatalf("generateRecoveryCodes: %v", err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("got %d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+
+	// Users may type the codes in upper case and without the dash
+	code := codes[0]
+	for _, typed := range []string{code, strings.ToUpper(code), strings.ReplaceAll(code, "-", ""), strings.ReplaceAll(code, "-", " ")} {
+		if hashToken(normalizeRecoveryCode(typed)) != hashes[0] {
+			t.Errorf("%q does not match the stored hash of %q", typed, code)
+		}
+	}
+
+	// Longer than a TOTP code, so verifySecondFactor can tell them apart
+	if len(code) <= totpDigits {
+		t.Fatalf("recovery code %q is not longer than a TOTP code", code)
+	}
+}
+
+func TestSecretBox(t *testing.T) {
+	box, err := newSecretBox("test key")
+	if err != nil {
+		t.Fatalf("newSecretBox: %v", err)
+	}
+
+	sealed, err := box.Seal(rfc6238Secret)
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if strings.Contains(sealed, rfc6238Secret) {
+		t.Fatal("sealed secret contains the plaintext")
+	}
+	if opened, err := box.Open(sealed); err != nil || opened != rfc6238Secret {
+		t.Fatalf("Open = %q, %v, want the secret", opened, err)
+	}
+
+	other, _ := newSecretBox("other key")
+	if _, err := other.Open(sealed); err == nil {
+		t.Fatal("a box with another key opened the secret")
+	}
+	if _, err := box.Open(sealed[:8]); err == nil {
+		t.Fatal("a truncated ciphertext was opened")
+	}
+}
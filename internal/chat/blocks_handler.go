@@ -0,0 +1,126 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+type BlockedUserResponse struct {
+	UserID    int64  `json:"user_id"`
+	Username  string `json:"username"`
+	CreatedAt string `json:"created_at"`
+}
+
+type BlockUserRequest struct {
+	UserID int64 `json:"user_id"`
+}
+
+// BlocksHandler lets users block others from messaging them, under /api/chat/blocks
+type BlocksHandler struct {
+	hub  *Hub
+	auth *auth.Service
+}
+
+func NewBlocksHandler(hub *Hub, auth *auth.Service) *BlocksHandler {
+	return &BlocksHandler{
+		hub:  hub,
+		auth: auth,
+	}
+}
+
+func (h *BlocksHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/chat/blocks"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		h.handleListBlocks(w, r)
+	case id == "" && r.Method == http.MethodPost:
+		h.handleBlock(w, r)
+	case id != "" && r.Method == http.MethodDelete:
+		h.handleUnblock(w, r, id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *BlocksHandler) handleListBlocks(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireUser(w, r, h.auth, auth.ScopeChatRead)
+	if !ok {
+		return
+	}
+
+	blocked, err := h.hub.blockRepo.GetBlockedUsers(r.Context(), claims.UserId)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", claims.UserId).Msg("Failed to list blocked users")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]BlockedUserResponse, len(blocked))
+	for i, b := range blocked {
+		response[i] = BlockedUserResponse{
+			UserID:    b.UserID,
+			Username:  b.Username,
+			CreatedAt: b.CreatedAt.Format(time.RFC3339),
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *BlocksHandler) handleBlock(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireUser(w, r, h.auth, auth.ScopeChatWrite)
+	if !ok {
+		return
+	}
+
+	var req BlockUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID <= 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID == claims.UserId {
+		http.Error(w, "You cannot block yourself", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.hub.blockRepo.BlockUser(r.Context(), claims.UserId, req.UserID); err != nil {
+		// The foreign key rejects unknown users
+		logger.Error().Err(err).Int64("user_id", claims.UserId).Msg("Failed to block user")
+		http.Error(w, "Failed to block user", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *BlocksHandler) handleUnblock(w http.ResponseWriter, r *http.Request, idStr string) {
+	claims, ok := requireUser(w, r, h.auth, auth.ScopeChatWrite)
+	if !ok {
+		return
+	}
+
+	blockedID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	removed, err := h.hub.blockRepo.UnblockUser(r.Context(), claims.UserId, blockedID)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", claims.UserId).Msg("Failed to unblock user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !removed {
+		http.Error(w, "Block not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
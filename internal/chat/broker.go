@@ -0,0 +1,51 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/config"
+)
+
+// BrokerMessage is a hub event shared between forum-service instances. Every
+// hub delivers it to its own matching connections.
+type BrokerMessage struct {
+	// Origin is the ID of the hub that published the message
+	Origin string `json:"origin"`
+	Room   int64  `json:"room,omitempty"`
+	// Users, if set, addresses all connections of these users instead of a room
+	Users []int64 `json:"users,omitempty"`
+	// Skip is the connection on the origin hub that must not get the message
+	Skip uint64 `json:"skip,omitempty"`
+	// MessageID is set for new chat messages
+	MessageID int64 `json:"message_id,omitempty"`
+	// EvictUser unsubscribes the connections of the user from Room
+	EvictUser int64 `json:"evict_user,omitempty"`
+	// Disconnect, if set, closes the connections of Users after sending
+	// them Data, with this close reason
+	Disconnect string          `json:"disconnect,omitempty"`
+	Data       json.RawMessage `json:"data,omitempty"`
+}
+
+// Broker fans hub events out to every hub, including the publishing one
+type Broker interface {
+	Publish(ctx context.Context, msg BrokerMessage) error
+	// Subscribe returns the messages published by all hubs. The channel is
+	// closed when ctx is done or the broker is closed.
+	Subscribe(ctx context.Context) (<-chan BrokerMessage, error)
+	Close() error
+}
+
+// NewBroker creates the broker selected by cfg.ChatBroker
+func NewBroker(cfg *config.Config, db *storage.DB) (Broker, error) {
+	switch cfg.ChatBroker {
+	case "memory":
+		return NewMemoryBroker(), nil
+	case "postgres":
+		return NewPostgresBroker(cfg.GetDBURL(), storage.NewFanoutRepository(db)), nil
+	default:
+		return nil, fmt.Errorf("unknown chat broker %q", cfg.ChatBroker)
+	}
+}
@@ -0,0 +1,163 @@
+package chat
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+)
+
+func TestMemoryBrokerPublishDoesNotBlock(t *testing.T) {
+	broker := NewMemoryBroker()
+	defer broker.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	messages, err := broker.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// Nobody reads, so the subscriber fills up and later messages are dropped
+	published := make(chan error, 1)
+	go func() {
+		for i := 0; i < 2*cap(messages); i++ {
+			if err := broker.Publish(context.Background(), BrokerMessage{Room: int64(i + 1)}); err != nil {
+				published <- err
+				return
+			}
+		}
+		published <- nil
+	}()
+
+	select {
+	case err := <-published:
+		if err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("Publish blocked on a full subscriber")
+	}
+
+	if len(messages) != cap(messages) {
+		t.Fatalf("subscriber holds %d messages, want %d", len(messages), cap(messages))
+	}
+	if msg := <-messages; msg.Room != 1 {
+		t.Fatalf("first message is for room %d, want the oldest", msg.Room)
+	}
+}
+
+// brokerPair returns the brokers of two hubs. Hubs of one process share a
+// MemoryBroker, hubs of separate instances each have a PostgresBroker.
+type brokerPair func(t *testing.T) (Broker, Broker)
+
+func testBrokerPairs() map[string]brokerPair {
+	return map[string]brokerPair{
+		"memory": func(t *testing.T) (Broker, Broker) {
+			broker := NewMemoryBroker()
+			t.Cleanup(func() { broker.Close() })
+			return broker, broker
+		},
+		"postgres": func(t *testing.T) (Broker, Broker) {
+			db, connStr := testDB(t)
+			a := NewPostgresBroker(connStr, storage.NewFanoutRepository(db))
+			b := NewPostgresBroker(connStr, storage.NewFanoutRepository(db))
+			t.Cleanup(func() {
+				a.Close()
+				b.Close()
+			})
+			return a, b
+		},
+	}
+}
+
+func TestHubsShareBroker(t *testing.T) {
+	const room = 7
+
+	for name, newBrokers := range testBrokerPairs() {
+		t.Run(name, func(t *testing.T) {
+			brokerA, brokerB := newBrokers(t)
+			hubA := newTestHub(t, brokerA, nil)
+			hubB := newTestHub(t, brokerB, nil)
+			serverA := newTestServer(t, hubA, nil)
+			serverB := newTestServer(t, hubB, nil)
+
+			// A connection on hub B gets the events published on hub A
+			connB, framesB := dial(t, serverB, "")
+			joinRoom(hubB, onlyClient(t, hubB), room)
+			deliverEventually(t, hubA, framesB, room, "from A")
+
+			// The skipped connection is one of the origin hub only, even
+			// if a connection elsewhere has the same ID
+			_, framesA := dial(t, serverA, "")
+			clientA := onlyClient(t, hubA)
+			joinRoom(hubA, clientA, room)
+			hubA.Broadcast(Envelope{Type: TypeMessage, Room: room, Content: "skip A"}, clientA)
+			expectFrame(t, framesB, hasContent("skip A"))
+
+			// After reconnecting to the other hub the client gets the events
+			// of the hub it left
+			connB.Close()
+			waitFor(t, "hub B to drop the closed connection", func() bool {
+				return len(hubClients(hubB)) == 0
+			})
+			_, framesA2 := dial(t, serverA, "")
+			var reconnected *Client
+			waitFor(t, "the reconnected client", func() bool {
+				for _, client := range hubClients(hubA) {
+					if client != clientA {
+						reconnected = client
+						return true
+					}
+				}
+				return false
+			})
+			joinRoom(hubA, reconnected, room)
+
+			deliverEventually(t, hubB, framesA2, room, "from B")
+			expectFrame(t, framesA, hasContent("from B"))
+		})
+	}
+}
+
+// deliverEventually broadcasts on the hub until the frame arrives. The
+// listener of a PostgresBroker may still be connecting when the test starts,
+// and NOTIFY does not queue events for it.
+func deliverEventually(t *testing.T, hub *Hub, frames <-chan Envelope, room int64, content string) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		hub.Broadcast(Envelope{Type: TypeMessage, Room: room, Content: content}, nil)
+		select {
+		case env, ok := <-frames:
+			if !ok {
+				t.Fatal("connection closed while waiting for a frame")
+			}
+			if env.Type == TypeMessage && env.Content == content {
+				drainFrames(frames)
+				return
+			}
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	t.Fatalf("%q was not delivered", content)
+}
+
+// drainFrames discards the copies of repeated broadcasts
+func drainFrames(frames <-chan Envelope) {
+	for {
+		select {
+		case <-frames:
+		case <-time.After(200 * time.Millisecond):
+			return
+		}
+	}
+}
+
+func hasContent(content string) func(Envelope) bool {
+	return func(env Envelope) bool {
+		return env.Type == TypeMessage && env.Content == content
+	}
+}
@@ -0,0 +1,119 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+// commandUsage lists the moderator slash commands
+var commandUsage = map[string]string{
+	"/mute":   "/mute @user [duration] [reason]",
+	"/unmute": "/unmute @user",
+	"/kick":   "/kick @user [reason]",
+	"/ban":    "/ban @user [duration] [reason]",
+	"/unban":  "/unban @user",
+}
+
+// handleCommand runs a moderator slash command sent as a chat message, like
+// "/mute @alice 10m spam". It reports false if the content is not a command,
+// the content is then sent as a regular message.
+func (c *Client) handleCommand(ctx context.Context, env Envelope, content string) bool {
+	fields := strings.Fields(content)
+	name := fields[0]
+	usage, ok := commandUsage[name]
+	if !ok {
+		return false
+	}
+
+	if !auth.IsModerator(c.role) {
+		c.sendError(ErrCodeForbidden, "only moderators can use "+name, env.ClientMsgID)
+		return true
+	}
+	// Like the sanctions API, commands need a session rather than an API token
+	if c.apiToken {
+		c.sendError(ErrCodeForbidden, "API tokens cannot use "+name, env.ClientMsgID)
+		return true
+	}
+	if len(fields) < 2 || !strings.HasPrefix(fields[1], "@") {
+		c.sendError(ErrCodeBadRequest, "usage: "+usage, env.ClientMsgID)
+		return true
+	}
+
+	// The repository reports unknown users as errors
+	target, err := c.hub.userRepo.GetUserByUsername(ctx, strings.TrimPrefix(fields[1], "@"))
+	if err != nil {
+		c.sendError(ErrCodeNotFound, "user not found", env.ClientMsgID)
+		return true
+	}
+
+	args := fields[2:]
+	var result string
+	switch name {
+	case "/mute", "/ban":
+		kind, duration := storage.SanctionBan, time.Duration(0)
+		if name == "/mute" {
+			kind, duration = storage.SanctionMute, defaultMuteDuration
+		}
+		if len(args) > 0 {
+			if d, ok := parseSanctionDuration(args[0]); ok {
+				duration, args = d, args[1:]
+			}
+		}
+		result, err = c.runSanction(ctx, target, kind, duration, strings.Join(args, " "))
+
+	case "/kick":
+		result, err = c.runSanction(ctx, target, storage.SanctionKick, 0, strings.Join(args, " "))
+
+	case "/unmute", "/unban":
+		kind, state := storage.SanctionMute, "muted"
+		if name == "/unban" {
+			kind, state = storage.SanctionBan, "banned"
+		}
+		var lifted bool
+		lifted, err = c.hub.liftSanctions(ctx, *c.userID, target, kind)
+		result = target.Username + " was not " + state
+		if lifted {
+			result = target.Username + " is no longer " + state
+		}
+	}
+
+	if err != nil {
+		if errors.Is(err, errSanctionSelf) || errors.Is(err, errSanctionModerator) {
+			c.sendError(ErrCodeForbidden, err.Error(), env.ClientMsgID)
+			return true
+		}
+		logger.Error().Err(err).Str("command", name).Int64("target_id", target.ID).Msg("Failed to run chat command")
+		c.sendError(ErrCodeInternal, "failed to run command", env.ClientMsgID)
+		return true
+	}
+
+	c.sendFrame(Envelope{Type: TypeAck, Room: env.Room, ClientMsgID: env.ClientMsgID, Message: result})
+	return true
+}
+
+func (c *Client) runSanction(ctx context.Context, target *storage.User, kind string, duration time.Duration, reason string) (string, error) {
+	if utf8.RuneCountInString(reason) > maxSanctionReasonLength {
+		reason = string([]rune(reason)[:maxSanctionReasonLength])
+	}
+
+	s, err := c.hub.sanction(ctx, *c.userID, c.role, target, kind, duration, reason)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case kind == storage.SanctionKick:
+		return target.Username + " was kicked", nil
+	case s.ExpiresAt == nil:
+		return target.Username + " was " + sanctionEnvelope(s).Status + " permanently", nil
+	default:
+		return target.Username + " was " + sanctionEnvelope(s).Status + " for " + duration.String(), nil
+	}
+}
@@ -0,0 +1,109 @@
+package chat
+
+import (
+	"context"
+
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+// participantIDs returns the participants of the conversation, or nil if the
+// user does not take part in it
+func (h *Hub) participantIDs(ctx context.Context, conversationID, userID int64) ([]int64, error) {
+	ids, err := h.convRepo.GetParticipantIDs(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		if id == userID {
+			return ids, nil
+		}
+	}
+	return nil, nil
+}
+
+// otherUsers returns the IDs without the user
+func otherUsers(ids []int64, userID int64) []int64 {
+	others := make([]int64, 0, len(ids))
+	for _, id := range ids {
+		if id != userID {
+			others = append(others, id)
+		}
+	}
+	return others
+}
+
+func (c *Client) handleDirectMessage(ctx context.Context, env Envelope) {
+	if env.Conversation <= 0 {
+		c.sendError(ErrCodeBadRequest, "conversation is required", env.ClientMsgID)
+		return
+	}
+	content, ok := c.validContent(env)
+	if !ok {
+		return
+	}
+	if !c.allowContent(env, -env.Conversation, content) {
+		return
+	}
+
+	participants, err := c.hub.participantIDs(ctx, env.Conversation, *c.userID)
+	if err != nil {
+		logger.Error().Err(err).Int64("conversation_id", env.Conversation).Msg("Failed to get conversation participants")
+		c.sendError(ErrCodeInternal, "failed to send message", env.ClientMsgID)
+		return
+	}
+	if participants == nil {
+		c.sendError(ErrCodeNotFound, "conversation not found", env.ClientMsgID)
+		return
+	}
+
+	// A block by any participant keeps the sender out of the conversation
+	blocked, err := c.hub.blockRepo.IsBlockedByAny(ctx, *c.userID, otherUsers(participants, *c.userID))
+	if err != nil {
+		logger.Error().Err(err).Int64("conversation_id", env.Conversation).Msg("Failed to check blocks")
+		c.sendError(ErrCodeInternal, "failed to send message", env.ClientMsgID)
+		return
+	}
+	if blocked {
+		c.sendError(ErrCodeForbidden, "you cannot message this conversation", env.ClientMsgID)
+		return
+	}
+
+	msg, err := c.hub.convRepo.CreateDirectMessage(ctx, env.Conversation, *c.userID, content)
+	if err != nil {
+		logger.Error().Err(err).Int64("conversation_id", env.Conversation).Msg("Failed to store direct message")
+		c.sendError(ErrCodeInternal, "failed to send message", env.ClientMsgID)
+		return
+	}
+
+	createdAt := msg.CreatedAt.UTC()
+	c.sendFrame(Envelope{
+		Type:         TypeAck,
+		ID:           msg.ID,
+		Conversation: msg.ConversationID,
+		ClientMsgID:  env.ClientMsgID,
+		CreatedAt:    &createdAt,
+	})
+	c.hub.SendToUsers(directMessageEnvelope(msg, env.ClientMsgID), participants)
+}
+
+// handleRead moves the read marker of the user forward
+func (c *Client) handleRead(ctx context.Context, env Envelope) {
+	if env.Conversation <= 0 || env.ID <= 0 {
+		c.sendError(ErrCodeBadRequest, "conversation and id are required", env.ClientMsgID)
+		return
+	}
+
+	ok, err := c.hub.convRepo.MarkRead(ctx, env.Conversation, *c.userID, env.ID)
+	if err != nil {
+		logger.Error().Err(err).Int64("conversation_id", env.Conversation).Msg("Failed to mark conversation read")
+		c.sendError(ErrCodeInternal, "failed to mark read", env.ClientMsgID)
+		return
+	}
+	if !ok {
+		c.sendError(ErrCodeNotFound, "conversation not found", env.ClientMsgID)
+		return
+	}
+
+	c.sendFrame(Envelope{Type: TypeAck, ID: env.ID, Conversation: env.Conversation, ClientMsgID: env.ClientMsgID})
+}
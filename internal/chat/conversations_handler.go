@@ -0,0 +1,285 @@
+package chat
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+// maxConversationParticipants limits group conversations, the creator included
+const maxConversationParticipants = 10
+
+type ConversationResponse struct {
+	ID            int64                 `json:"id"`
+	IsGroup       bool                  `json:"is_group"`
+	Participants  []ParticipantResponse `json:"participants"`
+	UnreadCount   int                   `json:"unread_count"`
+	CreatedAt     string                `json:"created_at"`
+	LastMessageAt *string               `json:"last_message_at,omitempty"`
+}
+
+type ParticipantResponse struct {
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+}
+
+type DirectMessageResponse struct {
+	ID             int64  `json:"id"`
+	ConversationID int64  `json:"conversation_id"`
+	UserID         int64  `json:"user_id"`
+	Username       string `json:"username"`
+	Content        string `json:"content"`
+	CreatedAt      string `json:"created_at"`
+}
+
+type CreateConversationRequest struct {
+	UserIDs []int64 `json:"user_ids"`
+}
+
+type MarkReadRequest struct {
+	MessageID int64 `json:"message_id"`
+}
+
+// ConversationsHandler serves direct conversations under /api/chat/conversations.
+// Messages are sent over the websocket.
+type ConversationsHandler struct {
+	hub  *Hub
+	auth *auth.Service
+}
+
+func NewConversationsHandler(hub *Hub, auth *auth.Service) *ConversationsHandler {
+	return &ConversationsHandler{
+		hub:  hub,
+		auth: auth,
+	}
+}
+
+func (h *ConversationsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/chat/conversations"), "/")
+	if path == "" {
+		switch r.Method {
+		case http.MethodGet:
+			h.handleGetConversations(w, r)
+		case http.MethodPost:
+			h.handleCreateConversation(w, r)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	conversationID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case parts[1] == "messages" && r.Method == http.MethodGet:
+		h.handleGetConversationMessages(w, r, conversationID)
+	case parts[1] == "read" && r.Method == http.MethodPost:
+		h.handleMarkRead(w, r, conversationID)
+	case parts[1] == "messages" || parts[1] == "read":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *ConversationsHandler) handleGetConversations(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireUser(w, r, h.auth, auth.ScopeChatRead)
+	if !ok {
+		return
+	}
+
+	conversations, err := h.hub.convRepo.GetConversationsByUserID(r.Context(), claims.UserId)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", claims.UserId).Msg("Failed to get conversations")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]ConversationResponse, len(conversations))
+	for i := range conversations {
+		response[i] = toConversationResponse(&conversations[i])
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleCreateConversation starts a conversation with one or more users. A
+// conversation with a single other user is reused if it exists.
+func (h *ConversationsHandler) handleCreateConversation(w http.ResponseWriter, r *http.Request) {
+	claims, ok := requireUser(w, r, h.auth, auth.ScopeChatWrite)
+	if !ok {
+		return
+	}
+
+	var req CreateConversationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	seen := map[int64]bool{claims.UserId: true}
+	var others []int64
+	for _, id := range req.UserIDs {
+		if id > 0 && !seen[id] {
+			seen[id] = true
+			others = append(others, id)
+		}
+	}
+	if len(others) == 0 || len(others) >= maxConversationParticipants {
+		http.Error(w, "Conversations need between 2 and 10 participants", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	blocked, err := h.hub.blockRepo.IsBlockedByAny(ctx, claims.UserId, others)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", claims.UserId).Msg("Failed to check blocks")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if blocked {
+		http.Error(w, "You cannot message these users", http.StatusForbidden)
+		return
+	}
+
+	var conversationID int64
+	if len(others) == 1 {
+		conversationID, err = h.hub.convRepo.GetOrCreateDirectConversation(ctx, claims.UserId, others[0])
+	} else {
+		conversationID, err = h.hub.convRepo.CreateGroupConversation(ctx, claims.UserId, others)
+	}
+	if err != nil {
+		// The foreign keys reject unknown users
+		logger.Error().Err(err).Int64("user_id", claims.UserId).Msg("Failed to create conversation")
+		http.Error(w, "Failed to create conversation", http.StatusBadRequest)
+		return
+	}
+
+	conversations, err := h.hub.convRepo.GetConversationsByUserID(ctx, claims.UserId)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", claims.UserId).Msg("Failed to get conversations")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	for i := range conversations {
+		if conversations[i].ID == conversationID {
+			writeJSON(w, http.StatusCreated, toConversationResponse(&conversations[i]))
+			return
+		}
+	}
+
+	http.Error(w, "Internal server error", http.StatusInternalServerError)
+}
+
+func (h *ConversationsHandler) handleGetConversationMessages(w http.ResponseWriter, r *http.Request, conversationID int64) {
+	claims, ok := requireUser(w, r, h.auth, auth.ScopeChatRead)
+	if !ok {
+		return
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > 100 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var beforeID int64
+	if beforeStr := r.URL.Query().Get("before"); beforeStr != "" {
+		var err error
+		beforeID, err = strconv.ParseInt(beforeStr, 10, 64)
+		if err != nil || beforeID < 0 {
+			http.Error(w, "Invalid before parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	participants, err := h.hub.participantIDs(ctx, conversationID, claims.UserId)
+	if err != nil {
+		logger.Error().Err(err).Int64("conversation_id", conversationID).Msg("Failed to get conversation participants")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if participants == nil {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+
+	messages, err := h.hub.convRepo.GetConversationMessages(ctx, conversationID, beforeID, limit)
+	if err != nil {
+		logger.Error().Err(err).Int64("conversation_id", conversationID).Msg("Failed to get conversation messages")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]DirectMessageResponse, len(messages))
+	for i, msg := range messages {
+		response[i] = DirectMessageResponse{
+			ID:             msg.ID,
+			ConversationID: msg.ConversationID,
+			UserID:         msg.UserID,
+			Username:       msg.Username,
+			Content:        msg.Content,
+			CreatedAt:      msg.CreatedAt.Format(time.RFC3339),
+		}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *ConversationsHandler) handleMarkRead(w http.ResponseWriter, r *http.Request, conversationID int64) {
+	claims, ok := requireUser(w, r, h.auth, auth.ScopeChatRead)
+	if !ok {
+		return
+	}
+
+	var req MarkReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MessageID <= 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	marked, err := h.hub.convRepo.MarkRead(r.Context(), conversationID, claims.UserId, req.MessageID)
+	if err != nil {
+		logger.Error().Err(err).Int64("conversation_id", conversationID).Msg("Failed to mark conversation read")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !marked {
+		http.Error(w, "Conversation not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func toConversationResponse(c *storage.Conversation) ConversationResponse {
+	resp := ConversationResponse{
+		ID:           c.ID,
+		IsGroup:      c.IsGroup,
+		Participants: make([]ParticipantResponse, len(c.Participants)),
+		UnreadCount:  c.UnreadCount,
+		CreatedAt:    c.CreatedAt.Format(time.RFC3339),
+	}
+	for i, p := range c.Participants {
+		resp.Participants[i] = ParticipantResponse{UserID: p.UserID, Username: p.Username}
+	}
+	if c.LastMessageAt != nil {
+		lastMessageAt := c.LastMessageAt.Format(time.RFC3339)
+		resp.LastMessageAt = &lastMessageAt
+	}
+	return resp
+}
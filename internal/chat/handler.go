@@ -3,6 +3,7 @@ package chat
 import (
 	"context"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/websocket"
 	"github.com/Ryan-Gosusluging/forum/internal/auth"
@@ -32,17 +33,56 @@ func NewHandler(hub *Hub, auth *auth.Service) *Handler {
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Проверяем токен аутентификации
+	// Browsers cannot set headers on WebSocket requests, bots may use either
 	token := r.URL.Query().Get("token")
+	if token == "" {
+		token = auth.TokenFromRequest(r)
+	}
 	var userID *int64
 	var username *string
+	var role string
+	readOnly := false
+	apiToken := false
+
+	// since is the last message the client saw before reconnecting
+	var since int64
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		var err error
+		since, err = strconv.ParseInt(sinceStr, 10, 64)
+		if err != nil || since < 0 {
+			http.Error(w, "Invalid since parameter", http.StatusBadRequest)
+			return
+		}
+	}
 
 	if token != "" {
 		ctx := context.Background()
 		resp, err := h.auth.ValidateToken(ctx, &proto.ValidateTokenRequest{Token: token})
-		if err == nil && resp.Valid {
-			userID = &resp.UserId
-			username = &resp.Username
+		// A client that sent a token expects to be signed in, so a bad token
+		// is rejected rather than silently downgraded to a read-only session
+		if err != nil || !resp.Valid {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !auth.HasScope(resp, auth.ScopeChatRead) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
 		}
+		// Banned users may not even read the chat until the ban ends
+		banned, err := h.isBanned(ctx, resp.UserId)
+		if err != nil {
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if banned {
+			http.Error(w, "Banned from chat", http.StatusForbidden)
+			return
+		}
+		userID = &resp.UserId
+		username = &resp.Username
+		role = resp.Role
+		readOnly = !auth.HasScope(resp, auth.ScopeChatWrite)
+		apiToken = resp.IsApiToken
 	}
 
 	// Обновляем соединение до WebSocket
@@ -56,13 +96,24 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	client := &Client{
 		hub:      h.hub,
 		conn:     conn,
-		send:     make(chan []byte, 256),
+		send:     make(chan []byte, h.hub.cfg.ChatSendBuffer),
 		userID:   userID,
 		username: username,
+		ip:       remoteIP(r),
+		role:     role,
+		readOnly: readOnly,
+		apiToken: apiToken,
+		since:    since,
 	}
 
 	// Регистрируем клиента
-	h.hub.register <- client
+	select {
+	case h.hub.register <- client:
+	case <-h.hub.done:
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+		conn.Close()
+		return
+	}
 
 	// Запускаем горутины для чтения и записи
 	go client.writePump()
@@ -0,0 +1,136 @@
+package chat
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+)
+
+func TestAnonymousSenderGetsErrorFrame(t *testing.T) {
+	hub := newTestHub(t, NewMemoryBroker(), nil)
+	server := newTestServer(t, hub, nil)
+
+	conn, frames := dial(t, server, "")
+	sendFrame(t, conn, Envelope{Type: TypeMessage, Room: 1, Content: "hello", ClientMsgID: "m1"})
+
+	env := expectFrame(t, frames, func(env Envelope) bool { return env.Type == TypeError })
+	if env.Code != ErrCodeAuthRequired || env.ClientMsgID != "m1" || env.V != ProtocolVersion {
+		t.Fatalf("error frame = %+v, want code %q for m1", env, ErrCodeAuthRequired)
+	}
+}
+
+func TestMalformedFrameGetsErrorFrame(t *testing.T) {
+	hub := newTestHub(t, NewMemoryBroker(), nil)
+	server := newTestServer(t, hub, nil)
+
+	conn, frames := dial(t, server, "")
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("not json")); err != nil {
+		t.Fatalf("send frame: %v", err)
+	}
+
+	env := expectFrame(t, frames, func(env Envelope) bool { return env.Type == TypeError })
+	if env.Code != ErrCodeBadRequest {
+		t.Fatalf("error frame = %+v, want code %q", env, ErrCodeBadRequest)
+	}
+}
+
+func TestInvalidTokenIsRejected(t *testing.T) {
+	hub := newTestHub(t, NewMemoryBroker(), nil)
+	server := newTestServer(t, hub, nil)
+
+	endpoint := "ws" + strings.TrimPrefix(server.URL, "http") + "?token=invalid"
+	_, resp, err := websocket.DefaultDialer.Dial(endpoint, nil)
+	if err == nil {
+		t.Fatal("connection with an invalid token was accepted")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("response = %v, want 401", resp)
+	}
+}
+
+func TestRegisteredSenderMessageIsBroadcast(t *testing.T) {
+	db, _ := testDB(t)
+	hub := newTestHub(t, NewMemoryBroker(), db)
+	server := newTestServer(t, hub, db)
+
+	user, room := createTestRoom(t, db)
+	token := testToken(t, hub, user)
+
+	listener, listenerFrames := dial(t, server, "")
+	sendFrame(t, listener, Envelope{Type: TypeJoin, Room: room.ID, ClientMsgID: "join"})
+	expectFrame(t, listenerFrames, isAck("join"))
+
+	sender, senderFrames := dial(t, server, token)
+	sendFrame(t, sender, Envelope{Type: TypeJoin, Room: room.ID, ClientMsgID: "join"})
+	expectFrame(t, senderFrames, isAck("join"))
+
+	sendFrame(t, sender, Envelope{Type: TypeMessage, Room: room.ID, Content: "hello", ClientMsgID: "m1"})
+
+	ack := expectFrame(t, senderFrames, isAck("m1"))
+	if ack.ID == 0 || ack.Room != room.ID || ack.CreatedAt == nil {
+		t.Fatalf("ack = %+v, want the stored message", ack)
+	}
+
+	msg := expectFrame(t, listenerFrames, hasContent("hello"))
+	if msg.ID != ack.ID || msg.Room != room.ID || msg.User == nil || msg.User.ID != user.ID || msg.User.Username != user.Username {
+		t.Fatalf("message = %+v, want message %d of user %d", msg, ack.ID, user.ID)
+	}
+	// The sender gets the broadcast too, with its client_msg_id to match
+	if echo := expectFrame(t, senderFrames, hasContent("hello")); echo.ClientMsgID != "m1" {
+		t.Fatalf("echo = %+v, want client_msg_id m1", echo)
+	}
+}
+
+// createTestRoom creates a user and a public room for a test
+func createTestRoom(t *testing.T, db *storage.DB) (*storage.User, *storage.Room) {
+	t.Helper()
+
+	ctx := context.Background()
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	user, err := storage.NewUserRepository(db).CreateUser(ctx, "chat_"+suffix, "chat_"+suffix+"@example.com", "not a hash")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	room, err := storage.NewRoomRepository(db).CreateRoom(ctx, "test-"+suffix, "Test "+suffix, storage.RoomKindPublic, nil, &user.ID)
+	if err != nil || room == nil {
+		t.Fatalf("create room: %v", err)
+	}
+
+	t.Cleanup(func() {
+		db.ExecContext(ctx, `DELETE FROM rooms WHERE id = $1`, room.ID)
+		db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, user.ID)
+	})
+
+	return user, room
+}
+
+// testToken signs a session token for the user, as the auth service does
+func testToken(t *testing.T, hub *Hub, user *storage.User) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id":  user.ID,
+		"username": user.Username,
+		"role":     user.Role,
+		"ver":      user.TokenVersion,
+		"exp":      time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(hub.cfg.JWTSecret))
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+	return token
+}
+
+func isAck(clientMsgID string) func(Envelope) bool {
+	return func(env Envelope) bool {
+		return env.Type == TypeAck && env.ClientMsgID == clientMsgID
+	}
+}
@@ -2,143 +2,884 @@ package chat
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
-	"github.com/gorilla/websocket"
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/internal/notify"
 	"github.com/Ryan-Gosusluging/forum/internal/storage"
 	"github.com/Ryan-Gosusluging/forum/pkg/config"
 	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/gorilla/websocket"
 )
 
+// Client is a websocket connection. Anonymous clients have no userID and may
+// only read.
 type Client struct {
 	hub      *Hub
 	conn     *websocket.Conn
 	send     chan []byte
 	userID   *int64
 	username *string
+	// ip is the address of the client, limited like the user
+	ip string
+	// role is the site role of the user, moderators may remove any message
+	role string
+	// readOnly is set for API tokens without the chat:write scope
+	readOnly bool
+	// apiToken is set for API tokens, which cannot moderate
+	apiToken bool
+	// id identifies the connection within its hub, assigned on registration
+	id uint64
+	// rooms holds the joined rooms, guarded by hub.mu
+	rooms map[int64]bool
+	// replaying holds the live events of rooms whose history is still being
+	// replayed, guarded by hub.mu
+	replaying map[int64][]outbound
+	// since is the last message ID the client has seen, from ?since= or a
+	// resume frame. Only the read goroutine uses it.
+	since int64
+	// present holds the rooms in which the client counts for presence,
+	// guarded by hub.mu
+	present map[int64]bool
+	// typing holds the rooms the user is typing in and typingStarted when
+	// they last started, guarded by typingMu
+	typingMu      sync.Mutex
+	typing        map[int64]*typingState
+	typingStarted map[int64]time.Time
+	// closeCode and closeText are sent in the close frame. They are set
+	// before send is closed and read by writePump afterwards.
+	closeCode int
+	closeText string
+}
+
+// outbound is a frame for the subscribers of a room except the connection
+// skip. If users is set, the frame goes to all connections of those users
+// instead.
+type outbound struct {
+	room  int64
+	users []int64
+	data  []byte
+	skip  uint64
+	// msgID is the ID of a chat message event, used to drop live copies of
+	// replayed messages
+	msgID int64
 }
 
+// Hub tracks the connections of this instance. Events are published to the
+// broker, which hands them back to the hubs of all instances for delivery.
 type Hub struct {
-	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
-	mu         sync.RWMutex
-	chatRepo   *storage.ChatRepository
-	cfg        *config.Config
+	id           string
+	clients      map[*Client]bool
+	rooms        map[int64]map[*Client]bool
+	users        map[int64]map[*Client]bool
+	register     chan *Client
+	unregister   chan *Client
+	done         chan struct{}
+	nextClientID uint64
+	mu           sync.RWMutex
+	broker       Broker
+	presence     *Presence
+	chatRepo     *storage.ChatRepository
+	roomRepo     *storage.RoomRepository
+	convRepo     *storage.ConversationRepository
+	blockRepo    *storage.BlockRepository
+	auditRepo    *storage.AuditRepository
+	sanctionRepo *storage.SanctionRepository
+	userRepo     *storage.UserRepository
+	notifier     *notify.Service
+	// archive keeps purged messages, nil if archival is off
+	archive     ArchiveStore
+	userLimiter *rateLimiter
+	ipLimiter   *rateLimiter
+	duplicates  *duplicateFilter
+	cfg         *config.Config
 }
 
-func NewHub(chatRepo *storage.ChatRepository, cfg *config.Config) *Hub {
+func NewHub(chatRepo *storage.ChatRepository, roomRepo *storage.RoomRepository, convRepo *storage.ConversationRepository, blockRepo *storage.BlockRepository, presenceRepo *storage.PresenceRepository, auditRepo *storage.AuditRepository, sanctionRepo *storage.SanctionRepository, userRepo *storage.UserRepository, notifier *notify.Service, broker Broker, archive ArchiveStore, cfg *config.Config) *Hub {
+	id := newHubID()
 	return &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		chatRepo:   chatRepo,
-		cfg:        cfg,
+		id:           id,
+		clients:      make(map[*Client]bool),
+		rooms:        make(map[int64]map[*Client]bool),
+		users:        make(map[int64]map[*Client]bool),
+		register:     make(chan *Client),
+		unregister:   make(chan *Client),
+		done:         make(chan struct{}),
+		broker:       broker,
+		presence:     NewPresence(presenceRepo, id),
+		chatRepo:     chatRepo,
+		roomRepo:     roomRepo,
+		convRepo:     convRepo,
+		blockRepo:    blockRepo,
+		auditRepo:    auditRepo,
+		sanctionRepo: sanctionRepo,
+		userRepo:     userRepo,
+		notifier:     notifier,
+		archive:      archive,
+		userLimiter:  newRateLimiter(cfg.ChatUserBurst, cfg.ChatUserRefill),
+		ipLimiter:    newRateLimiter(cfg.ChatIPBurst, cfg.ChatIPRefill),
+		duplicates:   newDuplicateFilter(cfg.ChatDuplicateWindow),
+		cfg:          cfg,
+	}
+}
+
+func newHubID() string {
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to read random bytes")
 	}
+	return hex.EncodeToString(raw)
 }
 
+// Run serves the connections of this instance until ctx is done, then closes
+// them. done is closed on return, as nobody receives from register and
+// unregister anymore.
 func (h *Hub) Run(ctx context.Context) {
-	// Start cleanup goroutine
-	go h.cleanupOldMessages(ctx)
+	defer close(h.done)
+
+	messages, err := h.broker.Subscribe(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to subscribe to chat broker")
+		return
+	}
+
+	go h.enforceRetention(ctx)
+	go h.presence.Run(ctx)
+	go h.pruneLimiters(ctx)
 
 	for {
 		select {
+		case <-ctx.Done():
+			h.closeAll()
+			return
+
 		case client := <-h.register:
 			h.mu.Lock()
+			h.nextClientID++
+			client.id = h.nextClientID
+			client.rooms = make(map[int64]bool)
+			client.replaying = make(map[int64][]outbound)
+			client.present = make(map[int64]bool)
 			h.clients[client] = true
+			metrics.Add(metricConnections, 1)
+			if client.userID != nil {
+				if h.users[*client.userID] == nil {
+					h.users[*client.userID] = make(map[*Client]bool)
+				}
+				h.users[*client.userID][client] = true
+			}
 			h.mu.Unlock()
 
 		case client := <-h.unregister:
 			h.mu.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
+			if h.clients[client] {
+				h.removeClient(client)
 			}
 			h.mu.Unlock()
 
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
+		case msg, ok := <-messages:
+			if !ok {
+				// Connections stay usable, but no longer get events
+				logger.Error().Msg("Chat broker subscription closed")
+				messages = nil
+				continue
 			}
-			h.mu.RUnlock()
+			h.receive(msg)
 		}
 	}
 }
 
-func (h *Hub) cleanupOldMessages(ctx context.Context) {
-	ticker := time.NewTicker(1 * time.Hour)
-	defer ticker.Stop()
+// receive handles a message from the broker
+func (h *Hub) receive(msg BrokerMessage) {
+	if msg.EvictUser != 0 {
+		h.evict(msg.Room, msg.EvictUser)
+		return
+	}
+	if msg.Disconnect != "" {
+		h.disconnect(msg.Users, msg.Data, msg.Disconnect)
+		return
+	}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			olderThan := time.Now().Add(-h.cfg.ChatMessageTTL)
-			if err := h.chatRepo.DeleteOldMessages(ctx, olderThan); err != nil {
-				logger.Error().Err(err).Msg("Failed to cleanup old messages")
+	var skip uint64
+	if msg.Origin == h.id {
+		skip = msg.Skip
+	}
+	h.deliver(outbound{room: msg.Room, users: msg.Users, data: msg.Data, skip: skip, msgID: msg.MessageID})
+}
+
+// publish hands an event to the broker
+func (h *Hub) publish(msg BrokerMessage) {
+	msg.Origin = h.id
+	if err := h.broker.Publish(context.Background(), msg); err != nil {
+		logger.Error().Err(err).Int64("room_id", msg.Room).Msg("Failed to publish chat event")
+	}
+}
+
+// removeClient forgets the client and closes its send channel, which makes
+// writePump close the connection. This is the only place send is closed, and
+// the caller must hold the write lock.
+func (h *Hub) removeClient(client *Client) {
+	for room := range client.rooms {
+		h.leaveRoom(client, room)
+	}
+	if client.userID != nil {
+		delete(h.users[*client.userID], client)
+		if len(h.users[*client.userID]) == 0 {
+			delete(h.users, *client.userID)
+		}
+	}
+	delete(h.clients, client)
+	close(client.send)
+	metrics.Add(metricConnections, -1)
+}
+
+// closeAll closes every connection when the hub stops
+func (h *Hub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.clients {
+		client.closeCode = websocket.CloseGoingAway
+		client.closeText = "server shutting down"
+		h.removeClient(client)
+	}
+}
+
+// evictSlowConsumer drops a client whose send buffer is full. The caller must
+// hold the write lock.
+func (h *Hub) evictSlowConsumer(client *Client) {
+	client.closeCode = websocket.CloseTryAgainLater
+	client.closeText = "slow consumer"
+	h.removeClient(client)
+	metrics.Add(metricEvictedSlowConsumer, 1)
+
+	logger.Warn().Uint64("client_id", client.id).Msg("Evicted slow chat client")
+}
+
+// leaveRoom unsubscribes the client from the room. The caller must hold the
+// write lock.
+func (h *Hub) leaveRoom(client *Client, room int64) {
+	delete(client.rooms, room)
+	delete(client.replaying, room)
+	if subscribers := h.rooms[room]; subscribers != nil {
+		delete(subscribers, client)
+		if len(subscribers) == 0 {
+			delete(h.rooms, room)
+		}
+	}
+}
+
+// deliver sends a frame to its recipients on this instance. Slow clients are
+// dropped, which modifies the client set.
+func (h *Hub) deliver(message outbound) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var recipients []*Client
+	if message.users != nil {
+		for _, userID := range message.users {
+			for client := range h.users[userID] {
+				recipients = append(recipients, client)
 			}
 		}
+	} else {
+		for client := range h.rooms[message.room] {
+			recipients = append(recipients, client)
+		}
+	}
+
+	for _, client := range recipients {
+		if client.id == message.skip || !h.clients[client] {
+			continue
+		}
+		// Live events wait until the history before them has been sent
+		if pending, ok := client.replaying[message.room]; ok && message.users == nil {
+			if len(pending) >= cap(client.send) {
+				h.evictSlowConsumer(client)
+				continue
+			}
+			client.replaying[message.room] = append(pending, message)
+			continue
+		}
+		select {
+		case client.send <- message.data:
+		default:
+			h.evictSlowConsumer(client)
+		}
+	}
+}
+
+// announcePresence tells the other subscribers of the room that a signed-in
+// user came or went
+func (h *Hub) announcePresence(client *Client, room int64, status string) {
+	if client.userID == nil {
+		return
+	}
+	h.Broadcast(Envelope{Type: TypePresence, Room: room, User: client.user(), Status: status}, client)
+}
+
+// Broadcast publishes an event for the subscribers of its room except skip,
+// which may be nil
+func (h *Hub) Broadcast(env Envelope, skip *Client) {
+	data, err := env.encode()
+	if err != nil {
+		logger.Error().Err(err).Str("type", env.Type).Msg("Failed to encode frame")
+		return
+	}
+
+	msg := BrokerMessage{Room: env.Room, Data: data}
+	if env.Type == TypeMessage {
+		msg.MessageID = env.ID
+	}
+	if skip != nil {
+		msg.Skip = skip.id
+	}
+	h.publish(msg)
+}
+
+// SendToUsers publishes an event for all connections of the users
+func (h *Hub) SendToUsers(env Envelope, userIDs []int64) {
+	data, err := env.encode()
+	if err != nil {
+		logger.Error().Err(err).Str("type", env.Type).Msg("Failed to encode frame")
+		return
+	}
+	h.publish(BrokerMessage{Users: userIDs, Data: data})
+}
+
+// subscribe adds a registered client to a room. Live events of the room are
+// held back until finishReplay is called. It returns false if the client has
+// joined too many rooms.
+func (h *Hub) subscribe(client *Client, room int64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.clients[client] {
+		return false
+	}
+	if client.rooms[room] {
+		return true
+	}
+	if len(client.rooms) >= maxRoomsPerClient {
+		return false
+	}
+
+	client.rooms[room] = true
+	client.replaying[room] = nil
+	if h.rooms[room] == nil {
+		h.rooms[room] = make(map[*Client]bool)
+	}
+	h.rooms[room][client] = true
+	return true
+}
+
+// unsubscribe removes a client from a room and reports whether it had joined
+func (h *Hub) unsubscribe(client *Client, room int64) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.clients[client] || !client.rooms[room] {
+		return false
+	}
+	h.leaveRoom(client, room)
+	return true
+}
+
+func (h *Hub) isSubscribed(client *Client, room int64) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return client.rooms[room]
+}
+
+// EvictUser unsubscribes all connections of the user from the room on every
+// instance, for example after the user was removed from a private room. Each
+// connection is sent a leave frame.
+func (h *Hub) EvictUser(room, userID int64) {
+	h.publish(BrokerMessage{Room: room, EvictUser: userID})
+}
+
+// evict removes the connections of the user on this instance from the room
+func (h *Hub) evict(room, userID int64) {
+	data, err := Envelope{Type: TypeLeave, Room: room}.encode()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to encode leave frame")
+		return
+	}
+
+	h.mu.Lock()
+	var evicted []*Client
+	for client := range h.rooms[room] {
+		if client.userID != nil && *client.userID == userID {
+			evicted = append(evicted, client)
+		}
+	}
+	for _, client := range evicted {
+		h.leaveRoom(client, room)
+		select {
+		case client.send <- data:
+		default:
+		}
+	}
+	h.mu.Unlock()
+
+	// evict runs on the hub loop, which must not publish itself
+	if len(evicted) > 0 {
+		go func() {
+			for _, client := range evicted {
+				client.stopTyping(room, true)
+				h.leavePresence(client, room)
+			}
+		}()
 	}
 }
 
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+		}
 		c.conn.Close()
+		c.hub.disconnectPresence(c)
 	}()
 
+	c.hub.connectPresence(c)
+
+	cfg := c.hub.cfg
+	c.conn.SetReadLimit(cfg.ChatMaxMessageBytes)
+	c.conn.SetReadDeadline(time.Now().Add(cfg.ChatPongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(cfg.ChatPongTimeout))
+	})
+
 	for {
-		_, message, err := c.conn.ReadMessage()
+		_, data, err := c.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			var netErr net.Error
+			switch {
+			case errors.Is(err, websocket.ErrReadLimit):
+				// The connection has already sent the close frame
+				metrics.Add(metricClosedReadLimit, 1)
+			case errors.As(err, &netErr) && netErr.Timeout():
+				metrics.Add(metricClosedPongTimeout, 1)
+			case websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure):
 				logger.Error().Err(err).Msg("WebSocket read error")
 			}
 			break
 		}
 
-		// Store message in database
-		ctx := context.Background()
-		_, err = c.hub.chatRepo.CreateMessage(ctx, c.userID, string(message))
+		c.handleFrame(data)
+	}
+}
+
+// handleFrame validates a client frame and dispatches it by type
+func (c *Client) handleFrame(data []byte) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		c.sendError(ErrCodeBadRequest, "frames must be JSON envelopes", "")
+		return
+	}
+
+	if env.V != 0 && env.V != ProtocolVersion {
+		c.sendError(ErrCodeUnsupportedVersion, "unsupported protocol version", env.ClientMsgID)
+		return
+	}
+	if utf8.RuneCountInString(env.ClientMsgID) > maxClientMsgIDLength {
+		c.sendError(ErrCodeBadRequest, "client_msg_id is too long", "")
+		return
+	}
+
+	// Typing frames are frequent by nature and limited on their own
+	if env.Type != TypeTyping && !c.allowFrame(env) {
+		return
+	}
+
+	ctx := context.Background()
+
+	// Everyone may join and leave rooms they can read
+	switch env.Type {
+	case TypeJoin:
+		c.handleJoin(ctx, env)
+		return
+	case TypeLeave:
+		c.handleLeave(env)
+		return
+	case TypeResume:
+		c.handleResume(ctx, env)
+		return
+	case TypeMessage, TypeDM, TypeRead, TypeEdit, TypeDelete, TypeTyping, TypeReaction, TypePin, TypeUnpin:
+	default:
+		c.sendError(ErrCodeBadRequest, "unsupported frame type", env.ClientMsgID)
+		return
+	}
+
+	// Only registered users may send
+	if c.userID == nil {
+		c.sendError(ErrCodeAuthRequired, "sign in to send messages", env.ClientMsgID)
+		return
+	}
+	if c.readOnly {
+		c.sendError(ErrCodeForbidden, "token lacks the chat:write scope", env.ClientMsgID)
+		return
+	}
+	// Muted users may still delete their messages and mark conversations read
+	if (env.Type == TypeMessage || env.Type == TypeDM || env.Type == TypeEdit || env.Type == TypeReaction) && !c.allowedToSend(ctx, env) {
+		return
+	}
+
+	switch env.Type {
+	case TypeMessage:
+		c.handleMessage(ctx, env)
+	case TypeDM:
+		c.handleDirectMessage(ctx, env)
+	case TypeRead:
+		c.handleRead(ctx, env)
+	case TypeEdit:
+		c.handleEdit(ctx, env)
+	case TypeDelete:
+		c.handleDelete(ctx, env)
+	case TypeTyping:
+		c.handleTyping(env)
+	case TypeReaction:
+		c.handleReaction(ctx, env)
+	case TypePin, TypeUnpin:
+		c.handlePin(ctx, env)
+	}
+}
+
+func (c *Client) handleJoin(ctx context.Context, env Envelope) {
+	if env.Room <= 0 {
+		c.sendError(ErrCodeBadRequest, "room is required", env.ClientMsgID)
+		return
+	}
+
+	room, err := c.hub.readableRoom(ctx, env.Room, c.userID)
+	if err != nil {
+		logger.Error().Err(err).Int64("room_id", env.Room).Msg("Failed to look up room")
+		c.sendError(ErrCodeInternal, "failed to join room", env.ClientMsgID)
+		return
+	}
+	if room == nil {
+		c.sendError(ErrCodeNotFound, "room not found", env.ClientMsgID)
+		return
+	}
+
+	if c.hub.isSubscribed(c, room.ID) {
+		c.sendFrame(Envelope{Type: TypeAck, Room: room.ID, ClientMsgID: env.ClientMsgID})
+		return
+	}
+	if !c.hub.subscribe(c, room.ID) {
+		c.sendError(ErrCodeBadRequest, "too many rooms joined", env.ClientMsgID)
+		return
+	}
+
+	c.sendFrame(Envelope{Type: TypeAck, Room: room.ID, ClientMsgID: env.ClientMsgID})
+
+	since := c.since
+	if env.Since > 0 {
+		since = env.Since
+	}
+	c.replay(ctx, room.ID, since)
+	c.hub.enterPresence(c, room.ID)
+}
+
+func (c *Client) handleLeave(env Envelope) {
+	if !c.hub.unsubscribe(c, env.Room) {
+		c.sendError(ErrCodeNotFound, "room not joined", env.ClientMsgID)
+		return
+	}
+
+	c.sendFrame(Envelope{Type: TypeAck, Room: env.Room, ClientMsgID: env.ClientMsgID})
+	c.stopTyping(env.Room, true)
+	c.hub.leavePresence(c, env.Room)
+}
+
+// joinedRoom checks that the frame names a room the client has joined
+func (c *Client) joinedRoom(env Envelope) bool {
+	if env.Room <= 0 {
+		c.sendError(ErrCodeBadRequest, "room is required", env.ClientMsgID)
+		return false
+	}
+	if !c.hub.isSubscribed(c, env.Room) {
+		c.sendError(ErrCodeForbidden, "join the room first", env.ClientMsgID)
+		return false
+	}
+	return true
+}
+
+func (c *Client) handleMessage(ctx context.Context, env Envelope) {
+	if !c.joinedRoom(env) {
+		return
+	}
+	content, ok := c.validContent(env)
+	if !ok {
+		return
+	}
+	if strings.HasPrefix(content, "/") && c.handleCommand(ctx, env, content) {
+		return
+	}
+	if !c.allowSlowMode(ctx, env) || !c.allowContent(env, env.Room, content) {
+		return
+	}
+
+	var replyToID *int64
+	if env.ReplyToID != 0 {
+		replied, err := c.hub.chatRepo.GetMessage(ctx, env.ReplyToID)
 		if err != nil {
-			logger.Error().Err(err).Msg("Failed to store message")
-			continue
+			logger.Error().Err(err).Int64("message_id", env.ReplyToID).Msg("Failed to get message")
+			c.sendError(ErrCodeInternal, "failed to send message", env.ClientMsgID)
+			return
+		}
+		if replied == nil || replied.RoomID != env.Room {
+			c.sendError(ErrCodeNotFound, "replied to message not found", env.ClientMsgID)
+			return
 		}
+		replyToID = &replied.ID
+	}
+
+	// Store message in database, the ID is assigned by the database
+	msg, err := c.hub.chatRepo.CreateMessage(ctx, env.Room, *c.userID, content, replyToID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to store message")
+		c.sendError(ErrCodeInternal, "failed to send message", env.ClientMsgID)
+		return
+	}
+
+	// Receivers clear the typing indicator of the author on its message
+	c.stopTyping(env.Room, false)
+	c.sendAck(msg, env.ClientMsgID, &msg.CreatedAt)
+	c.hub.Broadcast(messageEnvelope(msg, env.ClientMsgID), nil)
+	go c.hub.notifyMentions(msg)
+}
+
+// handleEdit replaces the content of a message of the user. Messages can be
+// edited for ChatEditWindow after they were sent.
+func (c *Client) handleEdit(ctx context.Context, env Envelope) {
+	if env.ID <= 0 {
+		c.sendError(ErrCodeBadRequest, "id is required", env.ClientMsgID)
+		return
+	}
+	content, ok := c.validContent(env)
+	if !ok {
+		return
+	}
+
+	existing, err := c.hub.chatRepo.GetMessage(ctx, env.ID)
+	if err != nil {
+		logger.Error().Err(err).Int64("message_id", env.ID).Msg("Failed to get message")
+		c.sendError(ErrCodeInternal, "failed to edit message", env.ClientMsgID)
+		return
+	}
+	if existing == nil {
+		c.sendError(ErrCodeNotFound, "message not found", env.ClientMsgID)
+		return
+	}
+	if existing.UserID != *c.userID {
+		c.sendError(ErrCodeForbidden, "only the author can edit a message", env.ClientMsgID)
+		return
+	}
+
+	editableSince := time.Now().Add(-c.hub.cfg.ChatEditWindow)
+	if existing.CreatedAt.Before(editableSince) {
+		c.sendError(ErrCodeForbidden, "the message can no longer be edited", env.ClientMsgID)
+		return
+	}
+
+	// The message may have been deleted meanwhile
+	msg, err := c.hub.chatRepo.UpdateMessage(ctx, env.ID, *c.userID, content, editableSince)
+	if err != nil {
+		logger.Error().Err(err).Int64("message_id", env.ID).Msg("Failed to edit message")
+		c.sendError(ErrCodeInternal, "failed to edit message", env.ClientMsgID)
+		return
+	}
+	if msg == nil {
+		c.sendError(ErrCodeNotFound, "message not found", env.ClientMsgID)
+		return
+	}
+
+	c.sendAck(msg, env.ClientMsgID, nil)
+	c.hub.Broadcast(editEnvelope(msg, env.ClientMsgID), nil)
+}
+
+// handleDelete removes a message. Authors can delete their own messages and
+// moderators any message, even in rooms they have not joined; removals by
+// moderators need a session and are recorded in the audit log.
+func (c *Client) handleDelete(ctx context.Context, env Envelope) {
+	if env.ID <= 0 {
+		c.sendError(ErrCodeBadRequest, "id is required", env.ClientMsgID)
+		return
+	}
+
+	existing, err := c.hub.chatRepo.GetMessage(ctx, env.ID)
+	if err != nil {
+		logger.Error().Err(err).Int64("message_id", env.ID).Msg("Failed to get message")
+		c.sendError(ErrCodeInternal, "failed to delete message", env.ClientMsgID)
+		return
+	}
+	if existing == nil {
+		c.sendError(ErrCodeNotFound, "message not found", env.ClientMsgID)
+		return
+	}
+	moderated := existing.UserID != *c.userID
+	if moderated && !auth.IsModerator(c.role) {
+		c.sendError(ErrCodeForbidden, "only the author or a moderator can delete a message", env.ClientMsgID)
+		return
+	}
+	if moderated && c.apiToken {
+		c.sendError(ErrCodeForbidden, "API tokens cannot remove messages of other users", env.ClientMsgID)
+		return
+	}
+
+	msg, err := c.hub.chatRepo.DeleteMessage(ctx, env.ID, *c.userID)
+	if err != nil {
+		logger.Error().Err(err).Int64("message_id", env.ID).Msg("Failed to delete message")
+		c.sendError(ErrCodeInternal, "failed to delete message", env.ClientMsgID)
+		return
+	}
+	if msg == nil {
+		c.sendError(ErrCodeNotFound, "message not found", env.ClientMsgID)
+		return
+	}
+
+	if moderated {
+		c.hub.auditRemoval(ctx, msg, *c.userID)
+	}
+
+	c.sendAck(msg, env.ClientMsgID, nil)
+	c.hub.Broadcast(Envelope{
+		Type:        TypeDelete,
+		ID:          msg.ID,
+		Room:        msg.RoomID,
+		User:        c.user(),
+		ClientMsgID: env.ClientMsgID,
+	}, nil)
+}
+
+// auditRemoval records the removal of a message of another user
+func (h *Hub) auditRemoval(ctx context.Context, msg *storage.ChatMessage, moderatorID int64) {
+	h.recordAudit(ctx, &storage.AuditEntry{
+		ActorID:    &moderatorID,
+		Action:     storage.AuditActionChatMessageRemoved,
+		TargetType: storage.AuditTargetChatMessage,
+		TargetID:   msg.ID,
+		Details: map[string]interface{}{
+			"room_id":   msg.RoomID,
+			"author_id": msg.UserID,
+			"content":   msg.Content,
+		},
+	})
+
+	logger.Info().Int64("message_id", msg.ID).Int64("moderator_id", moderatorID).Msg("Chat message removed by moderator")
+}
+
+func (c *Client) validContent(env Envelope) (string, bool) {
+	content := strings.TrimSpace(env.Content)
+	if content == "" {
+		c.sendError(ErrCodeBadRequest, "content is required", env.ClientMsgID)
+		return "", false
+	}
+	if utf8.RuneCountInString(content) > maxContentLength {
+		c.sendError(ErrCodeBadRequest, "content is too long", env.ClientMsgID)
+		return "", false
+	}
+	return content, true
+}
+
+func (c *Client) user() *UserRef {
+	if c.userID == nil {
+		return nil
+	}
+	ref := &UserRef{ID: *c.userID}
+	if c.username != nil {
+		ref.Username = *c.username
+	}
+	return ref
+}
+
+// sendAck confirms a processed frame to its sender so that it can match the
+// server ID to its client_msg_id
+func (c *Client) sendAck(msg *storage.ChatMessage, clientMsgID string, createdAt *time.Time) {
+	if createdAt != nil {
+		utc := createdAt.UTC()
+		createdAt = &utc
+	}
+	c.sendFrame(Envelope{Type: TypeAck, ID: msg.ID, Room: msg.RoomID, ClientMsgID: clientMsgID, CreatedAt: createdAt})
+}
+
+func (c *Client) sendError(code, message, clientMsgID string) {
+	c.sendFrame(errorEnvelope(code, message, clientMsgID))
+}
+
+// sendFrame queues a frame for this client only. The frame is dropped if the
+// client is gone or its buffer is full.
+func (c *Client) sendFrame(env Envelope) {
+	data, err := env.encode()
+	if err != nil {
+		logger.Error().Err(err).Str("type", env.Type).Msg("Failed to encode frame")
+		return
+	}
+
+	// Holding the read lock keeps the hub from closing the channel meanwhile
+	c.hub.mu.RLock()
+	defer c.hub.mu.RUnlock()
 
-		// Broadcast message to all clients
-		c.hub.broadcast <- []byte(message)
+	if !c.hub.clients[c] {
+		return
+	}
+	select {
+	case c.send <- data:
+	default:
+		metrics.Add(metricDroppedDirectFrames, 1)
 	}
 }
 
 func (c *Client) writePump() {
+	cfg := c.hub.cfg
+	ticker := time.NewTicker(cfg.ChatPingInterval)
 	defer func() {
+		ticker.Stop()
 		c.conn.Close()
 	}()
 
 	for {
 		select {
 		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(cfg.ChatWriteTimeout))
 			if !ok {
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				// The hub removed the client
+				code := c.closeCode
+				if code == 0 {
+					code = websocket.CloseNormalClosure
+				}
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, c.closeText))
 				return
 			}
 
 			w, err := c.conn.NextWriter(websocket.TextMessage)
 			if err != nil {
+				metrics.Add(metricClosedWriteError, 1)
 				return
 			}
 			w.Write(message)
 
 			if err := w.Close(); err != nil {
+				metrics.Add(metricClosedWriteError, 1)
+				return
+			}
+
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(cfg.ChatWriteTimeout))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				metrics.Add(metricClosedWriteError, 1)
 				return
 			}
 		}
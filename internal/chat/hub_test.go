@@ -0,0 +1,208 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/internal/notify"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/config"
+	"github.com/gorilla/websocket"
+)
+
+// testDatabaseEnv names the variable with the URL of a migrated database.
+// Tests that need Postgres are skipped when it is not set.
+const testDatabaseEnv = "FORUM_TEST_DATABASE_URL"
+
+// testTimeout bounds every wait for a frame or a hub change
+const testTimeout = 5 * time.Second
+
+// testDB connects to the test database or skips the test
+func testDB(t *testing.T) (*storage.DB, string) {
+	t.Helper()
+
+	connStr := os.Getenv(testDatabaseEnv)
+	if connStr == "" {
+		t.Skip(testDatabaseEnv + " is not set")
+	}
+
+	db, err := storage.NewDB(connStr)
+	if err != nil {
+		t.Fatalf("connect to test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db, connStr
+}
+
+// newTestHub starts a hub on the broker. Without db the hub has no
+// repositories, which is enough for anonymous connections and for events
+// published on the hub directly.
+func newTestHub(t *testing.T, broker Broker, db *storage.DB) *Hub {
+	t.Helper()
+
+	cfg := config.NewConfig()
+	if db == nil {
+		hub := NewHub(nil, nil, nil, nil, nil, nil, nil, nil, nil, broker, nil, cfg)
+		// Stopping the hub also stops presence, which needs its repository,
+		// so this hub runs until the test binary exits
+		go hub.Run(context.Background())
+		return hub
+	}
+
+	userRepo := storage.NewUserRepository(db)
+	blockRepo := storage.NewBlockRepository(db)
+	notifier := notify.NewService(storage.NewNotificationRepository(db), userRepo, nil, nil, blockRepo)
+	hub := NewHub(
+		storage.NewChatRepository(db),
+		storage.NewRoomRepository(db),
+		storage.NewConversationRepository(db),
+		blockRepo,
+		storage.NewPresenceRepository(db),
+		storage.NewAuditRepository(db),
+		storage.NewSanctionRepository(db),
+		userRepo,
+		notifier,
+		broker,
+		nil,
+		cfg,
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go hub.Run(ctx)
+
+	return hub
+}
+
+// newTestServer serves the websocket endpoint of the hub
+func newTestServer(t *testing.T, hub *Hub, db *storage.DB) *httptest.Server {
+	t.Helper()
+
+	var userRepo *storage.UserRepository
+	var accessTokenRepo *storage.AccessTokenRepository
+	if db != nil {
+		userRepo = storage.NewUserRepository(db)
+		accessTokenRepo = storage.NewAccessTokenRepository(db)
+	}
+	authService := auth.NewService(userRepo, nil, nil, accessTokenRepo, nil, nil, nil, nil, hub.cfg)
+
+	server := httptest.NewServer(NewHandler(hub, authService))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// dial opens a websocket connection, signed in if token is set, and returns
+// the frames the server sends on it
+func dial(t *testing.T, server *httptest.Server, token string) (*websocket.Conn, <-chan Envelope) {
+	t.Helper()
+
+	endpoint := "ws" + strings.TrimPrefix(server.URL, "http")
+	if token != "" {
+		endpoint += "?token=" + url.QueryEscape(token)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(endpoint, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", endpoint, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	frames := make(chan Envelope, 64)
+	go func() {
+		defer close(frames)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var env Envelope
+			if err := json.Unmarshal(data, &env); err == nil {
+				frames <- env
+			}
+		}
+	}()
+
+	return conn, frames
+}
+
+func sendFrame(t *testing.T, conn *websocket.Conn, env Envelope) {
+	t.Helper()
+
+	if err := conn.WriteJSON(env); err != nil {
+		t.Fatalf("send %s frame: %v", env.Type, err)
+	}
+}
+
+// expectFrame returns the first frame that matches, skipping others such as
+// presence events
+func expectFrame(t *testing.T, frames <-chan Envelope, match func(Envelope) bool) Envelope {
+	t.Helper()
+
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case env, ok := <-frames:
+			if !ok {
+				t.Fatal("connection closed while waiting for a frame")
+			}
+			if match(env) {
+				return env
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for a frame")
+		}
+	}
+}
+
+// waitFor polls cond until it holds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// hubClients returns the connections registered with the hub
+func hubClients(hub *Hub) []*Client {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+
+	clients := make([]*Client, 0, len(hub.clients))
+	for client := range hub.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// onlyClient waits until the hub has exactly one connection and returns it
+func onlyClient(t *testing.T, hub *Hub) *Client {
+	t.Helper()
+
+	var clients []*Client
+	waitFor(t, "a single connection", func() bool {
+		clients = hubClients(hub)
+		return len(clients) == 1
+	})
+	return clients[0]
+}
+
+// joinRoom subscribes a connection to a room without looking the room up,
+// as a join frame would
+func joinRoom(hub *Hub, client *Client, room int64) {
+	hub.subscribe(client, room)
+	hub.finishReplay(client, room, nil, 0)
+}
@@ -0,0 +1,83 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+var errBrokerClosed = errors.New("broker is closed")
+
+// MemoryBroker fans events out within a single process. It is enough for a
+// single instance and lets several hubs share one process in development.
+type MemoryBroker struct {
+	mu          sync.RWMutex
+	subscribers map[chan BrokerMessage]bool
+	closed      bool
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		subscribers: make(map[chan BrokerMessage]bool),
+	}
+}
+
+// Publish never blocks: a subscriber whose buffer is full misses the message,
+// as a hub that falls behind must not stall the hubs publishing to it
+func (b *MemoryBroker) Publish(ctx context.Context, msg BrokerMessage) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return errBrokerClosed
+	}
+	for ch := range b.subscribers {
+		select {
+		case ch <- msg:
+		default:
+			metrics.Add(metricDroppedBrokerMessages, 1)
+			logger.Warn().Int64("room_id", msg.Room).Msg("Chat broker subscriber is full, message dropped")
+		}
+	}
+	return nil
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context) (<-chan BrokerMessage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, errBrokerClosed
+	}
+
+	ch := make(chan BrokerMessage, 256)
+	b.subscribers[ch] = true
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.subscribers[ch] {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}()
+
+	return ch, nil
+}
+
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.closed {
+		b.closed = true
+		for ch := range b.subscribers {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return nil
+}
@@ -1,29 +1,40 @@
 package chat
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
 	"github.com/Ryan-Gosusluging/forum/pkg/logger"
 )
 
 type MessageResponse struct {
-	ID        int64   `json:"id"`
-	UserID    *int64  `json:"user_id,omitempty"`
-	Username  *string `json:"username,omitempty"`
-	Content   string  `json:"content"`
-	CreatedAt string  `json:"created_at"`
+	ID        int64      `json:"id"`
+	RoomID    int64      `json:"room_id"`
+	UserID    int64      `json:"user_id"`
+	Username  string     `json:"username"`
+	Content   string     `json:"content"`
+	ReplyToID *int64     `json:"reply_to_id,omitempty"`
+	Quote     *Quote     `json:"quote,omitempty"`
+	Reactions []Reaction `json:"reactions,omitempty"`
+	CreatedAt string     `json:"created_at"`
+	EditedAt  *string    `json:"edited_at,omitempty"`
+	PinnedAt  *string    `json:"pinned_at,omitempty"`
 }
 
 type MessagesHandler struct {
-	hub *Hub
+	hub  *Hub
+	auth *auth.Service
 }
 
-func NewMessagesHandler(hub *Hub) *MessagesHandler {
+func NewMessagesHandler(hub *Hub, auth *auth.Service) *MessagesHandler {
 	return &MessagesHandler{
-		hub: hub,
+		hub:  hub,
+		auth: auth,
 	}
 }
 
@@ -45,24 +56,37 @@ func (h *MessagesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Получаем сообщения из базы данных
+	// Private rooms need a token, public rooms can be read anonymously
+	userID, ok := optionalUser(w, r, h.auth)
+	if !ok {
+		return
+	}
+
 	ctx := r.Context()
-	messages, err := h.hub.chatRepo.GetRecentMessages(ctx, limit)
+	room, err := h.requestedRoom(r, userID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get room")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if room == nil {
+		http.Error(w, "Room not found", http.StatusNotFound)
+		return
+	}
+
+	// Получаем сообщения из базы данных
+	messages, err := h.hub.chatRepo.GetRecentMessages(ctx, room.ID, limit)
 	if err != nil {
 		logger.Error().Err(err).Msg("Failed to get messages")
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
 
-	// Преобразуем сообщения в формат ответа
-	response := make([]MessageResponse, len(messages))
-	for i, msg := range messages {
-		response[i] = MessageResponse{
-			ID:        msg.ID,
-			UserID:    msg.UserID,
-			Content:   msg.Content,
-			CreatedAt: msg.CreatedAt.Format(time.RFC3339),
-		}
+	response, err := h.hub.messageResponses(ctx, messages)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get reactions")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
 
 	// Отправляем ответ
@@ -73,3 +97,55 @@ func (h *MessagesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// requestedRoom returns the room given by the room parameter, or the default
+// room if there is none. It returns nil if the user may not read the room.
+func (h *MessagesHandler) requestedRoom(r *http.Request, userID *int64) (*storage.Room, error) {
+	roomStr := r.URL.Query().Get("room")
+	if roomStr == "" {
+		return h.hub.roomRepo.GetRoomBySlug(r.Context(), storage.DefaultRoomSlug)
+	}
+
+	roomID, err := strconv.ParseInt(roomStr, 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+	return h.hub.readableRoom(r.Context(), roomID, userID)
+}
+
+// messageResponses converts messages to the response format, with their
+// reaction counts
+func (h *Hub) messageResponses(ctx context.Context, messages []*storage.ChatMessage) ([]MessageResponse, error) {
+	ids := make([]int64, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+	reactions, err := h.chatRepo.GetReactions(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]MessageResponse, len(messages))
+	for i, msg := range messages {
+		response[i] = MessageResponse{
+			ID:        msg.ID,
+			RoomID:    msg.RoomID,
+			UserID:    msg.UserID,
+			Username:  msg.Username,
+			Content:   msg.Content,
+			ReplyToID: msg.ReplyToID,
+			Quote:     toQuote(msg),
+			Reactions: toReactions(reactions[msg.ID]),
+			CreatedAt: msg.CreatedAt.Format(time.RFC3339),
+		}
+		if msg.EditedAt != nil {
+			editedAt := msg.EditedAt.Format(time.RFC3339)
+			response[i].EditedAt = &editedAt
+		}
+		if msg.PinnedAt != nil {
+			pinnedAt := msg.PinnedAt.Format(time.RFC3339)
+			response[i].PinnedAt = &pinnedAt
+		}
+	}
+	return response, nil
+}
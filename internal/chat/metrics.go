@@ -0,0 +1,21 @@
+package chat
+
+import "expvar"
+
+// metrics are published by expvar as "chat" on /debug/vars
+var metrics = expvar.NewMap("chat")
+
+const (
+	metricConnections           = "connections"
+	metricEvictedSlowConsumer   = "evicted_slow_consumer"
+	metricClosedReadLimit       = "closed_read_limit"
+	metricClosedPongTimeout     = "closed_pong_timeout"
+	metricClosedWriteError      = "closed_write_error"
+	metricDroppedDirectFrames   = "dropped_direct_frames"
+	metricDroppedBrokerMessages = "dropped_broker_messages"
+	metricRejectedRateLimit     = "rejected_rate_limit"
+	metricRejectedDuplicate     = "rejected_duplicate"
+	metricRejectedSlowMode      = "rejected_slow_mode"
+	metricRetentionPurged       = "retention_purged"
+	metricRetentionArchived     = "retention_archived"
+)
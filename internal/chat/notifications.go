@@ -0,0 +1,30 @@
+package chat
+
+import (
+	"context"
+
+	"github.com/Ryan-Gosusluging/forum/internal/notify"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+// PushNotification sends a new notification to the connections of its user,
+// on every instance
+func (h *Hub) PushNotification(n *storage.Notification) {
+	response := notify.ToNotificationResponse(n)
+	h.SendToUsers(Envelope{Type: TypeNotification, Notification: &response}, []int64{n.UserID})
+}
+
+// notifyMentions notifies the users mentioned in a room message. It runs
+// after the message was delivered, so that lookups do not delay the room.
+func (h *Hub) notifyMentions(msg *storage.ChatMessage) {
+	ctx := context.Background()
+	h.notifier.ChatMessageCreated(ctx, msg, func(userID int64) bool {
+		room, err := h.readableRoom(ctx, msg.RoomID, &userID)
+		if err != nil {
+			logger.Error().Err(err).Int64("room_id", msg.RoomID).Msg("Failed to get room")
+			return false
+		}
+		return room != nil
+	})
+}
@@ -0,0 +1,76 @@
+package chat
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+type OnlineUserResponse struct {
+	UserID   int64  `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// OnlineHandler lists the users connected to the chat, or to the room given
+// by the room parameter, under /api/chat/online
+type OnlineHandler struct {
+	hub  *Hub
+	auth *auth.Service
+}
+
+func NewOnlineHandler(hub *Hub, auth *auth.Service) *OnlineHandler {
+	return &OnlineHandler{
+		hub:  hub,
+		auth: auth,
+	}
+}
+
+func (h *OnlineHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Private rooms need a token, like their messages
+	userID, ok := optionalUser(w, r, h.auth)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	room := siteRoom
+	if roomStr := r.URL.Query().Get("room"); roomStr != "" {
+		roomID, err := strconv.ParseInt(roomStr, 10, 64)
+		if err != nil || roomID <= 0 {
+			http.Error(w, "Invalid room parameter", http.StatusBadRequest)
+			return
+		}
+
+		readable, err := h.hub.readableRoom(ctx, roomID, userID)
+		if err != nil {
+			logger.Error().Err(err).Int64("room_id", roomID).Msg("Failed to get room")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if readable == nil {
+			http.Error(w, "Room not found", http.StatusNotFound)
+			return
+		}
+		room = readable.ID
+	}
+
+	online, err := h.hub.presence.Online(ctx, room)
+	if err != nil {
+		logger.Error().Err(err).Int64("room_id", room).Msg("Failed to get online users")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]OnlineUserResponse, len(online))
+	for i, u := range online {
+		response[i] = OnlineUserResponse{UserID: u.UserID, Username: u.Username}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
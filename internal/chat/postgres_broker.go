@@ -0,0 +1,184 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/lib/pq"
+)
+
+const (
+	// fanoutChannel is the NOTIFY channel shared by all instances
+	fanoutChannel = "chat_fanout"
+	// maxNotifyPayload stays below the 8000 byte NOTIFY limit
+	maxNotifyPayload = 7900
+	// storedPayloadPrefix marks a notification that carries the ID of a
+	// stored payload instead of the payload itself
+	storedPayloadPrefix = "@"
+	storedPayloadTTL    = time.Minute
+
+	listenerMinReconnect = time.Second
+	listenerMaxReconnect = 30 * time.Second
+	listenerPingInterval = 90 * time.Second
+)
+
+// PostgresBroker fans events out to all instances with LISTEN/NOTIFY on the
+// existing database. The listener reconnects on its own; events published
+// while it is disconnected are lost, as NOTIFY does not queue them.
+type PostgresBroker struct {
+	connStr string
+	repo    *storage.FanoutRepository
+
+	mu        sync.Mutex
+	listeners []*pq.Listener
+	closed    bool
+}
+
+func NewPostgresBroker(connStr string, repo *storage.FanoutRepository) *PostgresBroker {
+	return &PostgresBroker{
+		connStr: connStr,
+		repo:    repo,
+	}
+}
+
+func (b *PostgresBroker) Publish(ctx context.Context, msg BrokerMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	payload := string(data)
+	if len(payload) > maxNotifyPayload {
+		id, err := b.repo.StorePayload(ctx, payload)
+		if err != nil {
+			return err
+		}
+		payload = storedPayloadPrefix + strconv.FormatInt(id, 10)
+	}
+
+	return b.repo.Notify(ctx, fanoutChannel, payload)
+}
+
+func (b *PostgresBroker) Subscribe(ctx context.Context) (<-chan BrokerMessage, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, errBrokerClosed
+	}
+
+	listener := pq.NewListener(b.connStr, listenerMinReconnect, listenerMaxReconnect, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventConnected:
+			logger.Info().Msg("Chat broker listener connected")
+		case pq.ListenerEventDisconnected:
+			logger.Warn().Err(err).Msg("Chat broker listener disconnected")
+		case pq.ListenerEventReconnected:
+			logger.Info().Msg("Chat broker listener reconnected")
+		case pq.ListenerEventConnectionAttemptFailed:
+			logger.Warn().Err(err).Msg("Chat broker listener failed to connect")
+		}
+	})
+	b.listeners = append(b.listeners, listener)
+
+	out := make(chan BrokerMessage, 256)
+	go b.listen(ctx, listener, out)
+
+	return out, nil
+}
+
+func (b *PostgresBroker) listen(ctx context.Context, listener *pq.Listener, out chan<- BrokerMessage) {
+	defer close(out)
+	defer listener.Close()
+
+	// Listen waits until the first connection is established
+	if err := listener.Listen(fanoutChannel); err != nil {
+		logger.Error().Err(err).Msg("Failed to listen for chat events")
+		return
+	}
+
+	ping := time.NewTicker(listenerPingInterval)
+	defer ping.Stop()
+	prune := time.NewTicker(storedPayloadTTL)
+	defer prune.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case n, ok := <-listener.Notify:
+			if !ok {
+				return
+			}
+			// A nil notification follows a reconnect; anything sent in
+			// between is gone
+			if n == nil {
+				logger.Warn().Msg("Chat broker listener reconnected, events may have been missed")
+				continue
+			}
+
+			msg, ok := b.decode(ctx, n.Extra)
+			if !ok {
+				continue
+			}
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+				return
+			}
+
+		case <-ping.C:
+			// Detects dead connections that would otherwise go unnoticed
+			go func() {
+				if err := listener.Ping(); err != nil {
+					logger.Warn().Err(err).Msg("Chat broker listener ping failed")
+				}
+			}()
+
+		case <-prune.C:
+			if err := b.repo.DeleteOldPayloads(ctx, time.Now().Add(-storedPayloadTTL)); err != nil {
+				logger.Error().Err(err).Msg("Failed to prune chat fan-out payloads")
+			}
+		}
+	}
+}
+
+func (b *PostgresBroker) decode(ctx context.Context, payload string) (BrokerMessage, bool) {
+	if strings.HasPrefix(payload, storedPayloadPrefix) {
+		id, err := strconv.ParseInt(strings.TrimPrefix(payload, storedPayloadPrefix), 10, 64)
+		if err != nil {
+			logger.Error().Err(err).Msg("Invalid chat fan-out payload reference")
+			return BrokerMessage{}, false
+		}
+		if payload, err = b.repo.GetPayload(ctx, id); err != nil || payload == "" {
+			logger.Error().Err(err).Int64("payload_id", id).Msg("Failed to load chat fan-out payload")
+			return BrokerMessage{}, false
+		}
+	}
+
+	var msg BrokerMessage
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		logger.Error().Err(err).Msg("Invalid chat fan-out payload")
+		return BrokerMessage{}, false
+	}
+	return msg, true
+}
+
+func (b *PostgresBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for _, listener := range b.listeners {
+		listener.Close()
+	}
+	b.listeners = nil
+	return nil
+}
@@ -0,0 +1,195 @@
+package chat
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+const (
+	// presenceHeartbeat is how often an instance refreshes its presence rows
+	presenceHeartbeat = 30 * time.Second
+	// presenceStaleAfter expires the rows of instances that stopped refreshing
+	presenceStaleAfter = 3 * presenceHeartbeat
+	// siteRoom records that a user is connected at all
+	siteRoom int64 = 0
+)
+
+type presenceKey struct {
+	userID int64
+	room   int64
+}
+
+// Presence tracks which users are online in which rooms. A user counts as
+// online while any connection, on any instance, is open; several tabs of the
+// same user come and go without presence events.
+type Presence struct {
+	instanceID string
+	repo       *storage.PresenceRepository
+
+	// mu serializes changes, so that the first and last connection of a
+	// user on this instance are detected reliably
+	mu    sync.Mutex
+	local map[presenceKey]int
+}
+
+func NewPresence(repo *storage.PresenceRepository, instanceID string) *Presence {
+	return &Presence{
+		instanceID: instanceID,
+		repo:       repo,
+		local:      make(map[presenceKey]int),
+	}
+}
+
+// Enter records a connection of the user in the room and reports whether the
+// user just came online there
+func (p *Presence) Enter(ctx context.Context, userID, room int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := presenceKey{userID: userID, room: room}
+	p.local[key]++
+	if p.local[key] > 1 {
+		return false
+	}
+
+	elsewhere, err := p.repo.IsPresent(ctx, userID, room, time.Now().Add(-presenceStaleAfter))
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to check presence")
+	}
+	if err := p.repo.AddPresence(ctx, p.instanceID, userID, room); err != nil {
+		logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to record presence")
+	}
+
+	return !elsewhere
+}
+
+// Leave records that a connection of the user left the room and reports
+// whether the user is now offline there
+func (p *Presence) Leave(ctx context.Context, userID, room int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := presenceKey{userID: userID, room: room}
+	if p.local[key] == 0 {
+		return false
+	}
+	p.local[key]--
+	if p.local[key] > 0 {
+		return false
+	}
+	delete(p.local, key)
+
+	if err := p.repo.RemovePresence(ctx, p.instanceID, userID, room); err != nil {
+		logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to remove presence")
+	}
+
+	elsewhere, err := p.repo.IsPresent(ctx, userID, room, time.Now().Add(-presenceStaleAfter))
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to check presence")
+	}
+
+	return !elsewhere
+}
+
+// Online returns the roster of the room, or of the whole chat for siteRoom
+func (p *Presence) Online(ctx context.Context, room int64) ([]storage.OnlineUser, error) {
+	return p.repo.GetOnlineUsers(ctx, room, time.Now().Add(-presenceStaleAfter))
+}
+
+// Run refreshes the presence rows of this instance and expires those of
+// instances that went away. The rows of this instance are removed on exit.
+func (p *Presence) Run(ctx context.Context) {
+	ticker := time.NewTicker(presenceHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := p.repo.DeleteInstancePresence(context.Background(), p.instanceID); err != nil {
+				logger.Error().Err(err).Msg("Failed to remove instance presence")
+			}
+			return
+		case <-ticker.C:
+			if err := p.repo.TouchInstancePresence(ctx, p.instanceID); err != nil {
+				logger.Error().Err(err).Msg("Failed to refresh presence")
+			}
+			if err := p.repo.DeleteStalePresence(ctx, time.Now().Add(-presenceStaleAfter)); err != nil {
+				logger.Error().Err(err).Msg("Failed to expire presence")
+			}
+		}
+	}
+}
+
+// connectPresence counts a new connection of a signed-in user towards the
+// roster of the whole chat
+func (h *Hub) connectPresence(client *Client) {
+	if client.userID == nil {
+		return
+	}
+	h.presence.Enter(context.Background(), *client.userID, siteRoom)
+}
+
+// enterPresence counts the client towards the roster of a room it joined and
+// announces the user if no other connection had them there yet
+func (h *Hub) enterPresence(client *Client, room int64) {
+	if client.userID == nil {
+		return
+	}
+
+	h.mu.Lock()
+	if client.present == nil || client.present[room] {
+		h.mu.Unlock()
+		return
+	}
+	client.present[room] = true
+	h.mu.Unlock()
+
+	if h.presence.Enter(context.Background(), *client.userID, room) {
+		h.announcePresence(client, room, PresenceOnline)
+	}
+}
+
+// leavePresence removes the client from the roster of a room and announces
+// the user as gone once their last connection left it
+func (h *Hub) leavePresence(client *Client, room int64) {
+	if client.userID == nil {
+		return
+	}
+
+	h.mu.Lock()
+	if !client.present[room] {
+		h.mu.Unlock()
+		return
+	}
+	delete(client.present, room)
+	h.mu.Unlock()
+
+	if h.presence.Leave(context.Background(), *client.userID, room) {
+		h.announcePresence(client, room, PresenceOffline)
+	}
+}
+
+// disconnectPresence runs after the client was unregistered and removes it
+// from every roster it counted towards
+func (h *Hub) disconnectPresence(client *Client) {
+	if client.userID == nil {
+		return
+	}
+
+	h.mu.Lock()
+	rooms := make([]int64, 0, len(client.present))
+	for room := range client.present {
+		rooms = append(rooms, room)
+	}
+	h.mu.Unlock()
+
+	for _, room := range rooms {
+		client.stopTyping(room, true)
+		h.leavePresence(client, room)
+	}
+	h.presence.Leave(context.Background(), *client.userID, siteRoom)
+}
@@ -0,0 +1,264 @@
+package chat
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/notify"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+)
+
+// ProtocolVersion is the version of the envelope sent in every frame.
+// Clients may omit it; frames with any other version are rejected.
+const ProtocolVersion = 1
+
+// Frame types
+const (
+	TypeJoin     = "join"
+	TypeLeave    = "leave"
+	TypeResume   = "resume"
+	TypeMessage  = "message"
+	TypeDM       = "dm"
+	TypeRead     = "read"
+	TypeEdit     = "edit"
+	TypeDelete   = "delete"
+	TypeTyping   = "typing"
+	TypePresence = "presence"
+	TypeError    = "error"
+	TypeSlowMode = "slow_mode"
+	TypeSanction = "sanction"
+	TypeReaction = "reaction"
+	TypePin      = "pin"
+	TypeUnpin    = "unpin"
+	TypeAck      = "ack"
+	// TypeHistoryTruncated precedes a replay that does not reach back to
+	// the requested message
+	TypeHistoryTruncated = "history_truncated"
+	// TypeNotification pushes a new notification of the notification center
+	TypeNotification = "notification"
+)
+
+// Error codes sent to clients in error frames
+const (
+	ErrCodeBadRequest         = "bad_request"
+	ErrCodeUnsupportedVersion = "unsupported_version"
+	ErrCodeAuthRequired       = "auth_required"
+	ErrCodeForbidden          = "forbidden"
+	ErrCodeNotFound           = "not_found"
+	ErrCodeInternal           = "internal_error"
+	// Rejected frames carry retry_after in seconds
+	ErrCodeRateLimited = "rate_limited"
+	ErrCodeSlowMode    = "slow_mode"
+	ErrCodeDuplicate   = "duplicate_message"
+	ErrCodeMuted       = "muted"
+	ErrCodeBanned      = "banned"
+)
+
+// Presence statuses
+const (
+	PresenceOnline  = "online"
+	PresenceOffline = "offline"
+)
+
+// Sanction statuses
+const (
+	SanctionMuted    = "muted"
+	SanctionUnmuted  = "unmuted"
+	SanctionKicked   = "kicked"
+	SanctionBanned   = "banned"
+	SanctionUnbanned = "unbanned"
+)
+
+// Reaction statuses
+const (
+	ReactionAdded   = "added"
+	ReactionRemoved = "removed"
+)
+
+// Typing statuses
+const (
+	TypingStarted = "started"
+	TypingStopped = "stopped"
+)
+
+const (
+	maxContentLength     = 2000
+	maxClientMsgIDLength = 64
+	// maxRoomsPerClient limits the rooms one connection can subscribe to
+	maxRoomsPerClient = 50
+	// maxQuoteLength shortens the quoted message sent with replies
+	maxQuoteLength = 200
+	maxEmojiLength = 32
+	// maxReactionsPerUser limits the different emojis of a user on a message
+	maxReactionsPerUser = 10
+	maxPinnedMessages   = 10
+)
+
+// UserRef identifies the author of an event
+type UserRef struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+// Quote is the replied to message sent with a reply
+type Quote struct {
+	ID      int64    `json:"id"`
+	User    *UserRef `json:"user"`
+	Content string   `json:"content,omitempty"`
+	Deleted bool     `json:"deleted,omitempty"`
+}
+
+// Reaction is the number of users that reacted with an emoji
+type Reaction struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+}
+
+// Envelope is the JSON frame exchanged in both directions. Which fields are
+// set depends on the type:
+//
+//	join      client: room, since, client_msg_id
+//	resume    client: since, client_msg_id
+//	leave     client: room, client_msg_id; server: room when removed from a room
+//	message   client: room, content, reply_to_id, client_msg_id; server: id, room, user, content, reply_to_id, quote, reactions, created_at, edited_at, pinned_at, client_msg_id
+//	dm        client: conversation, content, client_msg_id; server: id, conversation, user, content, created_at, client_msg_id
+//	read      client: conversation, id
+//	edit      client: id, content, client_msg_id; server: id, room, user, content, edited_at, client_msg_id
+//	delete    client: id, client_msg_id; server: id, room, user who deleted it, client_msg_id
+//	reaction  client: id, emoji, status, client_msg_id; server: id, room, user, emoji, status, reactions of the message
+//	pin       client: id, client_msg_id; server: id, room, user who pinned it, content, pinned_at
+//	unpin     client: id, client_msg_id; server: id, room, user who unpinned it
+//	typing    client: room, status; server: room, user, status
+//	presence  server: room, user, status
+//	notification server: notification
+//	ack       server: id, room or conversation, client_msg_id, created_at, message with the result of a command
+//	error     server: code, message, client_msg_id, retry_after
+//	slow_mode server: room, slow_mode in seconds, unset when turned off
+//	sanction  server: status, message with the reason, expires_at
+//	history_truncated  server: room, since
+//
+// A connection receives events only for the rooms it has joined, and direct
+// messages for the conversations its user takes part in. Joining a room
+// replays the messages after since, or the recent history if since is not
+// set, before any live events of the room.
+type Envelope struct {
+	V            int        `json:"v"`
+	Type         string     `json:"type"`
+	ID           int64      `json:"id,omitempty"`
+	Room         int64      `json:"room,omitempty"`
+	Conversation int64      `json:"conversation,omitempty"`
+	Since        int64      `json:"since,omitempty"`
+	User         *UserRef   `json:"user,omitempty"`
+	Content      string     `json:"content,omitempty"`
+	CreatedAt    *time.Time `json:"created_at,omitempty"`
+	EditedAt     *time.Time `json:"edited_at,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	PinnedAt     *time.Time `json:"pinned_at,omitempty"`
+	ReplyToID    int64      `json:"reply_to_id,omitempty"`
+	Quote        *Quote     `json:"quote,omitempty"`
+	Emoji        string     `json:"emoji,omitempty"`
+	Reactions    []Reaction `json:"reactions,omitempty"`
+	ClientMsgID  string     `json:"client_msg_id,omitempty"`
+	Status       string     `json:"status,omitempty"`
+	Code         string     `json:"code,omitempty"`
+	Message      string     `json:"message,omitempty"`
+	RetryAfter   int        `json:"retry_after,omitempty"`
+	SlowMode     int        `json:"slow_mode,omitempty"`
+
+	Notification *notify.NotificationResponse `json:"notification,omitempty"`
+}
+
+func (e Envelope) encode() ([]byte, error) {
+	e.V = ProtocolVersion
+	return json.Marshal(e)
+}
+
+func messageEnvelope(msg *storage.ChatMessage, clientMsgID string) Envelope {
+	createdAt := msg.CreatedAt.UTC()
+	env := Envelope{
+		Type:        TypeMessage,
+		ID:          msg.ID,
+		Room:        msg.RoomID,
+		User:        &UserRef{ID: msg.UserID, Username: msg.Username},
+		Content:     msg.Content,
+		CreatedAt:   &createdAt,
+		EditedAt:    utcTime(msg.EditedAt),
+		PinnedAt:    utcTime(msg.PinnedAt),
+		Quote:       toQuote(msg),
+		ClientMsgID: clientMsgID,
+	}
+	if msg.ReplyToID != nil {
+		env.ReplyToID = *msg.ReplyToID
+	}
+	return env
+}
+
+// toQuote returns the quote of a reply, shortened to maxQuoteLength
+func toQuote(msg *storage.ChatMessage) *Quote {
+	if msg.Quote == nil || msg.ReplyToID == nil {
+		return nil
+	}
+	content := msg.Quote.Content
+	if runes := []rune(content); len(runes) > maxQuoteLength {
+		content = string(runes[:maxQuoteLength]) + "…"
+	}
+	return &Quote{
+		ID:      *msg.ReplyToID,
+		User:    &UserRef{ID: msg.Quote.UserID, Username: msg.Quote.Username},
+		Content: content,
+		Deleted: msg.Quote.Deleted,
+	}
+}
+
+func toReactions(counts []storage.ReactionCount) []Reaction {
+	if len(counts) == 0 {
+		return nil
+	}
+	reactions := make([]Reaction, len(counts))
+	for i, rc := range counts {
+		reactions[i] = Reaction{Emoji: rc.Emoji, Count: rc.Count}
+	}
+	return reactions
+}
+
+func editEnvelope(msg *storage.ChatMessage, clientMsgID string) Envelope {
+	return Envelope{
+		Type:        TypeEdit,
+		ID:          msg.ID,
+		Room:        msg.RoomID,
+		User:        &UserRef{ID: msg.UserID, Username: msg.Username},
+		Content:     msg.Content,
+		EditedAt:    utcTime(msg.EditedAt),
+		ClientMsgID: clientMsgID,
+	}
+}
+
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
+
+func errorEnvelope(code, message, clientMsgID string) Envelope {
+	return Envelope{
+		Type:        TypeError,
+		Code:        code,
+		Message:     message,
+		ClientMsgID: clientMsgID,
+	}
+}
+
+func directMessageEnvelope(msg *storage.DirectMessage, clientMsgID string) Envelope {
+	createdAt := msg.CreatedAt.UTC()
+	return Envelope{
+		Type:         TypeDM,
+		ID:           msg.ID,
+		Conversation: msg.ConversationID,
+		User:         &UserRef{ID: msg.UserID, Username: msg.Username},
+		Content:      msg.Content,
+		CreatedAt:    &createdAt,
+		ClientMsgID:  clientMsgID,
+	}
+}
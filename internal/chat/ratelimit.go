@@ -0,0 +1,234 @@
+package chat
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+)
+
+const (
+	// limiterPruneInterval is how often idle buckets and filter entries are dropped
+	limiterPruneInterval = time.Minute
+	// maxSlowMode caps the slow mode interval moderators may set
+	maxSlowMode = time.Hour
+)
+
+// rateLimiter is a set of token buckets, one per key. A bucket holds up to
+// burst tokens and regains one every refill. The buckets live in memory, so
+// every instance limits the connections it serves.
+type rateLimiter struct {
+	burst  float64
+	refill time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(burst int, refill time.Duration) *rateLimiter {
+	return &rateLimiter{
+		burst:   float64(burst),
+		refill:  refill,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes a token from the bucket of the key. If there is none, it
+// returns false and how long until the next one.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += float64(now.Sub(b.last)) / float64(l.refill)
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(l.refill))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drops the buckets that have refilled completely, they are
+// indistinguishable from new ones
+func (l *rateLimiter) prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	full := time.Duration(l.burst * float64(l.refill))
+	for key, b := range l.buckets {
+		if time.Since(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// duplicateFilter remembers the last message of each user per destination
+// and rejects the same content again within the window
+type duplicateFilter struct {
+	window time.Duration
+
+	mu     sync.Mutex
+	recent map[duplicateKey]recentMessage
+}
+
+type duplicateKey struct {
+	userID int64
+	// target is the room, or the negated conversation
+	target int64
+}
+
+type recentMessage struct {
+	content string
+	at      time.Time
+}
+
+func newDuplicateFilter(window time.Duration) *duplicateFilter {
+	return &duplicateFilter{
+		window: window,
+		recent: make(map[duplicateKey]recentMessage),
+	}
+}
+
+// allow records the content and reports whether it differs from the last
+// message of the user to the same target within the window
+func (f *duplicateFilter) allow(userID, target int64, content string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := duplicateKey{userID: userID, target: target}
+	now := time.Now()
+	if last, ok := f.recent[key]; ok && last.content == content && now.Sub(last.at) < f.window {
+		return false
+	}
+	f.recent[key] = recentMessage{content: content, at: now}
+	return true
+}
+
+func (f *duplicateFilter) prune() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for key, last := range f.recent {
+		if time.Since(last.at) >= f.window {
+			delete(f.recent, key)
+		}
+	}
+}
+
+// pruneLimiters keeps the limiters from growing with every user and address
+// ever seen
+func (h *Hub) pruneLimiters(ctx context.Context) {
+	ticker := time.NewTicker(limiterPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.userLimiter.prune()
+			h.ipLimiter.prune()
+			h.duplicates.prune()
+		}
+	}
+}
+
+// allowFrame applies the per-user and per-address limits to a frame and
+// answers with an error frame if it is rejected
+func (c *Client) allowFrame(env Envelope) bool {
+	if c.ip != "" {
+		if ok, retryAfter := c.hub.ipLimiter.allow(c.ip); !ok {
+			c.sendRateLimited(env, retryAfter)
+			return false
+		}
+	}
+	if c.userID != nil {
+		if ok, retryAfter := c.hub.userLimiter.allow(strconv.FormatInt(*c.userID, 10)); !ok {
+			c.sendRateLimited(env, retryAfter)
+			return false
+		}
+	}
+	return true
+}
+
+// allowContent rejects a message the user just sent to the same target
+func (c *Client) allowContent(env Envelope, target int64, content string) bool {
+	if !c.hub.duplicates.allow(*c.userID, target, content) {
+		metrics.Add(metricRejectedDuplicate, 1)
+		c.sendError(ErrCodeDuplicate, "duplicate message", env.ClientMsgID)
+		return false
+	}
+	return true
+}
+
+// allowSlowMode checks that the user waited out the slow mode of the room.
+// Moderators and room owners are exempt.
+func (c *Client) allowSlowMode(ctx context.Context, env Envelope) bool {
+	if auth.IsModerator(c.role) {
+		return true
+	}
+
+	room, err := c.hub.roomRepo.GetRoom(ctx, env.Room)
+	if err != nil || room == nil || room.SlowMode == 0 {
+		// Failing open keeps the chat usable if the lookup fails
+		return true
+	}
+	if role, err := c.hub.roomRepo.GetMemberRole(ctx, room.ID, *c.userID); err == nil && role == storage.RoomRoleOwner {
+		return true
+	}
+
+	last, err := c.hub.chatRepo.GetLastMessageTime(ctx, room.ID, *c.userID)
+	if err != nil || last == nil {
+		return true
+	}
+	if wait := room.SlowMode - time.Since(*last); wait > 0 {
+		metrics.Add(metricRejectedSlowMode, 1)
+		frame := errorEnvelope(ErrCodeSlowMode, "slow mode is on in this room", env.ClientMsgID)
+		frame.RetryAfter = retryAfterSeconds(wait)
+		c.sendFrame(frame)
+		return false
+	}
+	return true
+}
+
+func (c *Client) sendRateLimited(env Envelope, retryAfter time.Duration) {
+	metrics.Add(metricRejectedRateLimit, 1)
+	frame := errorEnvelope(ErrCodeRateLimited, "too many frames, slow down", env.ClientMsgID)
+	frame.RetryAfter = retryAfterSeconds(retryAfter)
+	c.sendFrame(frame)
+}
+
+// retryAfterSeconds rounds up, so that clients never retry too early
+func retryAfterSeconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// remoteIP returns the address of the websocket client
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,203 @@
+package chat
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/pkg/config"
+)
+
+// newFrameClient registers a connection without a socket on a hub that is
+// not running, so that the frames sent to it can be read from its buffer
+func newFrameClient(t *testing.T, hub *Hub, userID *int64, ip string) *Client {
+	t.Helper()
+
+	client := &Client{hub: hub, send: make(chan []byte, 16), userID: userID, ip: ip}
+	hub.mu.Lock()
+	hub.clients[client] = true
+	hub.mu.Unlock()
+
+	return client
+}
+
+// nextFrame returns the frame the client was sent last, failing if there is none
+func nextFrame(t *testing.T, client *Client) Envelope {
+	t.Helper()
+
+	select {
+	case data := <-client.send:
+		var env Envelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			t.Fatalf("decode frame: %v", err)
+		}
+		return env
+	default:
+		t.Fatal("no frame was sent")
+		return Envelope{}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(3, time.Hour)
+
+	for i := 1; i <= 3; i++ {
+		if ok, _ := limiter.allow("a"); !ok {
+			t.Fatalf("frame %d within the burst was rejected", i)
+		}
+	}
+	ok, retryAfter := limiter.allow("a")
+	if ok {
+		t.Fatal("frame past the burst was allowed")
+	}
+	if retryAfter <= 59*time.Minute || retryAfter > time.Hour {
+		t.Fatalf("retry after %s, want about an hour", retryAfter)
+	}
+
+	// Keys have buckets of their own
+	if ok, _ := limiter.allow("b"); !ok {
+		t.Fatal("frame of another key was rejected")
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	limiter := newRateLimiter(1, 20*time.Millisecond)
+
+	if ok, _ := limiter.allow("a"); !ok {
+		t.Fatal("first frame was rejected")
+	}
+	if ok, _ := limiter.allow("a"); ok {
+		t.Fatal("second frame was allowed before the refill")
+	}
+
+	time.Sleep(40 * time.Millisecond)
+	if ok, _ := limiter.allow("a"); !ok {
+		t.Fatal("frame after the refill was rejected")
+	}
+	// The bucket never holds more than the burst
+	if ok, _ := limiter.allow("a"); ok {
+		t.Fatal("bucket refilled past its burst")
+	}
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	limiter := newRateLimiter(2, time.Hour)
+	limiter.allow("drained")
+	limiter.allow("full")
+	limiter.buckets["full"].last = time.Now().Add(-3 * time.Hour)
+
+	limiter.prune()
+
+	if _, ok := limiter.buckets["full"]; ok {
+		t.Fatal("refilled bucket was kept")
+	}
+	if _, ok := limiter.buckets["drained"]; !ok {
+		t.Fatal("bucket that is still refilling was dropped")
+	}
+}
+
+func TestDuplicateFilter(t *testing.T) {
+	filter := newDuplicateFilter(time.Hour)
+	if !filter.allow(1, 10, "hello") {
+		t.Fatal("first message was rejected")
+	}
+
+	tests := []struct {
+		name    string
+		userID  int64
+		target  int64
+		content string
+		want    bool
+	}{
+		{name: "same message again", userID: 1, target: 10, content: "hello", want: false},
+		{name: "other room", userID: 1, target: 11, content: "hello", want: true},
+		{name: "conversation with the room's ID", userID: 1, target: -10, content: "hello", want: true},
+		{name: "other user", userID: 2, target: 10, content: "hello", want: true},
+		{name: "other content", userID: 1, target: 10, content: "hello!", want: true},
+		// Only the last message counts
+		{name: "first message after another", userID: 1, target: 10, content: "hello", want: true},
+	}
+	for _, tt := range tests {
+		if got := filter.allow(tt.userID, tt.target, tt.content); got != tt.want {
+			t.Errorf("%s: allow = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDuplicateFilterWindow(t *testing.T) {
+	filter := newDuplicateFilter(20 * time.Millisecond)
+	filter.allow(1, 10, "hello")
+
+	time.Sleep(40 * time.Millisecond)
+	if !filter.allow(1, 10, "hello") {
+		t.Fatal("message after the window was rejected")
+	}
+
+	filter.recent[duplicateKey{userID: 2, target: 10}] = recentMessage{content: "old", at: time.Now().Add(-time.Hour)}
+	filter.prune()
+	if _, ok := filter.recent[duplicateKey{userID: 2, target: 10}]; ok {
+		t.Fatal("expired entry was kept")
+	}
+	if _, ok := filter.recent[duplicateKey{userID: 1, target: 10}]; !ok {
+		t.Fatal("recent entry was dropped")
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want int
+	}{
+		{d: 0, want: 0},
+		{d: time.Nanosecond, want: 1},
+		{d: time.Second, want: 1},
+		{d: 1500 * time.Millisecond, want: 2},
+		{d: time.Minute, want: 60},
+	}
+	for _, tt := range tests {
+		if got := retryAfterSeconds(tt.d); got != tt.want {
+			t.Errorf("retryAfterSeconds(%s) = %d, want %d", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestAllowFrameLimitsUsersAndAddresses(t *testing.T) {
+	cfg := config.NewConfig()
+	cfg.ChatUserBurst, cfg.ChatUserRefill = 2, time.Hour
+	cfg.ChatIPBurst, cfg.ChatIPRefill = 3, time.Hour
+	hub := NewHub(nil, nil, nil, nil, nil, nil, nil, nil, nil, NewMemoryBroker(), nil, cfg)
+
+	alice, bob := int64(1), int64(2)
+	first := newFrameClient(t, hub, &alice, "192.0.2.1")
+	for i := 1; i <= 2; i++ {
+		if !first.allowFrame(Envelope{Type: TypeMessage}) {
+			t.Fatalf("frame %d of the user was rejected", i)
+		}
+	}
+
+	if first.allowFrame(Envelope{Type: TypeMessage, ClientMsgID: "m3"}) {
+		t.Fatal("frame past the user burst was allowed")
+	}
+	env := nextFrame(t, first)
+	if env.Type != TypeError || env.Code != ErrCodeRateLimited || env.ClientMsgID != "m3" || env.RetryAfter != 3600 {
+		t.Fatalf("frame = %+v, want rate_limited for m3 with retry_after 3600", env)
+	}
+
+	// The rejected frame still used up the last token of the address
+	second := newFrameClient(t, hub, &bob, "192.0.2.1")
+	if second.allowFrame(Envelope{Type: TypeMessage}) {
+		t.Fatal("frame past the address burst was allowed")
+	}
+	if env := nextFrame(t, second); env.Code != ErrCodeRateLimited {
+		t.Fatalf("frame = %+v, want rate_limited", env)
+	}
+
+	// The same user from another address is still limited, anonymous
+	// connections from another address are not
+	if newFrameClient(t, hub, &alice, "192.0.2.2").allowFrame(Envelope{Type: TypeMessage}) {
+		t.Fatal("frame of the limited user from another address was allowed")
+	}
+	if !newFrameClient(t, hub, nil, "192.0.2.3").allowFrame(Envelope{Type: TypeJoin}) {
+		t.Fatal("anonymous frame from another address was rejected")
+	}
+}
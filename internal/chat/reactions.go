@@ -0,0 +1,173 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+// handleReaction adds or removes an emoji reaction of the user. The room gets
+// the new reaction counts of the message.
+func (c *Client) handleReaction(ctx context.Context, env Envelope) {
+	if env.ID <= 0 {
+		c.sendError(ErrCodeBadRequest, "id is required", env.ClientMsgID)
+		return
+	}
+	if env.Status != ReactionAdded && env.Status != ReactionRemoved {
+		c.sendError(ErrCodeBadRequest, "status must be added or removed", env.ClientMsgID)
+		return
+	}
+	if !validEmoji(env.Emoji) {
+		c.sendError(ErrCodeBadRequest, "invalid emoji", env.ClientMsgID)
+		return
+	}
+
+	msg, err := c.hub.chatRepo.GetMessage(ctx, env.ID)
+	if err != nil {
+		logger.Error().Err(err).Int64("message_id", env.ID).Msg("Failed to get message")
+		c.sendError(ErrCodeInternal, "failed to react to message", env.ClientMsgID)
+		return
+	}
+	if msg == nil || !c.hub.isSubscribed(c, msg.RoomID) {
+		c.sendError(ErrCodeNotFound, "message not found", env.ClientMsgID)
+		return
+	}
+
+	var changed bool
+	if env.Status == ReactionAdded {
+		count, err := c.hub.chatRepo.CountUserReactions(ctx, msg.ID, *c.userID)
+		if err != nil {
+			logger.Error().Err(err).Int64("message_id", msg.ID).Msg("Failed to count reactions")
+			c.sendError(ErrCodeInternal, "failed to react to message", env.ClientMsgID)
+			return
+		}
+		if count >= maxReactionsPerUser {
+			c.sendError(ErrCodeBadRequest, "too many reactions on this message", env.ClientMsgID)
+			return
+		}
+		changed, err = c.hub.chatRepo.AddReaction(ctx, msg.ID, *c.userID, env.Emoji)
+	} else {
+		changed, err = c.hub.chatRepo.RemoveReaction(ctx, msg.ID, *c.userID, env.Emoji)
+	}
+	if err != nil {
+		logger.Error().Err(err).Int64("message_id", msg.ID).Msg("Failed to update reaction")
+		c.sendError(ErrCodeInternal, "failed to react to message", env.ClientMsgID)
+		return
+	}
+
+	c.sendAck(msg, env.ClientMsgID, nil)
+	if !changed {
+		return
+	}
+
+	reactions, err := c.hub.chatRepo.GetReactions(ctx, []int64{msg.ID})
+	if err != nil {
+		logger.Error().Err(err).Int64("message_id", msg.ID).Msg("Failed to get reactions")
+		return
+	}
+	c.hub.Broadcast(Envelope{
+		Type:      TypeReaction,
+		ID:        msg.ID,
+		Room:      msg.RoomID,
+		User:      c.user(),
+		Emoji:     env.Emoji,
+		Status:    env.Status,
+		Reactions: toReactions(reactions[msg.ID]),
+	}, nil)
+}
+
+// handlePin pins a message to the room header or unpins it. Only moderators
+// and room owners can pin, and not with an API token.
+func (c *Client) handlePin(ctx context.Context, env Envelope) {
+	if c.apiToken {
+		c.sendError(ErrCodeForbidden, "API tokens cannot pin messages", env.ClientMsgID)
+		return
+	}
+	if env.ID <= 0 {
+		c.sendError(ErrCodeBadRequest, "id is required", env.ClientMsgID)
+		return
+	}
+
+	existing, err := c.hub.chatRepo.GetMessage(ctx, env.ID)
+	if err != nil {
+		logger.Error().Err(err).Int64("message_id", env.ID).Msg("Failed to get message")
+		c.sendError(ErrCodeInternal, "failed to pin message", env.ClientMsgID)
+		return
+	}
+	if existing == nil || !c.hub.isSubscribed(c, existing.RoomID) {
+		c.sendError(ErrCodeNotFound, "message not found", env.ClientMsgID)
+		return
+	}
+
+	allowed, err := c.hub.canModerateRoom(ctx, existing.RoomID, *c.userID, c.role)
+	if err != nil {
+		logger.Error().Err(err).Int64("room_id", existing.RoomID).Msg("Failed to get member role")
+		c.sendError(ErrCodeInternal, "failed to pin message", env.ClientMsgID)
+		return
+	}
+	if !allowed {
+		c.sendError(ErrCodeForbidden, "only moderators and room owners can pin messages", env.ClientMsgID)
+		return
+	}
+
+	if env.Type == TypeUnpin {
+		msg, err := c.hub.chatRepo.UnpinMessage(ctx, existing.ID)
+		if err != nil {
+			logger.Error().Err(err).Int64("message_id", existing.ID).Msg("Failed to unpin message")
+			c.sendError(ErrCodeInternal, "failed to unpin message", env.ClientMsgID)
+			return
+		}
+		if msg == nil {
+			c.sendError(ErrCodeNotFound, "message is not pinned", env.ClientMsgID)
+			return
+		}
+
+		c.sendAck(msg, env.ClientMsgID, nil)
+		c.hub.Broadcast(Envelope{Type: TypeUnpin, ID: msg.ID, Room: msg.RoomID, User: c.user()}, nil)
+		return
+	}
+
+	pinned, err := c.hub.chatRepo.GetPinnedMessages(ctx, existing.RoomID)
+	if err != nil {
+		logger.Error().Err(err).Int64("room_id", existing.RoomID).Msg("Failed to get pinned messages")
+		c.sendError(ErrCodeInternal, "failed to pin message", env.ClientMsgID)
+		return
+	}
+	if len(pinned) >= maxPinnedMessages {
+		c.sendError(ErrCodeBadRequest, "too many pinned messages in this room", env.ClientMsgID)
+		return
+	}
+
+	msg, err := c.hub.chatRepo.PinMessage(ctx, existing.ID, *c.userID)
+	if err != nil {
+		logger.Error().Err(err).Int64("message_id", existing.ID).Msg("Failed to pin message")
+		c.sendError(ErrCodeInternal, "failed to pin message", env.ClientMsgID)
+		return
+	}
+	if msg == nil {
+		c.sendError(ErrCodeBadRequest, "message is pinned already", env.ClientMsgID)
+		return
+	}
+
+	c.sendAck(msg, env.ClientMsgID, nil)
+	c.hub.Broadcast(Envelope{
+		Type:     TypePin,
+		ID:       msg.ID,
+		Room:     msg.RoomID,
+		User:     c.user(),
+		Content:  msg.Content,
+		PinnedAt: utcTime(msg.PinnedAt),
+	}, nil)
+}
+
+// validEmoji accepts a short string without spaces or control characters
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > maxEmojiLength {
+		return false
+	}
+	return strings.IndexFunc(emoji, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r)
+	}) < 0
+}
@@ -0,0 +1,119 @@
+package chat
+
+import (
+	"context"
+
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+// replay sends the messages of a just joined room after since, or the recent
+// history if since is zero, and then releases the live events held back
+// meanwhile. At most ChatReplayLimit messages are replayed; if more were
+// missed, the newest ones are sent after a history_truncated marker.
+func (c *Client) replay(ctx context.Context, room, since int64) {
+	limit := c.hub.cfg.ChatReplayLimit
+
+	messages, err := c.hub.chatRepo.GetMessagesAfter(ctx, room, since, limit+1)
+	if err != nil {
+		logger.Error().Err(err).Int64("room_id", room).Msg("Failed to load chat history")
+		c.sendError(ErrCodeInternal, "failed to load history", "")
+		c.hub.finishReplay(c, room, nil, 0)
+		return
+	}
+
+	var frames [][]byte
+	if len(messages) > limit {
+		messages = messages[1:]
+		if since > 0 {
+			marker, err := Envelope{Type: TypeHistoryTruncated, Room: room, Since: since}.encode()
+			if err == nil {
+				frames = append(frames, marker)
+			}
+		}
+	}
+
+	ids := make([]int64, len(messages))
+	for i, msg := range messages {
+		ids[i] = msg.ID
+	}
+	reactions, err := c.hub.chatRepo.GetReactions(ctx, ids)
+	if err != nil {
+		logger.Error().Err(err).Int64("room_id", room).Msg("Failed to load reactions")
+	}
+
+	var lastID int64
+	for _, msg := range messages {
+		env := messageEnvelope(msg, "")
+		env.Reactions = toReactions(reactions[msg.ID])
+		data, err := env.encode()
+		if err != nil {
+			logger.Error().Err(err).Int64("message_id", msg.ID).Msg("Failed to encode frame")
+			continue
+		}
+		frames = append(frames, data)
+		lastID = msg.ID
+	}
+
+	c.hub.finishReplay(c, room, frames, lastID)
+}
+
+// finishReplay queues the replayed frames followed by the live events held
+// back during the replay. Live copies of replayed messages are dropped, so
+// the client sees every message exactly once and in order.
+func (h *Hub) finishReplay(client *Client, room int64, frames [][]byte, lastID int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.clients[client] || !client.rooms[room] {
+		return
+	}
+
+	pending := client.replaying[room]
+	delete(client.replaying, room)
+
+	for _, data := range frames {
+		select {
+		case client.send <- data:
+		default:
+			h.evictSlowConsumer(client)
+			return
+		}
+	}
+
+	for _, message := range pending {
+		if message.msgID != 0 && message.msgID <= lastID {
+			continue
+		}
+		select {
+		case client.send <- message.data:
+		default:
+			h.evictSlowConsumer(client)
+			return
+		}
+	}
+}
+
+// handleResume replays what the client missed in the rooms it has already
+// joined and remembers since for rooms it joins later
+func (c *Client) handleResume(ctx context.Context, env Envelope) {
+	if env.Since <= 0 {
+		c.sendError(ErrCodeBadRequest, "since is required", env.ClientMsgID)
+		return
+	}
+	c.since = env.Since
+
+	c.hub.mu.Lock()
+	var rooms []int64
+	for room := range c.rooms {
+		if _, replaying := c.replaying[room]; !replaying {
+			c.replaying[room] = nil
+			rooms = append(rooms, room)
+		}
+	}
+	c.hub.mu.Unlock()
+
+	c.sendFrame(Envelope{Type: TypeAck, Since: env.Since, ClientMsgID: env.ClientMsgID})
+	for _, room := range rooms {
+		c.replay(ctx, room, env.Since)
+	}
+}
@@ -0,0 +1,143 @@
+package chat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+// ArchiveStore keeps the archives of purged messages. The upload blob stores
+// implement it.
+type ArchiveStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+}
+
+// archivedMessage is one line of an archive
+type archivedMessage struct {
+	ID        int64      `json:"id"`
+	RoomID    int64      `json:"room_id"`
+	UserID    int64      `json:"user_id"`
+	Username  string     `json:"username"`
+	Content   string     `json:"content"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy *int64     `json:"deleted_by,omitempty"`
+}
+
+// enforceRetention purges expired room messages every ChatRetentionInterval
+func (h *Hub) enforceRetention(ctx context.Context) {
+	ticker := time.NewTicker(h.cfg.ChatRetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.purgeExpiredMessages(ctx)
+		}
+	}
+}
+
+// purgeExpiredMessages applies the retention policy of every room
+func (h *Hub) purgeExpiredMessages(ctx context.Context) {
+	rooms, err := h.roomRepo.GetRooms(ctx)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list rooms for retention")
+		return
+	}
+
+	for i := range rooms {
+		room := &rooms[i]
+		purged, err := h.purgeRoom(ctx, room)
+		if purged > 0 {
+			logger.Info().Int64("room_id", room.ID).Str("policy", room.Retention.Policy).Int("purged", purged).Msg("Purged expired chat messages")
+		}
+		if err != nil {
+			logger.Error().Err(err).Int64("room_id", room.ID).Msg("Failed to purge expired chat messages")
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// purgeRoom deletes the expired messages of the room in batches of
+// ChatRetentionBatchSize, so that no statement holds many rows for long
+func (h *Hub) purgeRoom(ctx context.Context, room *storage.Room) (int, error) {
+	var archive func([]*storage.ChatMessage) error
+	if h.archive != nil {
+		archive = func(messages []*storage.ChatMessage) error {
+			return h.archiveMessages(ctx, room.ID, messages)
+		}
+	}
+
+	batch := h.cfg.ChatRetentionBatchSize
+	total := 0
+	for ctx.Err() == nil {
+		var n int
+		var err error
+		switch room.Retention.Policy {
+		case storage.RetentionTTL:
+			ttl := room.Retention.TTL
+			if ttl == 0 {
+				ttl = h.cfg.ChatMessageTTL
+			}
+			n, err = h.chatRepo.PurgeMessagesOlderThan(ctx, room.ID, time.Now().Add(-ttl), batch, archive)
+		case storage.RetentionCount:
+			n, err = h.chatRepo.PurgeMessagesBeyond(ctx, room.ID, room.Retention.MaxMessages, batch, archive)
+		default:
+			return total, nil
+		}
+
+		total += n
+		metrics.Add(metricRetentionPurged, int64(n))
+		if archive != nil {
+			metrics.Add(metricRetentionArchived, int64(n))
+		}
+		if err != nil || n < batch {
+			return total, err
+		}
+	}
+
+	return total, ctx.Err()
+}
+
+// archiveMessages writes the messages as gzip compressed JSON lines, one
+// archive per batch
+func (h *Hub) archiveMessages(ctx context.Context, roomID int64, messages []*storage.ChatMessage) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(zw)
+	for _, m := range messages {
+		err := enc.Encode(archivedMessage{
+			ID:        m.ID,
+			RoomID:    m.RoomID,
+			UserID:    m.UserID,
+			Username:  m.Username,
+			Content:   m.Content,
+			CreatedAt: m.CreatedAt.UTC(),
+			EditedAt:  utcTime(m.EditedAt),
+			DeletedAt: utcTime(m.DeletedAt),
+			DeletedBy: m.DeletedBy,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("chat-archive/room-%d/%s-%d-%d.jsonl.gz",
+		roomID, time.Now().UTC().Format("20060102T150405Z"), messages[0].ID, messages[len(messages)-1].ID)
+	return h.archive.Put(ctx, key, &buf, int64(buf.Len()), "application/gzip")
+}
@@ -0,0 +1,49 @@
+package chat
+
+import (
+	"context"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+)
+
+// readableRoom returns the room if the user may read it, or nil if the room
+// does not exist or is private and the user is not a member. Private rooms
+// are reported as missing so that their existence is not revealed.
+func (h *Hub) readableRoom(ctx context.Context, roomID int64, userID *int64) (*storage.Room, error) {
+	room, err := h.roomRepo.GetRoom(ctx, roomID)
+	if err != nil || room == nil {
+		return nil, err
+	}
+
+	if room.Kind != storage.RoomKindPrivate {
+		return room, nil
+	}
+	if userID == nil {
+		return nil, nil
+	}
+
+	role, err := h.roomRepo.GetMemberRole(ctx, room.ID, *userID)
+	if err != nil {
+		return nil, err
+	}
+	if role == "" {
+		return nil, nil
+	}
+
+	return room, nil
+}
+
+// canModerateRoom reports whether the user is a site moderator or an owner
+// of the room
+func (h *Hub) canModerateRoom(ctx context.Context, roomID, userID int64, siteRole string) (bool, error) {
+	if auth.IsModerator(siteRole) {
+		return true, nil
+	}
+
+	role, err := h.roomRepo.GetMemberRole(ctx, roomID, userID)
+	if err != nil {
+		return false, err
+	}
+	return role == storage.RoomRoleOwner, nil
+}
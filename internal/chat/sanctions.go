@@ -0,0 +1,223 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// defaultMuteDuration applies to mutes given without a duration. Bans
+	// without a duration are permanent.
+	defaultMuteDuration     = 10 * time.Minute
+	maxSanctionReasonLength = 500
+)
+
+var (
+	errSanctionSelf      = errors.New("you cannot sanction yourself")
+	errSanctionModerator = errors.New("only admins can sanction moderators")
+)
+
+// sanction mutes, kicks or bans the target on behalf of a moderator. The
+// target is told with a sanction frame; kicks and bans also close all of
+// their connections, on every instance.
+func (h *Hub) sanction(ctx context.Context, actorID int64, actorRole string, target *storage.User, kind string, duration time.Duration, reason string) (*storage.ChatSanction, error) {
+	if target.ID == actorID {
+		return nil, errSanctionSelf
+	}
+	if auth.IsModerator(target.Role) && actorRole != auth.RoleAdmin {
+		return nil, errSanctionModerator
+	}
+
+	var expiresAt *time.Time
+	if duration > 0 && kind != storage.SanctionKick {
+		t := time.Now().Add(duration)
+		expiresAt = &t
+	}
+
+	s, err := h.sanctionRepo.CreateSanction(ctx, target.ID, kind, reason, &actorID, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	action := map[string]string{
+		storage.SanctionMute: storage.AuditActionChatUserMuted,
+		storage.SanctionKick: storage.AuditActionChatUserKicked,
+		storage.SanctionBan:  storage.AuditActionChatUserBanned,
+	}[kind]
+	h.recordAudit(ctx, &storage.AuditEntry{
+		ActorID:    &actorID,
+		Action:     action,
+		TargetType: storage.AuditTargetUser,
+		TargetID:   target.ID,
+		Details: map[string]interface{}{
+			"sanction_id": s.ID,
+			"reason":      reason,
+			"expires_at":  s.ExpiresAt,
+		},
+	})
+
+	env := sanctionEnvelope(s)
+	switch kind {
+	case storage.SanctionMute:
+		h.SendToUsers(env, []int64{target.ID})
+	case storage.SanctionKick:
+		h.DisconnectUser(target.ID, env, "kicked")
+	case storage.SanctionBan:
+		h.DisconnectUser(target.ID, env, "banned")
+	}
+
+	return s, nil
+}
+
+// liftSanctions revokes the mutes or bans in force of the target and reports
+// whether there were any
+func (h *Hub) liftSanctions(ctx context.Context, actorID int64, target *storage.User, kind string) (bool, error) {
+	revoked, err := h.sanctionRepo.RevokeActiveSanctions(ctx, target.ID, kind, actorID)
+	if err != nil || revoked == 0 {
+		return false, err
+	}
+
+	h.sanctionLifted(ctx, actorID, target.ID, kind)
+	return true, nil
+}
+
+// sanctionLifted records a revoked mute or ban and tells its target
+func (h *Hub) sanctionLifted(ctx context.Context, actorID, userID int64, kind string) {
+	action, status := storage.AuditActionChatUserUnmuted, SanctionUnmuted
+	if kind == storage.SanctionBan {
+		action, status = storage.AuditActionChatUserUnbanned, SanctionUnbanned
+	}
+
+	h.recordAudit(ctx, &storage.AuditEntry{
+		ActorID:    &actorID,
+		Action:     action,
+		TargetType: storage.AuditTargetUser,
+		TargetID:   userID,
+	})
+	h.SendToUsers(Envelope{Type: TypeSanction, Status: status}, []int64{userID})
+}
+
+// activeSanction returns the ban, or else the mute, in force for the user
+func (h *Hub) activeSanction(ctx context.Context, userID int64) (*storage.ChatSanction, error) {
+	sanctions, err := h.sanctionRepo.GetActiveSanctions(ctx, &userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var active *storage.ChatSanction
+	for i := range sanctions {
+		if active == nil || sanctions[i].Kind == storage.SanctionBan {
+			active = &sanctions[i]
+		}
+	}
+	return active, nil
+}
+
+func (h *Handler) isBanned(ctx context.Context, userID int64) (bool, error) {
+	s, err := h.hub.activeSanction(ctx, userID)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to check chat sanctions")
+		return false, err
+	}
+	return s != nil && s.Kind == storage.SanctionBan, nil
+}
+
+// allowedToSend rejects frames of muted and banned users. Bans also close
+// the connection, this covers frames that arrive before it is closed.
+func (c *Client) allowedToSend(ctx context.Context, env Envelope) bool {
+	s, err := c.hub.activeSanction(ctx, *c.userID)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", *c.userID).Msg("Failed to check chat sanctions")
+		c.sendError(ErrCodeInternal, "failed to send message", env.ClientMsgID)
+		return false
+	}
+	if s == nil {
+		return true
+	}
+
+	frame := errorEnvelope(ErrCodeMuted, "you are muted", env.ClientMsgID)
+	if s.Kind == storage.SanctionBan {
+		frame = errorEnvelope(ErrCodeBanned, "you are banned from the chat", env.ClientMsgID)
+	}
+	if s.ExpiresAt != nil {
+		frame.RetryAfter = retryAfterSeconds(time.Until(*s.ExpiresAt))
+	}
+	c.sendFrame(frame)
+	return false
+}
+
+// DisconnectUser closes all connections of the user on every instance after
+// sending them the frame
+func (h *Hub) DisconnectUser(userID int64, env Envelope, reason string) {
+	data, err := env.encode()
+	if err != nil {
+		logger.Error().Err(err).Str("type", env.Type).Msg("Failed to encode frame")
+		return
+	}
+	h.publish(BrokerMessage{Users: []int64{userID}, Data: data, Disconnect: reason})
+}
+
+// disconnect closes the connections of the users on this instance
+func (h *Hub) disconnect(userIDs []int64, data []byte, reason string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, userID := range userIDs {
+		for client := range h.users[userID] {
+			select {
+			case client.send <- data:
+			default:
+			}
+			client.closeCode = websocket.ClosePolicyViolation
+			client.closeText = reason
+			h.removeClient(client)
+		}
+	}
+}
+
+// recordAudit writes an audit log entry. A failure is logged but does not
+// undo the action.
+func (h *Hub) recordAudit(ctx context.Context, entry *storage.AuditEntry) {
+	if err := h.auditRepo.CreateEntry(ctx, entry); err != nil {
+		logger.Error().Err(err).Str("action", entry.Action).Int64("target_id", entry.TargetID).Msg("Failed to write audit log")
+	}
+}
+
+func sanctionEnvelope(s *storage.ChatSanction) Envelope {
+	status := map[string]string{
+		storage.SanctionMute: SanctionMuted,
+		storage.SanctionKick: SanctionKicked,
+		storage.SanctionBan:  SanctionBanned,
+	}[s.Kind]
+	return Envelope{
+		Type:      TypeSanction,
+		Status:    status,
+		Message:   s.Reason,
+		ExpiresAt: utcTime(s.ExpiresAt),
+	}
+}
+
+// parseSanctionDuration accepts Go durations like 10m or 2h, and days like 7d
+func parseSanctionDuration(s string) (time.Duration, bool) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, false
+		}
+		return time.Duration(n) * 24 * time.Hour, true
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, false
+	}
+	return d, true
+}
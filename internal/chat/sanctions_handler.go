@@ -0,0 +1,212 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+type SanctionResponse struct {
+	ID        int64   `json:"id"`
+	UserID    int64   `json:"user_id"`
+	Username  string  `json:"username"`
+	Kind      string  `json:"kind"`
+	Reason    string  `json:"reason"`
+	CreatedBy *int64  `json:"created_by,omitempty"`
+	CreatedAt string  `json:"created_at"`
+	ExpiresAt *string `json:"expires_at,omitempty"`
+}
+
+type CreateSanctionRequest struct {
+	UserID int64  `json:"user_id"`
+	Kind   string `json:"kind"`
+	// Duration like "10m", "2h" or "7d". Mutes default to 10 minutes, bans
+	// without one are permanent.
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+// SanctionsHandler lets moderators mute, kick and ban chat users under
+// /api/admin/chat/sanctions
+type SanctionsHandler struct {
+	hub  *Hub
+	auth *auth.Service
+}
+
+func NewSanctionsHandler(hub *Hub, auth *auth.Service) *SanctionsHandler {
+	return &SanctionsHandler{
+		hub:  hub,
+		auth: auth,
+	}
+}
+
+func (h *SanctionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/chat/sanctions"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		h.handleListSanctions(w, r)
+	case id == "" && r.Method == http.MethodPost:
+		h.handleCreateSanction(w, r)
+	case id != "" && r.Method == http.MethodDelete:
+		h.handleRevokeSanction(w, r, id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// requireModerator authenticates a site moderator signed in with a session;
+// API tokens cannot moderate, whatever their scopes
+func (h *SanctionsHandler) requireModerator(w http.ResponseWriter, r *http.Request) (int64, string, bool) {
+	claims, err := h.auth.AuthenticateSession(r)
+	if err != nil {
+		if errors.Is(err, auth.ErrSessionRequired) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		} else {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
+		return 0, "", false
+	}
+	if !auth.IsModerator(claims.Role) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return 0, "", false
+	}
+	return claims.UserId, claims.Role, true
+}
+
+func (h *SanctionsHandler) handleListSanctions(w http.ResponseWriter, r *http.Request) {
+	if _, _, ok := h.requireModerator(w, r); !ok {
+		return
+	}
+
+	var userID *int64
+	if userStr := r.URL.Query().Get("user_id"); userStr != "" {
+		id, err := strconv.ParseInt(userStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid user_id parameter", http.StatusBadRequest)
+			return
+		}
+		userID = &id
+	}
+
+	sanctions, err := h.hub.sanctionRepo.GetActiveSanctions(r.Context(), userID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to list chat sanctions")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]SanctionResponse, len(sanctions))
+	for i := range sanctions {
+		response[i] = toSanctionResponse(&sanctions[i])
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *SanctionsHandler) handleCreateSanction(w http.ResponseWriter, r *http.Request) {
+	actorID, actorRole, ok := h.requireModerator(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateSanctionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserID <= 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Kind != storage.SanctionMute && req.Kind != storage.SanctionKick && req.Kind != storage.SanctionBan {
+		http.Error(w, "Kind must be mute, kick or ban", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if utf8.RuneCountInString(req.Reason) > maxSanctionReasonLength {
+		http.Error(w, "Reason is too long", http.StatusBadRequest)
+		return
+	}
+
+	var duration time.Duration
+	if req.Kind == storage.SanctionMute {
+		duration = defaultMuteDuration
+	}
+	if req.Duration != "" {
+		var ok bool
+		if duration, ok = parseSanctionDuration(req.Duration); !ok {
+			http.Error(w, "Invalid duration", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	// The repository reports unknown users as errors
+	target, err := h.hub.userRepo.GetUserByID(ctx, req.UserID)
+	if err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	s, err := h.hub.sanction(ctx, actorID, actorRole, target, req.Kind, duration, req.Reason)
+	if err != nil {
+		if errors.Is(err, errSanctionSelf) || errors.Is(err, errSanctionModerator) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		logger.Error().Err(err).Int64("user_id", req.UserID).Msg("Failed to create chat sanction")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, toSanctionResponse(s))
+}
+
+func (h *SanctionsHandler) handleRevokeSanction(w http.ResponseWriter, r *http.Request, idStr string) {
+	actorID, _, ok := h.requireModerator(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid sanction ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	s, err := h.hub.sanctionRepo.RevokeSanction(ctx, id, actorID)
+	if err != nil {
+		logger.Error().Err(err).Int64("sanction_id", id).Msg("Failed to revoke chat sanction")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if s == nil {
+		http.Error(w, "Sanction not found", http.StatusNotFound)
+		return
+	}
+
+	h.hub.sanctionLifted(ctx, actorID, s.UserID, s.Kind)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func toSanctionResponse(s *storage.ChatSanction) SanctionResponse {
+	resp := SanctionResponse{
+		ID:        s.ID,
+		UserID:    s.UserID,
+		Username:  s.Username,
+		Kind:      s.Kind,
+		Reason:    s.Reason,
+		CreatedBy: s.CreatedBy,
+		CreatedAt: s.CreatedAt.Format(time.RFC3339),
+	}
+	if s.ExpiresAt != nil {
+		expiresAt := s.ExpiresAt.Format(time.RFC3339)
+		resp.ExpiresAt = &expiresAt
+	}
+	return resp
+}
@@ -0,0 +1,201 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/config"
+)
+
+func TestParseSanctionDuration(t *testing.T) {
+	tests := []struct {
+		in     string
+		want   time.Duration
+		wantOK bool
+	}{
+		{in: "10m", want: 10 * time.Minute, wantOK: true},
+		{in: "1h30m", want: 90 * time.Minute, wantOK: true},
+		{in: "7d", want: 7 * 24 * time.Hour, wantOK: true},
+		{in: "0d"},
+		{in: "-1d"},
+		{in: "0s"},
+		{in: "-5m"},
+		{in: "d"},
+		{in: "spam"},
+		{in: ""},
+	}
+	for _, tt := range tests {
+		got, ok := parseSanctionDuration(tt.in)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("parseSanctionDuration(%q) = %s, %v, want %s, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestSanctionEnvelope(t *testing.T) {
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+
+	tests := []struct {
+		sanction storage.ChatSanction
+		want     string
+	}{
+		{sanction: storage.ChatSanction{Kind: storage.SanctionMute, Reason: "spam", ExpiresAt: &expires}, want: SanctionMuted},
+		{sanction: storage.ChatSanction{Kind: storage.SanctionKick}, want: SanctionKicked},
+		{sanction: storage.ChatSanction{Kind: storage.SanctionBan}, want: SanctionBanned},
+	}
+	for _, tt := range tests {
+		env := sanctionEnvelope(&tt.sanction)
+		if env.Type != TypeSanction || env.Status != tt.want || env.Message != tt.sanction.Reason {
+			t.Errorf("envelope of a %s = %+v, want status %q", tt.sanction.Kind, env, tt.want)
+		}
+		if tt.sanction.ExpiresAt != nil && (env.ExpiresAt == nil || !env.ExpiresAt.Equal(expires) || env.ExpiresAt.Location() != time.UTC) {
+			t.Errorf("expires_at = %v, want %s in UTC", env.ExpiresAt, expires)
+		}
+	}
+}
+
+func TestSanctionRules(t *testing.T) {
+	// The rules are checked before anything is stored, so the hub needs no
+	// repositories
+	hub := NewHub(nil, nil, nil, nil, nil, nil, nil, nil, nil, NewMemoryBroker(), nil, config.NewConfig())
+
+	tests := []struct {
+		name      string
+		actorRole string
+		target    storage.User
+		wantErr   error
+	}{
+		{name: "self", actorRole: auth.RoleAdmin, target: storage.User{ID: 1, Role: auth.RoleAdmin}, wantErr: errSanctionSelf},
+		{name: "moderator by a moderator", actorRole: auth.RoleModerator, target: storage.User{ID: 2, Role: auth.RoleModerator}, wantErr: errSanctionModerator},
+		{name: "admin by a moderator", actorRole: auth.RoleModerator, target: storage.User{ID: 2, Role: auth.RoleAdmin}, wantErr: errSanctionModerator},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := hub.sanction(context.Background(), 1, tt.actorRole, &tt.target, storage.SanctionBan, 0, "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("sanction = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestModeratorActionsNeedSession(t *testing.T) {
+	hub := NewHub(nil, nil, nil, nil, nil, nil, nil, nil, nil, NewMemoryBroker(), nil, config.NewConfig())
+	userID := int64(1)
+
+	tests := []struct {
+		name     string
+		role     string
+		apiToken bool
+		run      func(c *Client)
+		wantMsg  string
+	}{
+		{
+			name:    "command from a user",
+			role:    "user",
+			run:     func(c *Client) { c.handleCommand(context.Background(), Envelope{ClientMsgID: "c1"}, "/mute @bob") },
+			wantMsg: "only moderators",
+		},
+		{
+			name:     "command from a moderator's API token",
+			role:     auth.RoleModerator,
+			apiToken: true,
+			run:      func(c *Client) { c.handleCommand(context.Background(), Envelope{ClientMsgID: "c1"}, "/ban @bob 1d") },
+			wantMsg:  "API tokens cannot use /ban",
+		},
+		{
+			name:     "pin from a moderator's API token",
+			role:     auth.RoleModerator,
+			apiToken: true,
+			run:      func(c *Client) { c.handlePin(context.Background(), Envelope{Type: TypePin, ID: 5, ClientMsgID: "c1"}) },
+			wantMsg:  "API tokens cannot pin",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFrameClient(t, hub, &userID, "")
+			client.role, client.apiToken = tt.role, tt.apiToken
+
+			tt.run(client)
+
+			env := nextFrame(t, client)
+			if env.Code != ErrCodeForbidden || env.ClientMsgID != "c1" || !strings.Contains(env.Message, tt.wantMsg) {
+				t.Fatalf("frame = %+v, want forbidden mentioning %q", env, tt.wantMsg)
+			}
+		})
+	}
+
+	// Content that is not a command is sent as a message
+	client := newFrameClient(t, hub, &userID, "")
+	if client.handleCommand(context.Background(), Envelope{}, "/shrug") {
+		t.Fatal("unknown command was handled")
+	}
+}
+
+func TestAllowedToSend(t *testing.T) {
+	db, _ := testDB(t)
+	hub := newTestHub(t, NewMemoryBroker(), db)
+	ctx := context.Background()
+
+	moderator := createTestUser(t, db, "mod")
+	target := createTestUser(t, db, "target")
+	client := newFrameClient(t, hub, &target.ID, "")
+
+	if !client.allowedToSend(ctx, Envelope{Type: TypeMessage}) {
+		t.Fatal("user without sanctions may not send")
+	}
+
+	if _, err := hub.sanction(ctx, moderator.ID, auth.RoleModerator, target, storage.SanctionMute, 5*time.Minute, "spam"); err != nil {
+		t.Fatalf("mute: %v", err)
+	}
+	if client.allowedToSend(ctx, Envelope{Type: TypeMessage, ClientMsgID: "m1"}) {
+		t.Fatal("muted user may send")
+	}
+	env := nextFrame(t, client)
+	if env.Code != ErrCodeMuted || env.ClientMsgID != "m1" || env.RetryAfter < 299 || env.RetryAfter > 300 {
+		t.Fatalf("frame = %+v, want muted with retry_after of 5 minutes", env)
+	}
+
+	// A ban takes precedence over the mute and has no end
+	if _, err := hub.sanction(ctx, moderator.ID, auth.RoleModerator, target, storage.SanctionBan, 0, ""); err != nil {
+		t.Fatalf("ban: %v", err)
+	}
+	if client.allowedToSend(ctx, Envelope{Type: TypeMessage}) {
+		t.Fatal("banned user may send")
+	}
+	if env := nextFrame(t, client); env.Code != ErrCodeBanned || env.RetryAfter != 0 {
+		t.Fatalf("frame = %+v, want banned without retry_after", env)
+	}
+
+	for _, kind := range []string{storage.SanctionBan, storage.SanctionMute} {
+		if lifted, err := hub.liftSanctions(ctx, moderator.ID, target, kind); err != nil || !lifted {
+			t.Fatalf("lift %s = %v, %v, want it lifted", kind, lifted, err)
+		}
+	}
+	if !client.allowedToSend(ctx, Envelope{Type: TypeMessage}) {
+		t.Fatal("user may not send after the sanctions were lifted")
+	}
+}
+
+// createTestUser creates a user for a test and deletes it afterwards
+func createTestUser(t *testing.T, db *storage.DB, prefix string) *storage.User {
+	t.Helper()
+
+	ctx := context.Background()
+	name := prefix + "_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	user, err := storage.NewUserRepository(db).CreateUser(ctx, name, name+"@example.com", "not a hash")
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	t.Cleanup(func() {
+		db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, user.ID)
+	})
+
+	return user
+}
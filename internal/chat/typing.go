@@ -0,0 +1,92 @@
+package chat
+
+import (
+	"context"
+	"time"
+)
+
+// typingMinInterval limits how often a user starts typing in a room, so that
+// a flood of typing frames does not reach every subscriber
+const typingMinInterval = 2 * time.Second
+
+type typingState struct {
+	timer *time.Timer
+}
+
+// handleTyping starts or stops the typing indicator of the user in a room.
+// While the user keeps typing, further frames only extend the indicator,
+// which stops on its own after ChatTypingTimeout without one.
+func (c *Client) handleTyping(env Envelope) {
+	if !c.joinedRoom(env) {
+		return
+	}
+	if env.Status == TypingStopped {
+		c.stopTyping(env.Room, true)
+		return
+	}
+
+	c.typingMu.Lock()
+	if c.typing == nil {
+		c.typing = make(map[int64]*typingState)
+		c.typingStarted = make(map[int64]time.Time)
+	}
+	if state, ok := c.typing[env.Room]; ok {
+		state.timer.Reset(c.hub.cfg.ChatTypingTimeout)
+		c.typingMu.Unlock()
+		return
+	}
+	if last, ok := c.typingStarted[env.Room]; ok && time.Since(last) < typingMinInterval {
+		c.typingMu.Unlock()
+		return
+	}
+	c.typingStarted[env.Room] = time.Now()
+	c.typingMu.Unlock()
+
+	// Muted users stay silent, typing frames get no error frames
+	if s, err := c.hub.activeSanction(context.Background(), *c.userID); err != nil || s != nil {
+		return
+	}
+
+	// Only the read goroutine starts typing, so the room is still free
+	room := env.Room
+	state := &typingState{}
+	state.timer = time.AfterFunc(c.hub.cfg.ChatTypingTimeout, func() {
+		c.expireTyping(room, state)
+	})
+	c.typingMu.Lock()
+	c.typing[room] = state
+	c.typingMu.Unlock()
+
+	c.hub.Broadcast(Envelope{Type: TypeTyping, Room: room, User: c.user(), Status: TypingStarted}, c)
+}
+
+// stopTyping clears the typing indicator of the user in a room. Receivers
+// clear it themselves when a message of the user arrives, so announce is
+// false then.
+func (c *Client) stopTyping(room int64, announce bool) {
+	c.typingMu.Lock()
+	state, ok := c.typing[room]
+	if ok {
+		state.timer.Stop()
+		delete(c.typing, room)
+	}
+	c.typingMu.Unlock()
+
+	if ok && announce {
+		c.hub.Broadcast(Envelope{Type: TypeTyping, Room: room, User: c.user(), Status: TypingStopped}, c)
+	}
+}
+
+// expireTyping stops an indicator that was not extended in time
+func (c *Client) expireTyping(room int64, state *typingState) {
+	c.typingMu.Lock()
+	current, ok := c.typing[room]
+	if ok && current == state {
+		delete(c.typing, room)
+	}
+	c.typingMu.Unlock()
+
+	if ok && current == state {
+		c.hub.Broadcast(Envelope{Type: TypeTyping, Room: room, User: c.user(), Status: TypingStopped}, c)
+	}
+}
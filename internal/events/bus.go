@@ -0,0 +1,65 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Handler processes an event. Events are delivered at least once, so a
+// handler may see an event again if it or the relay failed after the work
+// was done; it should use the event ID to do the work only once.
+type Handler func(ctx context.Context, e *Event) error
+
+type subscriber struct {
+	name    string
+	types   map[string]bool
+	handler Handler
+}
+
+// Bus holds the in-process subscribers of the events the relay dispatches
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers []*subscriber
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers the handler for the event types, or all of them if
+// none are given. The outbox remembers which subscribers handled an event by
+// name, so names must be unique and stay the same across restarts.
+func (b *Bus) Subscribe(name string, handler Handler, types ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, s := range b.subscribers {
+		if s.name == name {
+			panic(fmt.Sprintf("events: subscriber %q registered twice", name))
+		}
+	}
+
+	var typeSet map[string]bool
+	if len(types) > 0 {
+		typeSet = make(map[string]bool, len(types))
+		for _, t := range types {
+			typeSet[t] = true
+		}
+	}
+	b.subscribers = append(b.subscribers, &subscriber{name: name, types: typeSet, handler: handler})
+}
+
+// subscribersOf returns the subscribers of the event type in registration order
+func (b *Bus) subscribersOf(eventType string) []*subscriber {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var subscribers []*subscriber
+	for _, s := range b.subscribers {
+		if s.types == nil || s.types[eventType] {
+			subscribers = append(subscribers, s)
+		}
+	}
+	return subscribers
+}
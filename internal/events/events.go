@@ -0,0 +1,97 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Event types
+const (
+	PostCreated    = "post.created"
+	PostDeleted    = "post.deleted"
+	CommentCreated = "comment.created"
+	CommentDeleted = "comment.deleted"
+	UserRegistered = "user.registered"
+	ReportFiled    = "report.filed"
+)
+
+// Event is a change in the forum. Its ID stays the same when it is
+// redelivered, so subscribers can use it as idempotency key.
+type Event struct {
+	ID         string
+	Type       string
+	OccurredAt time.Time
+	// Payload is the JSON of the data struct of the type
+	Payload json.RawMessage
+}
+
+type PostCreatedData struct {
+	ID        int64     `json:"id"`
+	Title     string    `json:"title"`
+	Content   string    `json:"content"`
+	UserID    int64     `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type PostDeletedData struct {
+	ID     int64 `json:"id"`
+	UserID int64 `json:"user_id"`
+}
+
+type CommentCreatedData struct {
+	ID        int64     `json:"id"`
+	PostID    int64     `json:"post_id"`
+	ParentID  *int64    `json:"parent_id,omitempty"`
+	Content   string    `json:"content"`
+	UserID    int64     `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CommentDeletedData struct {
+	ID     int64 `json:"id"`
+	PostID int64 `json:"post_id"`
+	UserID int64 `json:"user_id"`
+}
+
+// UserRegisteredData leaves the email address out on purpose
+type UserRegisteredData struct {
+	ID        int64     `json:"id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ReportFiledData struct {
+	ID         int64     `json:"id"`
+	ReporterID int64     `json:"reporter_id"`
+	TargetType string    `json:"target_type"`
+	TargetID   int64     `json:"target_id"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// New returns an event of the type with a fresh ID
+func New(eventType string, data interface{}) (*Event, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	return &Event{
+		ID:         hex.EncodeToString(b),
+		Type:       eventType,
+		OccurredAt: time.Now().UTC(),
+		Payload:    payload,
+	}, nil
+}
+
+// Decode unmarshals the payload into the data struct of the type
+func (e *Event) Decode(v interface{}) error {
+	return json.Unmarshal(e.Payload, v)
+}
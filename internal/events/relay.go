@@ -0,0 +1,181 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/pkg/config"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+const (
+	// leaseDuration is how long a claimed batch is hidden from other relays
+	leaseDuration = 5 * time.Minute
+	// cleanupInterval is how often dispatched events past retention are deleted
+	cleanupInterval = time.Hour
+)
+
+// Record is an event in the outbox with the number of failed dispatches
+type Record struct {
+	Event
+	Attempts int
+}
+
+// Outbox stores the events until every subscriber handled them.
+// storage.OutboxRepository implements it.
+type Outbox interface {
+	// ClaimEvents returns up to limit due events, oldest first, and hides
+	// them from other relays until leaseUntil
+	ClaimEvents(ctx context.Context, limit int, leaseUntil time.Time) ([]Record, error)
+	// HandledBy returns the names of the subscribers that handled the event
+	HandledBy(ctx context.Context, eventID string) (map[string]bool, error)
+	MarkHandled(ctx context.Context, eventID, subscriber string) error
+	// RecordAttempt marks the event dispatched, or failed and due again at
+	// nextAttemptAt, or dead if that is nil
+	RecordAttempt(ctx context.Context, eventID string, dispatched bool, errMsg string, nextAttemptAt *time.Time) error
+	DeleteDispatched(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Relay moves the events from the outbox to the subscribers of the bus. An
+// event is dispatched until every subscriber handled it; subscribers that
+// already did are skipped on retries.
+type Relay struct {
+	outbox Outbox
+	bus    *Bus
+	cfg    *config.Config
+}
+
+func NewRelay(outbox Outbox, bus *Bus, cfg *config.Config) *Relay {
+	return &Relay{
+		outbox: outbox,
+		bus:    bus,
+		cfg:    cfg,
+	}
+}
+
+// Run dispatches the due events every OutboxPollInterval until ctx is done
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.OutboxPollInterval)
+	defer ticker.Stop()
+	cleanup := time.NewTicker(cleanupInterval)
+	defer cleanup.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.dispatchDue(ctx)
+		case <-cleanup.C:
+			r.deleteDispatched(ctx)
+		}
+	}
+}
+
+// dispatchDue dispatches due events, OutboxBatchSize at a time, until none
+// are left. Events of a batch are dispatched in order.
+func (r *Relay) dispatchDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		records, err := r.outbox.ClaimEvents(ctx, r.cfg.OutboxBatchSize, time.Now().Add(leaseDuration))
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to claim outbox events")
+			return
+		}
+
+		for i := range records {
+			r.dispatch(ctx, &records[i])
+		}
+
+		if len(records) < r.cfg.OutboxBatchSize {
+			return
+		}
+	}
+}
+
+// dispatch hands the event to the subscribers that did not handle it yet and
+// records the outcome
+func (r *Relay) dispatch(ctx context.Context, record *Record) {
+	handled, err := r.outbox.HandledBy(ctx, record.ID)
+	if err != nil {
+		logger.Error().Err(err).Str("event_id", record.ID).Msg("Failed to get subscribers that handled event")
+		return
+	}
+
+	var failures []string
+	for _, s := range r.bus.subscribersOf(record.Type) {
+		if handled[s.name] {
+			continue
+		}
+		if err := call(ctx, s, &record.Event); err != nil {
+			failures = append(failures, s.name+": "+err.Error())
+			continue
+		}
+		if err := r.outbox.MarkHandled(ctx, record.ID, s.name); err != nil {
+			failures = append(failures, s.name+": "+err.Error())
+		}
+	}
+
+	var errMsg string
+	var nextAttemptAt *time.Time
+	if len(failures) > 0 {
+		errMsg = strings.Join(failures, "; ")
+		if attempt := record.Attempts + 1; attempt < r.cfg.OutboxMaxAttempts {
+			next := time.Now().Add(r.backoff(attempt))
+			nextAttemptAt = &next
+		}
+	}
+
+	// Record the outcome even if ctx was cancelled in the meantime
+	if err := r.outbox.RecordAttempt(context.Background(), record.ID, len(failures) == 0, errMsg, nextAttemptAt); err != nil {
+		logger.Error().Err(err).Str("event_id", record.ID).Msg("Failed to record event dispatch")
+		return
+	}
+
+	switch {
+	case len(failures) == 0:
+	case nextAttemptAt == nil:
+		logger.Warn().Str("error", errMsg).Str("event_id", record.ID).Str("type", record.Type).
+			Msg("Event dispatch failed for good")
+	default:
+		logger.Info().Str("error", errMsg).Str("event_id", record.ID).Str("type", record.Type).
+			Time("next_attempt_at", *nextAttemptAt).Msg("Event dispatch failed, will retry")
+	}
+}
+
+func (r *Relay) deleteDispatched(ctx context.Context) {
+	deleted, err := r.outbox.DeleteDispatched(ctx, time.Now().Add(-r.cfg.OutboxRetention))
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to delete dispatched outbox events")
+		return
+	}
+	if deleted > 0 {
+		logger.Info().Int64("deleted", deleted).Msg("Deleted dispatched outbox events")
+	}
+}
+
+// backoff is the delay before the attempt after the given one:
+// OutboxBackoffBase doubled for every failed attempt, capped at
+// OutboxBackoffMax
+func (r *Relay) backoff(attempt int) time.Duration {
+	delay := r.cfg.OutboxBackoffBase
+	for i := 1; i < attempt && delay < r.cfg.OutboxBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > r.cfg.OutboxBackoffMax {
+		delay = r.cfg.OutboxBackoffMax
+	}
+	return delay
+}
+
+// call runs the handler of the subscriber, turning a panic into an error so
+// that one broken subscriber does not stop the relay
+func call(ctx context.Context, s *subscriber, e *Event) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return s.handler(ctx, e)
+}
@@ -0,0 +1,398 @@
+package forum
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/Ryan-Gosusluging/forum/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// AccountHandler serves sign-in, two-factor, password recovery, email
+// verification and password change endpoints
+type AccountHandler struct {
+	authClient proto.AuthServiceClient
+}
+
+func NewAccountHandler(authClient proto.AuthServiceClient) *AccountHandler {
+	return &AccountHandler{
+		authClient: authClient,
+	}
+}
+
+func (h *AccountHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/me/mfa" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.handleMFAStatus(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/api/auth/login":
+		h.handleLogin(w, r)
+	case "/api/auth/login/mfa":
+		h.handleVerifyMFA(w, r)
+	case "/api/auth/password-reset":
+		h.handleRequestPasswordReset(w, r)
+	case "/api/auth/password-reset/confirm":
+		h.handleResetPassword(w, r)
+	case "/api/auth/verify-email":
+		h.handleVerifyEmail(w, r)
+	case "/api/auth/verify-email/resend":
+		h.handleResendVerification(w, r)
+	case "/api/me/password":
+		h.handleChangePassword(w, r)
+	case "/api/me/mfa/enroll":
+		h.handleEnrollMFA(w, r)
+	case "/api/me/mfa/confirm":
+		h.handleConfirmMFA(w, r)
+	case "/api/me/mfa/disable":
+		h.handleDisableMFA(w, r)
+	case "/api/me/mfa/recovery-codes":
+		h.handleRegenerateRecoveryCodes(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *AccountHandler) handleRequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.authClient.RequestPasswordReset(r.Context(), &proto.RequestPasswordResetRequest{Email: req.Email}); err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *AccountHandler) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	_, err := h.authClient.ResetPassword(r.Context(), &proto.ResetPasswordRequest{
+		ResetToken:  req.Token,
+		NewPassword: req.NewPassword,
+	})
+	if err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AccountHandler) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.authClient.VerifyEmail(r.Context(), &proto.VerifyEmailRequest{VerificationToken: req.Token}); err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AccountHandler) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	token := auth.TokenFromRequest(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := h.authClient.SendEmailVerification(r.Context(), &proto.SendEmailVerificationRequest{Token: token}); err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (h *AccountHandler) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	token := auth.TokenFromRequest(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.authClient.ChangePassword(r.Context(), &proto.ChangePasswordRequest{
+		Token:           token,
+		CurrentPassword: req.CurrentPassword,
+		NewPassword:     req.NewPassword,
+		ClientIp:        remoteIP(r),
+	})
+	if err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"token": resp.Token}); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
+
+// LoginResponse is returned by both sign-in steps. When MFARequired is set
+// the client must post a code together with MFAToken to /api/auth/login/mfa.
+type LoginResponse struct {
+	Token                 string `json:"token,omitempty"`
+	UserID                int64  `json:"user_id"`
+	Username              string `json:"username"`
+	Role                  string `json:"role,omitempty"`
+	MFARequired           bool   `json:"mfa_required,omitempty"`
+	MFAToken              string `json:"mfa_token,omitempty"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required,omitempty"`
+}
+
+func (h *AccountHandler) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Username == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.authClient.Login(r.Context(), &proto.LoginRequest{
+		Username: req.Username,
+		Password: req.Password,
+		ClientIp: remoteIP(r),
+	})
+	if err != nil {
+		writeLoginError(w, err)
+		return
+	}
+
+	writeLoginResponse(w, resp)
+}
+
+func (h *AccountHandler) handleVerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.authClient.VerifyMFA(r.Context(), &proto.VerifyMFARequest{
+		MfaToken: req.MFAToken,
+		Code:     req.Code,
+		ClientIp: remoteIP(r),
+	})
+	if err != nil {
+		writeLoginError(w, err)
+		return
+	}
+
+	writeLoginResponse(w, resp)
+}
+
+func (h *AccountHandler) handleMFAStatus(w http.ResponseWriter, r *http.Request) {
+	token := auth.TokenFromRequest(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	resp, err := h.authClient.GetMFAStatus(r.Context(), &proto.GetMFAStatusRequest{Token: token})
+	if err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"enabled":                  resp.Enabled,
+		"required":                 resp.Required,
+		"recovery_codes_remaining": resp.RecoveryCodesRemaining,
+	}); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
+
+func (h *AccountHandler) handleEnrollMFA(w http.ResponseWriter, r *http.Request) {
+	token := auth.TokenFromRequest(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	resp, err := h.authClient.EnrollMFA(r.Context(), &proto.EnrollMFARequest{Token: token})
+	if err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(map[string]string{
+		"secret":           resp.Secret,
+		"provisioning_uri": resp.ProvisioningUri,
+	}); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
+
+func (h *AccountHandler) handleConfirmMFA(w http.ResponseWriter, r *http.Request) {
+	token := auth.TokenFromRequest(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	code, ok := decodeMFACode(w, r)
+	if !ok {
+		return
+	}
+
+	resp, err := h.authClient.ConfirmMFA(r.Context(), &proto.ConfirmMFARequest{Token: token, Code: code})
+	if err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	writeRecoveryCodes(w, resp)
+}
+
+func (h *AccountHandler) handleDisableMFA(w http.ResponseWriter, r *http.Request) {
+	token := auth.TokenFromRequest(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	code, ok := decodeMFACode(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := h.authClient.DisableMFA(r.Context(), &proto.DisableMFARequest{Token: token, Code: code, ClientIp: remoteIP(r)}); err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AccountHandler) handleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	token := auth.TokenFromRequest(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	code, ok := decodeMFACode(w, r)
+	if !ok {
+		return
+	}
+
+	resp, err := h.authClient.RegenerateRecoveryCodes(r.Context(), &proto.RegenerateRecoveryCodesRequest{Token: token, Code: code, ClientIp: remoteIP(r)})
+	if err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	writeRecoveryCodes(w, resp)
+}
+
+func decodeMFACode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return "", false
+	}
+	return req.Code, true
+}
+
+func writeLoginResponse(w http.ResponseWriter, resp *proto.LoginResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(LoginResponse{
+		Token:                 resp.Token,
+		UserID:                resp.UserId,
+		Username:              resp.Username,
+		Role:                  resp.Role,
+		MFARequired:           resp.MfaRequired,
+		MFAToken:              resp.MfaToken,
+		MFAEnrollmentRequired: resp.MfaEnrollmentRequired,
+	}); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
+
+func writeRecoveryCodes(w http.ResponseWriter, resp *proto.ConfirmMFAResponse) {
+	body := map[string]interface{}{"recovery_codes": resp.RecoveryCodes}
+	if resp.Token != "" {
+		body["token"] = resp.Token
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
+
+// writeLoginError reports sign-in failures. The legacy Login errors carry no
+// status code, so anything unknown is treated as bad credentials.
+func writeLoginError(w http.ResponseWriter, err error) {
+	st, ok := status.FromError(err)
+	if ok && st.Code() != codes.Unknown {
+		writeRPCError(w, err)
+		return
+	}
+	http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+}
+
+// remoteIP returns the address of the HTTP client, forwarded to the auth
+// service for throttling
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,54 @@
+package forum
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/pkg/proto"
+)
+
+// AdminHandler serves administrative endpoints under /api/admin/
+type AdminHandler struct {
+	authClient proto.AuthServiceClient
+}
+
+func NewAdminHandler(authClient proto.AuthServiceClient) *AdminHandler {
+	return &AdminHandler{
+		authClient: authClient,
+	}
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/"), "/"), "/")
+
+	switch {
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "unlock":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.handleUnlockUser(w, r, parts[1])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *AdminHandler) handleUnlockUser(w http.ResponseWriter, r *http.Request, username string) {
+	token := auth.TokenFromRequest(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	_, err := h.authClient.UnlockAccount(r.Context(), &proto.UnlockAccountRequest{
+		Token:    token,
+		Username: username,
+	})
+	if err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
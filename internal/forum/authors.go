@@ -0,0 +1,56 @@
+package forum
+
+import (
+	"context"
+
+	"github.com/Ryan-Gosusluging/forum/pkg/proto"
+)
+
+// AuthorCard is the compact author block rendered next to posts and comments
+type AuthorCard struct {
+	UserID      int64  `json:"user_id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	Role        string `json:"role"`
+	Signature   string `json:"signature,omitempty"`
+}
+
+func newAuthorCard(profile *proto.UserProfile) *AuthorCard {
+	return &AuthorCard{
+		UserID:      profile.UserId,
+		Username:    profile.Username,
+		DisplayName: profile.DisplayName,
+		AvatarURL:   profile.AvatarUrl,
+		Role:        profile.Role,
+		Signature:   profile.Signature,
+	}
+}
+
+// loadAuthorCards fetches author cards for the given user IDs in a single RPC
+func loadAuthorCards(ctx context.Context, client proto.AuthServiceClient, userIDs []int64) (map[int64]*AuthorCard, error) {
+	cards := make(map[int64]*AuthorCard, len(userIDs))
+	if len(userIDs) == 0 {
+		return cards, nil
+	}
+
+	seen := make(map[int64]bool, len(userIDs))
+	unique := make([]int64, 0, len(userIDs))
+	for _, id := range userIDs {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	resp, err := client.GetUsersByIDs(ctx, &proto.GetUsersByIDsRequest{UserIds: unique})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, profile := range resp.Users {
+		cards[profile.UserId] = newAuthorCard(profile)
+	}
+
+	return cards, nil
+}
@@ -2,6 +2,7 @@ package forum
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
@@ -14,16 +15,30 @@ import (
 // CommentHandler handles HTTP requests related to forum comments
 type CommentHandler struct {
 	commentRepo storage.CommentRepository
+	subRepo     *storage.SubscriptionRepository
 	authService *auth.Service
-	logger      *logger.Logger
 }
 
 // NewCommentHandler creates a new CommentHandler instance
-func NewCommentHandler(commentRepo storage.CommentRepository, authService *auth.Service, logger *logger.Logger) *CommentHandler {
+func NewCommentHandler(commentRepo storage.CommentRepository, subRepo *storage.SubscriptionRepository, authService *auth.Service) *CommentHandler {
 	return &CommentHandler{
 		commentRepo: commentRepo,
+		subRepo:     subRepo,
 		authService: authService,
-		logger:      logger,
+	}
+}
+
+// ServeHTTP routes /api/comments requests by method
+func (h *CommentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.handleGetComments(w, r)
+	case http.MethodPost:
+		h.handleCreateComment(w, r)
+	case http.MethodDelete:
+		h.handleDeleteComment(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
@@ -37,7 +52,7 @@ func (h *CommentHandler) handleGetComments(w http.ResponseWriter, r *http.Reques
 
 	comments, err := h.commentRepo.GetCommentsByPostID(r.Context(), postID)
 	if err != nil {
-		h.logger.Error().Err(err).Msg("Failed to get comments")
+		logger.Error().Err(err).Msg("Failed to get comments")
 		http.Error(w, "Failed to get comments", http.StatusInternalServerError)
 		return
 	}
@@ -48,11 +63,16 @@ func (h *CommentHandler) handleGetComments(w http.ResponseWriter, r *http.Reques
 
 // handleCreateComment creates a new comment
 func (h *CommentHandler) handleCreateComment(w http.ResponseWriter, r *http.Request) {
-	userID, err := h.authService.GetUserIDFromRequest(r)
+	claims, err := h.authService.Authenticate(r, auth.ScopePostsWrite)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		if errors.Is(err, auth.ErrMissingScope) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		} else {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
 		return
 	}
+	userID := claims.UserId
 
 	var comment storage.Comment
 	if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
@@ -63,12 +83,31 @@ func (h *CommentHandler) handleCreateComment(w http.ResponseWriter, r *http.Requ
 	comment.UserID = userID
 	comment.CreatedAt = time.Now()
 
+	// Replies must answer a comment of the same post
+	if comment.ParentID != nil {
+		parent, err := h.commentRepo.GetCommentByID(r.Context(), *comment.ParentID)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to get comment")
+			http.Error(w, "Failed to create comment", http.StatusInternalServerError)
+			return
+		}
+		if parent == nil || parent.PostID != comment.PostID {
+			http.Error(w, "Parent comment not found", http.StatusBadRequest)
+			return
+		}
+	}
+
 	if err := h.commentRepo.CreateComment(r.Context(), &comment); err != nil {
-		h.logger.Error().Err(err).Msg("Failed to create comment")
+		logger.Error().Err(err).Msg("Failed to create comment")
 		http.Error(w, "Failed to create comment", http.StatusInternalServerError)
 		return
 	}
 
+	// Commenters follow the post
+	if err := h.subRepo.Subscribe(r.Context(), userID, comment.PostID); err != nil {
+		logger.Error().Err(err).Int64("post_id", comment.PostID).Msg("Failed to subscribe commenter to post")
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(comment)
@@ -76,11 +115,16 @@ func (h *CommentHandler) handleCreateComment(w http.ResponseWriter, r *http.Requ
 
 // handleDeleteComment deletes a comment
 func (h *CommentHandler) handleDeleteComment(w http.ResponseWriter, r *http.Request) {
-	userID, err := h.authService.GetUserIDFromRequest(r)
+	claims, err := h.authService.Authenticate(r, auth.ScopePostsWrite)
 	if err != nil {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		if errors.Is(err, auth.ErrMissingScope) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		} else {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
 		return
 	}
+	userID := claims.UserId
 
 	commentID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
 	if err != nil {
@@ -90,7 +134,7 @@ func (h *CommentHandler) handleDeleteComment(w http.ResponseWriter, r *http.Requ
 
 	comment, err := h.commentRepo.GetCommentByID(r.Context(), commentID)
 	if err != nil {
-		h.logger.Error().Err(err).Msg("Failed to get comment")
+		logger.Error().Err(err).Msg("Failed to get comment")
 		http.Error(w, "Comment not found", http.StatusNotFound)
 		return
 	}
@@ -101,7 +145,7 @@ func (h *CommentHandler) handleDeleteComment(w http.ResponseWriter, r *http.Requ
 	}
 
 	if err := h.commentRepo.DeleteComment(r.Context(), commentID); err != nil {
-		h.logger.Error().Err(err).Msg("Failed to delete comment")
+		logger.Error().Err(err).Msg("Failed to delete comment")
 		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
 		return
 	}
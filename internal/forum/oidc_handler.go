@@ -0,0 +1,244 @@
+package forum
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/Ryan-Gosusluging/forum/pkg/proto"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	oidcCookiePath  = "/api/auth/oidc/"
+)
+
+// OIDCHandler serves sign-in with external identity providers under
+// /api/auth/oidc/ and management of linked identities under /api/me/identities
+type OIDCHandler struct {
+	authClient proto.AuthServiceClient
+}
+
+type IdentityResponse struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewOIDCHandler(authClient proto.AuthServiceClient) *OIDCHandler {
+	return &OIDCHandler{
+		authClient: authClient,
+	}
+}
+
+func (h *OIDCHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/me/identities") {
+		h.serveIdentities(w, r)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, oidcCookiePath), "/"), "/")
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0] == "providers":
+		h.handleListProviders(w, r)
+	case len(parts) == 2 && parts[1] == "login":
+		h.handleLogin(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "callback":
+		h.handleCallback(w, r, parts[0])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *OIDCHandler) serveIdentities(w http.ResponseWriter, r *http.Request) {
+	provider := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/me/identities"), "/")
+
+	switch {
+	case provider == "" && r.Method == http.MethodGet:
+		h.handleListIdentities(w, r)
+	case provider != "" && r.Method == http.MethodPost:
+		h.handleStartLink(w, r, provider)
+	case provider != "" && r.Method == http.MethodDelete:
+		h.handleUnlink(w, r, provider)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *OIDCHandler) handleListProviders(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.authClient.ListOIDCProviders(r.Context(), &proto.ListOIDCProvidersRequest{})
+	if err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	providers := resp.Providers
+	if providers == nil {
+		providers = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string][]string{"providers": providers}); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
+
+// handleLogin redirects to the provider. The state is also kept in a cookie,
+// so that the callback is only accepted in the browser that started the flow.
+func (h *OIDCHandler) handleLogin(w http.ResponseWriter, r *http.Request, provider string) {
+	resp, err := h.authClient.StartOIDCLogin(r.Context(), &proto.StartOIDCLoginRequest{Provider: provider})
+	if err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    resp.State,
+		Path:     oidcCookiePath,
+		MaxAge:   int((10 * time.Minute).Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, resp.AuthorizationUrl, http.StatusFound)
+}
+
+// handleCallback completes a flow. Sign-in flows must come from the browser
+// holding the state cookie; linking flows are bound to the caller's session.
+func (h *OIDCHandler) handleCallback(w http.ResponseWriter, r *http.Request, provider string) {
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		http.Error(w, "Sign-in was not completed: "+errCode, http.StatusBadRequest)
+		return
+	}
+
+	state := query.Get("state")
+	code := query.Get("code")
+	if state == "" || code == "" {
+		http.Error(w, "Missing state or code", http.StatusBadRequest)
+		return
+	}
+
+	token := auth.TokenFromRequest(r)
+	if token == "" {
+		cookie, err := r.Cookie(oidcStateCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+			http.Error(w, "Invalid sign-in state", http.StatusBadRequest)
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     oidcCookiePath,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	resp, err := h.authClient.CompleteOIDCLogin(r.Context(), &proto.CompleteOIDCLoginRequest{
+		Provider: provider,
+		State:    state,
+		Code:     code,
+		Token:    token,
+	})
+	if err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	if resp.Linked {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]bool{"linked": true}); err != nil {
+			logger.Error().Err(err).Msg("Failed to encode response")
+		}
+		return
+	}
+
+	writeLoginResponse(w, resp.Login)
+}
+
+func (h *OIDCHandler) handleListIdentities(w http.ResponseWriter, r *http.Request) {
+	token := auth.TokenFromRequest(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	resp, err := h.authClient.ListIdentities(r.Context(), &proto.ListIdentitiesRequest{Token: token})
+	if err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	identities := make([]IdentityResponse, 0, len(resp.Identities))
+	for _, identity := range resp.Identities {
+		identities = append(identities, IdentityResponse{
+			Provider:  identity.Provider,
+			Email:     identity.Email,
+			CreatedAt: time.Unix(identity.CreatedAt, 0).UTC(),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"identities":   identities,
+		"has_password": resp.HasPassword,
+	}); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
+
+// handleStartLink returns the provider URL for linking. The client completes
+// the flow by calling the callback endpoint with its session token.
+func (h *OIDCHandler) handleStartLink(w http.ResponseWriter, r *http.Request, provider string) {
+	token := auth.TokenFromRequest(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	resp, err := h.authClient.StartOIDCLogin(r.Context(), &proto.StartOIDCLoginRequest{
+		Provider: provider,
+		Token:    token,
+	})
+	if err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"authorization_url": resp.AuthorizationUrl}); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
+
+func (h *OIDCHandler) handleUnlink(w http.ResponseWriter, r *http.Request, provider string) {
+	token := auth.TokenFromRequest(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	_, err := h.authClient.UnlinkIdentity(r.Context(), &proto.UnlinkIdentityRequest{
+		Token:    token,
+		Provider: provider,
+	})
+	if err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
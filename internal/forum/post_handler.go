@@ -2,40 +2,57 @@ package forum
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Ryan-Gosusluging/forum/internal/auth"
 	"github.com/Ryan-Gosusluging/forum/internal/storage"
 	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/Ryan-Gosusluging/forum/pkg/proto"
 )
 
 type PostResponse struct {
-	ID        int64  `json:"id"`
-	Title     string `json:"title"`
-	Content   string `json:"content"`
-	UserID    int64  `json:"user_id"`
-	Username  string `json:"username"`
-	CreatedAt string `json:"created_at"`
-	UpdatedAt string `json:"updated_at"`
+	ID        int64       `json:"id"`
+	Title     string      `json:"title"`
+	Content   string      `json:"content"`
+	UserID    int64       `json:"user_id"`
+	Username  string      `json:"username"`
+	Author    *AuthorCard `json:"author,omitempty"`
+	CreatedAt string      `json:"created_at"`
+	UpdatedAt string      `json:"updated_at"`
+}
+
+type SubscriptionResponse struct {
+	Subscribed bool `json:"subscribed"`
 }
 
 type PostHandler struct {
 	postRepo *storage.PostRepository
 	userRepo *storage.UserRepository
+	subRepo  *storage.SubscriptionRepository
 	auth     *auth.Service
+	authors  proto.AuthServiceClient
 }
 
-func NewPostHandler(postRepo *storage.PostRepository, userRepo *storage.UserRepository, auth *auth.Service) *PostHandler {
+func NewPostHandler(postRepo *storage.PostRepository, userRepo *storage.UserRepository, subRepo *storage.SubscriptionRepository, auth *auth.Service, authors proto.AuthServiceClient) *PostHandler {
 	return &PostHandler{
 		postRepo: postRepo,
 		userRepo: userRepo,
+		subRepo:  subRepo,
 		auth:     auth,
+		authors:  authors,
 	}
 }
 
 func (h *PostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/subscription") {
+		h.handleSubscription(w, r)
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		h.handleGetPosts(w, r)
@@ -82,24 +99,33 @@ func (h *PostHandler) handleGetPosts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Load author cards for all posts at once
+	userIDs := make([]int64, len(posts))
+	for i, post := range posts {
+		userIDs[i] = post.UserID
+	}
+	authors, err := loadAuthorCards(ctx, h.authors, userIDs)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get authors")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
 	// Convert posts to response format
 	response := make([]PostResponse, len(posts))
 	for i, post := range posts {
-		user, err := h.userRepo.GetUserByID(ctx, post.UserID)
-		if err != nil {
-			logger.Error().Err(err).Msg("Failed to get user")
-			continue
-		}
-
 		response[i] = PostResponse{
 			ID:        post.ID,
 			Title:     post.Title,
 			Content:   post.Content,
 			UserID:    post.UserID,
-			Username:  user.Username,
 			CreatedAt: post.CreatedAt.Format(time.RFC3339),
 			UpdatedAt: post.UpdatedAt.Format(time.RFC3339),
 		}
+		if author, ok := authors[post.UserID]; ok {
+			response[i].Username = author.Username
+			response[i].Author = author
+		}
 	}
 
 	// Send response
@@ -113,7 +139,7 @@ func (h *PostHandler) handleGetPosts(w http.ResponseWriter, r *http.Request) {
 
 func (h *PostHandler) handleCreatePost(w http.ResponseWriter, r *http.Request) {
 	// Check authentication
-	token := r.Header.Get("Authorization")
+	token := auth.TokenFromRequest(r)
 	if token == "" {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -125,6 +151,10 @@ func (h *PostHandler) handleCreatePost(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	if !auth.HasScope(resp, auth.ScopePostsWrite) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	// Parse request body
 	var req struct {
@@ -144,6 +174,11 @@ func (h *PostHandler) handleCreatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Authors follow their posts
+	if err := h.subRepo.Subscribe(ctx, post.UserID, post.ID); err != nil {
+		logger.Error().Err(err).Int64("post_id", post.ID).Msg("Failed to subscribe author to post")
+	}
+
 	// Get user info
 	user, err := h.userRepo.GetUserByID(ctx, post.UserID)
 	if err != nil {
@@ -173,7 +208,7 @@ func (h *PostHandler) handleCreatePost(w http.ResponseWriter, r *http.Request) {
 
 func (h *PostHandler) handleDeletePost(w http.ResponseWriter, r *http.Request) {
 	// Check authentication
-	token := r.Header.Get("Authorization")
+	token := auth.TokenFromRequest(r)
 	if token == "" {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
@@ -185,6 +220,10 @@ func (h *PostHandler) handleDeletePost(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
+	if !auth.HasScope(resp, auth.ScopePostsWrite) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	// Get post ID from URL
 	postIDStr := r.URL.Path[len("/api/posts/"):]
@@ -215,3 +254,58 @@ func (h *PostHandler) handleDeletePost(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// handleSubscription follows (PUT) or unfollows (DELETE) the post given as
+// /api/posts/{id}/subscription, or tells whether the user follows it (GET)
+func (h *PostHandler) handleSubscription(w http.ResponseWriter, r *http.Request) {
+	scope := auth.ScopePostsWrite
+	if r.Method == http.MethodGet {
+		scope = auth.ScopePostsRead
+	}
+	claims, err := h.auth.Authenticate(r, scope)
+	if err != nil {
+		if errors.Is(err, auth.ErrMissingScope) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		} else {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
+		return
+	}
+
+	postIDStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/posts/"), "/subscription")
+	postID, err := strconv.ParseInt(postIDStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	if _, err := h.postRepo.GetPostByID(ctx, postID); err != nil {
+		http.Error(w, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	var subscribed bool
+	switch r.Method {
+	case http.MethodGet:
+		subscribed, err = h.subRepo.IsSubscribed(ctx, claims.UserId, postID)
+	case http.MethodPut:
+		subscribed = true
+		err = h.subRepo.Subscribe(ctx, claims.UserId, postID)
+	case http.MethodDelete:
+		_, err = h.subRepo.Unsubscribe(ctx, claims.UserId, postID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		logger.Error().Err(err).Int64("post_id", postID).Msg("Failed to update post subscription")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(SubscriptionResponse{Subscribed: subscribed}); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
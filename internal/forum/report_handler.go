@@ -0,0 +1,99 @@
+package forum
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+const maxReportReasonLength = 1000
+
+type ReportRequest struct {
+	TargetType string `json:"target_type"`
+	TargetID   int64  `json:"target_id"`
+	Reason     string `json:"reason"`
+}
+
+type ReportResponse struct {
+	ID         int64  `json:"id"`
+	TargetType string `json:"target_type"`
+	TargetID   int64  `json:"target_id"`
+	Reason     string `json:"reason"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// ReportHandler lets users report posts and comments to the moderators
+// under /api/reports
+type ReportHandler struct {
+	reportRepo *storage.ReportRepository
+	auth       *auth.Service
+}
+
+func NewReportHandler(reportRepo *storage.ReportRepository, auth *auth.Service) *ReportHandler {
+	return &ReportHandler{
+		reportRepo: reportRepo,
+		auth:       auth,
+	}
+}
+
+func (h *ReportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := h.auth.Authenticate(r, auth.ScopePostsWrite)
+	if err != nil {
+		if errors.Is(err, auth.ErrMissingScope) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		} else {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
+		return
+	}
+
+	var req ReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.TargetType != storage.ReportTargetPost && req.TargetType != storage.ReportTargetComment {
+		http.Error(w, "Target type must be post or comment", http.StatusBadRequest)
+		return
+	}
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" || utf8.RuneCountInString(reason) > maxReportReasonLength {
+		http.Error(w, "Reason must be between 1 and 1000 characters", http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.reportRepo.CreateReport(r.Context(), claims.UserId, req.TargetType, req.TargetID, reason)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create report")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if report == nil {
+		http.Error(w, "Target not found", http.StatusNotFound)
+		return
+	}
+
+	logger.Info().Int64("report_id", report.ID).Int64("user_id", claims.UserId).Str("target_type", report.TargetType).Int64("target_id", report.TargetID).Msg("Report filed")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ReportResponse{
+		ID:         report.ID,
+		TargetType: report.TargetType,
+		TargetID:   report.TargetID,
+		Reason:     report.Reason,
+		CreatedAt:  report.CreatedAt.Format(time.RFC3339),
+	})
+}
@@ -0,0 +1,145 @@
+package forum
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/Ryan-Gosusluging/forum/pkg/proto"
+)
+
+// TokenHandler manages personal access tokens under /api/me/tokens
+type TokenHandler struct {
+	authClient proto.AuthServiceClient
+}
+
+type AccessTokenResponse struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+type CreateAccessTokenRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int32    `json:"expires_in_days"`
+}
+
+func NewTokenHandler(authClient proto.AuthServiceClient) *TokenHandler {
+	return &TokenHandler{
+		authClient: authClient,
+	}
+}
+
+func (h *TokenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := auth.TokenFromRequest(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/me/tokens"), "/")
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		h.handleListTokens(w, r, token)
+	case id == "" && r.Method == http.MethodPost:
+		h.handleCreateToken(w, r, token)
+	case id != "" && r.Method == http.MethodDelete:
+		h.handleRevokeToken(w, r, token, id)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *TokenHandler) handleListTokens(w http.ResponseWriter, r *http.Request, token string) {
+	resp, err := h.authClient.ListAccessTokens(r.Context(), &proto.ListAccessTokensRequest{Token: token})
+	if err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	tokens := make([]AccessTokenResponse, 0, len(resp.Tokens))
+	for _, info := range resp.Tokens {
+		tokens = append(tokens, toAccessTokenResponse(info))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tokens); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
+
+func (h *TokenHandler) handleCreateToken(w http.ResponseWriter, r *http.Request, token string) {
+	var req CreateAccessTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.authClient.CreateAccessToken(r.Context(), &proto.CreateAccessTokenRequest{
+		Token:         token,
+		Name:          req.Name,
+		Scopes:        req.Scopes,
+		ExpiresInDays: req.ExpiresInDays,
+	})
+	if err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(struct {
+		Token string `json:"token"`
+		AccessTokenResponse
+	}{
+		Token:               resp.AccessToken,
+		AccessTokenResponse: toAccessTokenResponse(resp.Info),
+	}); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
+
+func (h *TokenHandler) handleRevokeToken(w http.ResponseWriter, r *http.Request, token, idStr string) {
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.authClient.RevokeAccessToken(r.Context(), &proto.RevokeAccessTokenRequest{Token: token, Id: id}); err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func toAccessTokenResponse(info *proto.AccessTokenInfo) AccessTokenResponse {
+	resp := AccessTokenResponse{
+		ID:        info.Id,
+		Name:      info.Name,
+		Prefix:    info.Prefix,
+		Scopes:    info.Scopes,
+		CreatedAt: time.Unix(info.CreatedAt, 0).UTC(),
+	}
+	if info.ExpiresAt != 0 {
+		expiresAt := time.Unix(info.ExpiresAt, 0).UTC()
+		resp.ExpiresAt = &expiresAt
+	}
+	if info.LastUsedAt != 0 {
+		lastUsedAt := time.Unix(info.LastUsedAt, 0).UTC()
+		resp.LastUsedAt = &lastUsedAt
+	}
+	return resp
+}
@@ -0,0 +1,312 @@
+package forum
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/Ryan-Gosusluging/forum/pkg/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	recentActivityLimit = 10
+	activityExcerptLen  = 200
+)
+
+// UserResponse is the profile of a user as returned by /api/me and, with
+// activity added, by /api/users/{username}
+type UserResponse struct {
+	UserID      int64  `json:"user_id"`
+	Username    string `json:"username"`
+	Role        string `json:"role"`
+	DisplayName string `json:"display_name"`
+	Bio         string `json:"bio"`
+	Location    string `json:"location"`
+	Website     string `json:"website"`
+	Signature   string `json:"signature"`
+	AvatarURL   string `json:"avatar_url"`
+	JoinedAt    string `json:"joined_at"`
+}
+
+type ProfileResponse struct {
+	UserResponse
+	PostCount      int64          `json:"post_count"`
+	CommentCount   int64          `json:"comment_count"`
+	RecentActivity []ActivityItem `json:"recent_activity"`
+}
+
+type ActivityItem struct {
+	Type      string `json:"type"`
+	ID        int64  `json:"id"`
+	PostID    int64  `json:"post_id"`
+	Title     string `json:"title,omitempty"`
+	Excerpt   string `json:"excerpt"`
+	CreatedAt string `json:"created_at"`
+
+	createdAt time.Time
+}
+
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name"`
+	Bio         *string `json:"bio"`
+	Location    *string `json:"location"`
+	Website     *string `json:"website"`
+	Signature   *string `json:"signature"`
+	AvatarURL   *string `json:"avatar_url"`
+}
+
+// UserHandler serves public profile pages at /api/users/{username}
+type UserHandler struct {
+	authClient  proto.AuthServiceClient
+	postRepo    *storage.PostRepository
+	commentRepo storage.CommentRepository
+}
+
+func NewUserHandler(authClient proto.AuthServiceClient, postRepo *storage.PostRepository, commentRepo storage.CommentRepository) *UserHandler {
+	return &UserHandler{
+		authClient:  authClient,
+		postRepo:    postRepo,
+		commentRepo: commentRepo,
+	}
+}
+
+func (h *UserHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/users/"), "/")
+	if username == "" || strings.Contains(username, "/") {
+		http.Error(w, "Invalid username", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	resp, err := h.authClient.GetUser(ctx, &proto.GetUserRequest{Username: username})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		logger.Error().Err(err).Str("username", username).Msg("Failed to get user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	profile := resp.User
+	response := ProfileResponse{UserResponse: toUserResponse(profile)}
+
+	if response.PostCount, err = h.postRepo.CountPostsByUserID(ctx, profile.UserId); err != nil {
+		logger.Error().Err(err).Msg("Failed to count posts")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if response.CommentCount, err = h.commentRepo.CountCommentsByUserID(ctx, profile.UserId); err != nil {
+		logger.Error().Err(err).Msg("Failed to count comments")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	if response.RecentActivity, err = h.recentActivity(r, profile.UserId); err != nil {
+		logger.Error().Err(err).Msg("Failed to get recent activity")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
+
+func toUserResponse(profile *proto.UserProfile) UserResponse {
+	return UserResponse{
+		UserID:      profile.UserId,
+		Username:    profile.Username,
+		Role:        profile.Role,
+		DisplayName: profile.DisplayName,
+		Bio:         profile.Bio,
+		Location:    profile.Location,
+		Website:     profile.Website,
+		Signature:   profile.Signature,
+		AvatarURL:   profile.AvatarUrl,
+		JoinedAt:    time.Unix(profile.CreatedAt, 0).UTC().Format(time.RFC3339),
+	}
+}
+
+// recentActivity merges the latest posts and comments of a user, newest first
+func (h *UserHandler) recentActivity(r *http.Request, userID int64) ([]ActivityItem, error) {
+	ctx := r.Context()
+
+	posts, err := h.postRepo.GetPostsByUserID(ctx, userID, recentActivityLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	comments, err := h.commentRepo.GetCommentsByUserID(ctx, userID, recentActivityLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]ActivityItem, 0, len(posts)+len(comments))
+	for _, post := range posts {
+		items = append(items, ActivityItem{
+			Type:      "post",
+			ID:        post.ID,
+			PostID:    post.ID,
+			Title:     post.Title,
+			Excerpt:   excerpt(post.Content),
+			createdAt: post.CreatedAt,
+		})
+	}
+	for _, comment := range comments {
+		items = append(items, ActivityItem{
+			Type:      "comment",
+			ID:        comment.ID,
+			PostID:    comment.PostID,
+			Excerpt:   excerpt(comment.Content),
+			createdAt: comment.CreatedAt,
+		})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].createdAt.After(items[j].createdAt)
+	})
+	if len(items) > recentActivityLimit {
+		items = items[:recentActivityLimit]
+	}
+	for i := range items {
+		items[i].CreatedAt = items[i].createdAt.Format(time.RFC3339)
+	}
+
+	return items, nil
+}
+
+func excerpt(content string) string {
+	runes := []rune(content)
+	if len(runes) <= activityExcerptLen {
+		return content
+	}
+	return string(runes[:activityExcerptLen]) + "…"
+}
+
+// MeHandler serves the authenticated user's own profile at /api/me
+type MeHandler struct {
+	authClient proto.AuthServiceClient
+}
+
+func NewMeHandler(authClient proto.AuthServiceClient) *MeHandler {
+	return &MeHandler{
+		authClient: authClient,
+	}
+}
+
+func (h *MeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.handleGetMe(w, r)
+	case http.MethodPatch:
+		h.handleUpdateMe(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *MeHandler) handleGetMe(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	claims, err := h.authClient.ValidateToken(ctx, &proto.ValidateTokenRequest{Token: auth.TokenFromRequest(r)})
+	if err != nil || !claims.Valid {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if !auth.HasScope(claims, auth.ScopeProfileRead) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	resp, err := h.authClient.GetUser(ctx, &proto.GetUserRequest{UserId: claims.UserId})
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", claims.UserId).Msg("Failed to get user")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(toUserResponse(resp.User)); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
+
+func (h *MeHandler) handleUpdateMe(w http.ResponseWriter, r *http.Request) {
+	token := auth.TokenFromRequest(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.authClient.UpdateProfile(r.Context(), &proto.UpdateProfileRequest{
+		Token:       token,
+		DisplayName: req.DisplayName,
+		Bio:         req.Bio,
+		Location:    req.Location,
+		Website:     req.Website,
+		Signature:   req.Signature,
+		AvatarUrl:   req.AvatarURL,
+	})
+	if err != nil {
+		writeRPCError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(toUserResponse(resp.User)); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
+
+// writeRPCError maps an auth service error to the matching HTTP status
+func writeRPCError(w http.ResponseWriter, err error) {
+	st, _ := status.FromError(err)
+	switch st.Code() {
+	case codes.InvalidArgument:
+		http.Error(w, st.Message(), http.StatusBadRequest)
+	case codes.Unauthenticated:
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	case codes.PermissionDenied:
+		http.Error(w, "Forbidden", http.StatusForbidden)
+	case codes.NotFound:
+		http.Error(w, st.Message(), http.StatusNotFound)
+	case codes.AlreadyExists, codes.FailedPrecondition:
+		http.Error(w, st.Message(), http.StatusConflict)
+	case codes.ResourceExhausted:
+		for _, detail := range st.Details() {
+			if info, ok := detail.(*errdetails.RetryInfo); ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(info.RetryDelay.AsDuration().Seconds()))))
+			}
+		}
+		http.Error(w, st.Message(), http.StatusTooManyRequests)
+	case codes.Unavailable:
+		http.Error(w, st.Message(), http.StatusServiceUnavailable)
+	default:
+		logger.Error().Err(err).Msg("Auth service call failed")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
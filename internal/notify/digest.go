@@ -0,0 +1,144 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/config"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/Ryan-Gosusluging/forum/pkg/mailer"
+)
+
+// Digest batches the new comments in followed posts into one email per user
+// and period
+type Digest struct {
+	repo   *storage.SubscriptionRepository
+	mailer mailer.Mailer
+	signer *UnsubscribeSigner
+	cfg    *config.Config
+}
+
+func NewDigest(repo *storage.SubscriptionRepository, mailer mailer.Mailer, signer *UnsubscribeSigner, cfg *config.Config) *Digest {
+	return &Digest{
+		repo:   repo,
+		mailer: mailer,
+		signer: signer,
+		cfg:    cfg,
+	}
+}
+
+// Run sends the due digests every DigestInterval until ctx is done
+func (d *Digest) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.DigestInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.sendDueDigests(ctx)
+		}
+	}
+}
+
+// sendDueDigests sends the digests that are due, DigestBatchSize users at a time
+func (d *Digest) sendDueDigests(ctx context.Context) {
+	now := time.Now()
+	var afterID int64
+	sent := 0
+	for ctx.Err() == nil {
+		recipients, err := d.repo.GetDueDigests(ctx, now, afterID, d.cfg.DigestBatchSize)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to list due digests")
+			return
+		}
+
+		for i := range recipients {
+			r := &recipients[i]
+			ok, err := d.sendDigest(ctx, r, now)
+			if err != nil {
+				logger.Error().Err(err).Int64("user_id", r.UserID).Msg("Failed to send digest")
+			}
+			if ok {
+				sent++
+			}
+			afterID = r.UserID
+		}
+
+		if len(recipients) < d.cfg.DigestBatchSize {
+			break
+		}
+	}
+
+	if sent > 0 {
+		logger.Info().Int("sent", sent).Msg("Sent digest emails")
+	}
+}
+
+// sendDigest claims the digest of the user up to until and mails the activity
+// since the previous one. A digest is sent at most once: a failed email is
+// not retried, the next digest covers the activity after it.
+func (d *Digest) sendDigest(ctx context.Context, r *storage.DigestRecipient, until time.Time) (bool, error) {
+	since := until.Add(-digestPeriod(r.Frequency))
+	if r.DigestedUntil != nil {
+		since = *r.DigestedUntil
+	}
+
+	posts, err := d.repo.GetDigestActivity(ctx, r.UserID, since, until)
+	if err != nil {
+		return false, err
+	}
+
+	claimed, err := d.repo.ClaimDigest(ctx, r.UserID, r.DigestedUntil, until)
+	if err != nil || !claimed || len(posts) == 0 {
+		return false, err
+	}
+
+	err = d.mailer.Send(ctx, mailer.Message{
+		To:      r.Email,
+		Subject: digestSubject(r.Frequency, posts),
+		Body:    d.digestBody(r, posts),
+		Headers: map[string]string{
+			"List-Unsubscribe":      "<" + d.signer.Link(r.UserID, 0) + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *Digest) digestBody(r *storage.DigestRecipient, posts []storage.DigestPost) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi %s,\n\nThere are new comments in the threads you follow:\n", r.Username)
+	for _, p := range posts {
+		comments := "1 new comment"
+		if p.Comments > 1 {
+			comments = fmt.Sprintf("%d new comments", p.Comments)
+		}
+		fmt.Fprintf(&b, "\n%s (%s)\n%s/posts/%d\nUnfollow: %s\n",
+			p.Title, comments, d.cfg.PublicBaseURL, p.PostID, d.signer.Link(r.UserID, p.PostID))
+	}
+	fmt.Fprintf(&b, "\nYou get this email %s. To stop digest emails, open:\n%s\n",
+		r.Frequency, d.signer.Link(r.UserID, 0))
+	return b.String()
+}
+
+func digestSubject(frequency string, posts []storage.DigestPost) string {
+	if len(posts) == 1 {
+		return fmt.Sprintf("Your %s digest: new comments in %q", frequency, posts[0].Title)
+	}
+	return fmt.Sprintf("Your %s digest: new comments in %d threads", frequency, len(posts))
+}
+
+func digestPeriod(frequency string) time.Duration {
+	if frequency == storage.DigestWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
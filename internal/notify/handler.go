@@ -0,0 +1,307 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+type NotificationResponse struct {
+	ID            int64   `json:"id"`
+	Type          string  `json:"type"`
+	ActorID       *int64  `json:"actor_id,omitempty"`
+	ActorUsername *string `json:"actor_username,omitempty"`
+	PostID        *int64  `json:"post_id,omitempty"`
+	CommentID     *int64  `json:"comment_id,omitempty"`
+	RoomID        *int64  `json:"room_id,omitempty"`
+	MessageID     *int64  `json:"message_id,omitempty"`
+	Excerpt       string  `json:"excerpt"`
+	Read          bool    `json:"read"`
+	CreatedAt     string  `json:"created_at"`
+}
+
+type UnreadResponse struct {
+	Count int `json:"count"`
+}
+
+type DigestSettings struct {
+	// Frequency is off, daily or weekly
+	Frequency string `json:"frequency"`
+}
+
+type MarkReadRequest struct {
+	// IDs of the notifications to mark, all of them if empty
+	IDs []int64 `json:"ids"`
+}
+
+// Handler serves the notification center and the digest settings under
+// /api/notifications
+type Handler struct {
+	repo    *storage.NotificationRepository
+	subRepo *storage.SubscriptionRepository
+	auth    *auth.Service
+}
+
+func NewHandler(repo *storage.NotificationRepository, subRepo *storage.SubscriptionRepository, auth *auth.Service) *Handler {
+	return &Handler{
+		repo:    repo,
+		subRepo: subRepo,
+		auth:    auth,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/api/notifications" && r.Method == http.MethodGet:
+		h.handleListNotifications(w, r)
+	case r.URL.Path == "/api/notifications/unread" && r.Method == http.MethodGet:
+		h.handleCountUnread(w, r)
+	case r.URL.Path == "/api/notifications/read" && r.Method == http.MethodPost:
+		h.handleMarkRead(w, r)
+	case r.URL.Path == "/api/notifications/preferences" && r.Method == http.MethodGet:
+		h.handleGetPreferences(w, r)
+	case r.URL.Path == "/api/notifications/preferences" && r.Method == http.MethodPut:
+		h.handleSetPreferences(w, r)
+	case r.URL.Path == "/api/notifications/digest" && r.Method == http.MethodGet:
+		h.handleGetDigest(w, r)
+	case r.URL.Path == "/api/notifications/digest" && r.Method == http.MethodPut:
+		h.handleSetDigest(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleListNotifications pages through the notifications of the user, newest
+// first. The before parameter is the ID of the last notification of the
+// previous page.
+func (h *Handler) handleListNotifications(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireUser(w, r, auth.ScopeProfileRead)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultPageSize
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		if limit > maxPageSize {
+			limit = maxPageSize
+		}
+	}
+
+	var before int64
+	if beforeStr := query.Get("before"); beforeStr != "" {
+		var err error
+		before, err = strconv.ParseInt(beforeStr, 10, 64)
+		if err != nil || before <= 0 {
+			http.Error(w, "Invalid before parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	notifications, err := h.repo.GetNotifications(r.Context(), userID, before, query.Get("unread") == "true", limit)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to get notifications")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]NotificationResponse, len(notifications))
+	for i := range notifications {
+		response[i] = ToNotificationResponse(&notifications[i])
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) handleCountUnread(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireUser(w, r, auth.ScopeProfileRead)
+	if !ok {
+		return
+	}
+
+	count, err := h.repo.CountUnread(r.Context(), userID)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to count unread notifications")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, UnreadResponse{Count: count})
+}
+
+func (h *Handler) handleMarkRead(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireUser(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+
+	var req MarkReadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := h.repo.MarkRead(r.Context(), userID, req.IDs); err != nil {
+		logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to mark notifications read")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleGetPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireUser(w, r, auth.ScopeProfileRead)
+	if !ok {
+		return
+	}
+
+	preferences, err := h.repo.GetPreferences(r.Context(), userID)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to get notification preferences")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, preferences)
+}
+
+// handleSetPreferences turns notification types on or off. The body maps
+// types to whether they are enabled; types not in it are left unchanged.
+func (h *Handler) handleSetPreferences(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireUser(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+
+	var req map[string]bool
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	for t := range req {
+		if !knownType(t) {
+			http.Error(w, "Unknown notification type: "+t, http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx := r.Context()
+	for t, enabled := range req {
+		if err := h.repo.SetPreference(ctx, userID, t, enabled); err != nil {
+			logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to set notification preference")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	preferences, err := h.repo.GetPreferences(ctx, userID)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to get notification preferences")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, preferences)
+}
+
+func (h *Handler) handleGetDigest(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireUser(w, r, auth.ScopeProfileRead)
+	if !ok {
+		return
+	}
+
+	frequency, err := h.subRepo.GetDigestFrequency(r.Context(), userID)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to get digest frequency")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, DigestSettings{Frequency: frequency})
+}
+
+func (h *Handler) handleSetDigest(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireUser(w, r, auth.ScopeProfileWrite)
+	if !ok {
+		return
+	}
+
+	var req DigestSettings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	switch req.Frequency {
+	case storage.DigestOff, storage.DigestDaily, storage.DigestWeekly:
+	default:
+		http.Error(w, "Frequency must be off, daily or weekly", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.subRepo.SetDigestFrequency(r.Context(), userID, req.Frequency); err != nil {
+		logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to set digest frequency")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, req)
+}
+
+func (h *Handler) requireUser(w http.ResponseWriter, r *http.Request, scope string) (int64, bool) {
+	claims, err := h.auth.Authenticate(r, scope)
+	if err != nil {
+		if errors.Is(err, auth.ErrMissingScope) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		} else {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
+		return 0, false
+	}
+	return claims.UserId, true
+}
+
+// ToNotificationResponse is the format of notifications in responses and
+// websocket frames
+func ToNotificationResponse(n *storage.Notification) NotificationResponse {
+	return NotificationResponse{
+		ID:            n.ID,
+		Type:          n.Type,
+		ActorID:       n.ActorID,
+		ActorUsername: n.ActorUsername,
+		PostID:        n.PostID,
+		CommentID:     n.CommentID,
+		RoomID:        n.RoomID,
+		MessageID:     n.MessageID,
+		Excerpt:       n.Excerpt,
+		Read:          n.ReadAt != nil,
+		CreatedAt:     n.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func knownType(t string) bool {
+	for _, known := range storage.NotificationTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
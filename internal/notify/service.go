@@ -0,0 +1,194 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/Ryan-Gosusluging/forum/internal/events"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+const (
+	// maxMentions limits the users notified by one comment or message
+	maxMentions = 10
+	// maxExcerptLength shortens the content shown with a notification
+	maxExcerptLength = 140
+)
+
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@([\w.-]+)`)
+
+// Pusher delivers new notifications to the connected clients of their user.
+// The chat hub implements it.
+type Pusher interface {
+	PushNotification(n *storage.Notification)
+}
+
+// Service creates the notifications of new comments and chat messages.
+// Comment notifications are created from comment.created events and retried
+// with them; chat mentions are best effort and failures are only logged.
+// Neither ever fails the content that triggered them.
+type Service struct {
+	repo        *storage.NotificationRepository
+	userRepo    *storage.UserRepository
+	postRepo    *storage.PostRepository
+	commentRepo storage.CommentRepository
+	blockRepo   *storage.BlockRepository
+	pusher      Pusher
+}
+
+func NewService(repo *storage.NotificationRepository, userRepo *storage.UserRepository, postRepo *storage.PostRepository, commentRepo storage.CommentRepository, blockRepo *storage.BlockRepository) *Service {
+	return &Service{
+		repo:        repo,
+		userRepo:    userRepo,
+		postRepo:    postRepo,
+		commentRepo: commentRepo,
+		blockRepo:   blockRepo,
+	}
+}
+
+// SetPusher sets where new notifications are pushed live. The chat hub is
+// created after the service, as it notifies mentions itself.
+func (s *Service) SetPusher(pusher Pusher) {
+	s.pusher = pusher
+}
+
+// HandleCommentCreated is the comment.created subscriber
+func (s *Service) HandleCommentCreated(ctx context.Context, e *events.Event) error {
+	var data events.CommentCreatedData
+	if err := e.Decode(&data); err != nil {
+		return err
+	}
+
+	return s.commentCreated(ctx, &storage.Comment{
+		ID:        data.ID,
+		PostID:    data.PostID,
+		ParentID:  data.ParentID,
+		Content:   data.Content,
+		UserID:    data.UserID,
+		CreatedAt: data.CreatedAt,
+	})
+}
+
+// commentCreated notifies the author of the post, the author of the replied
+// to comment and the mentioned users. Each user gets one notification, the
+// most specific one, also when the comment is handled again after an error.
+func (s *Service) commentCreated(ctx context.Context, comment *storage.Comment) error {
+	var errs []error
+	notified := map[int64]bool{comment.UserID: true}
+	base := storage.Notification{
+		ActorID:   &comment.UserID,
+		PostID:    &comment.PostID,
+		CommentID: &comment.ID,
+		Excerpt:   excerpt(comment.Content),
+	}
+
+	if comment.ParentID != nil {
+		parent, err := s.commentRepo.GetCommentByID(ctx, *comment.ParentID)
+		if err != nil {
+			errs = append(errs, err)
+		} else if parent != nil && !notified[parent.UserID] {
+			notified[parent.UserID] = true
+			errs = append(errs, s.notify(ctx, parent.UserID, storage.NotificationCommentReply, base))
+		}
+	}
+
+	for _, userID := range s.mentionedUsers(ctx, comment.Content) {
+		if !notified[userID] {
+			notified[userID] = true
+			errs = append(errs, s.notify(ctx, userID, storage.NotificationMention, base))
+		}
+	}
+
+	// The repository reports unknown posts as errors
+	post, err := s.postRepo.GetPostByID(ctx, comment.PostID)
+	if err == nil && !notified[post.UserID] {
+		errs = append(errs, s.notify(ctx, post.UserID, storage.NotificationPostReply, base))
+	}
+
+	return errors.Join(errs...)
+}
+
+// ChatMessageCreated notifies the users mentioned in a room message that can
+// read the room
+func (s *Service) ChatMessageCreated(ctx context.Context, msg *storage.ChatMessage, canRead func(userID int64) bool) {
+	base := storage.Notification{
+		ActorID:   &msg.UserID,
+		RoomID:    &msg.RoomID,
+		MessageID: &msg.ID,
+		Excerpt:   excerpt(msg.Content),
+	}
+
+	for _, userID := range s.mentionedUsers(ctx, msg.Content) {
+		if userID != msg.UserID && canRead(userID) {
+			if err := s.notify(ctx, userID, storage.NotificationMention, base); err != nil {
+				logger.Error().Err(err).Int64("user_id", userID).Int64("message_id", msg.ID).Msg("Failed to notify mention")
+			}
+		}
+	}
+}
+
+// notify stores a notification for the user and pushes it, unless the user
+// blocked the actor or turned the type off
+func (s *Service) notify(ctx context.Context, userID int64, notificationType string, n storage.Notification) error {
+	blocked, err := s.blockRepo.IsBlockedByAny(ctx, *n.ActorID, []int64{userID})
+	if err != nil || blocked {
+		return err
+	}
+
+	n.UserID = userID
+	n.Type = notificationType
+	created, err := s.repo.CreateNotification(ctx, &n)
+	if err != nil {
+		return err
+	}
+	if created != nil && s.pusher != nil {
+		s.pusher.PushNotification(created)
+	}
+	return nil
+}
+
+// mentionedUsers returns the IDs of the existing users @mentioned in the content
+func (s *Service) mentionedUsers(ctx context.Context, content string) []int64 {
+	var userIDs []int64
+	seen := make(map[string]bool)
+	for _, username := range mentions(content) {
+		if seen[username] {
+			continue
+		}
+		if len(seen) == maxMentions {
+			break
+		}
+		seen[username] = true
+
+		// The repository reports unknown users as errors
+		user, err := s.userRepo.GetUserByUsername(ctx, username)
+		if err != nil {
+			continue
+		}
+		userIDs = append(userIDs, user.ID)
+	}
+	return userIDs
+}
+
+// mentions returns the usernames @mentioned in the content, in order.
+// Trailing dots and dashes are taken as punctuation.
+func mentions(content string) []string {
+	var usernames []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		if username := strings.TrimRight(match[1], ".-"); username != "" {
+			usernames = append(usernames, username)
+		}
+	}
+	return usernames
+}
+
+func excerpt(content string) string {
+	content = strings.TrimSpace(content)
+	if runes := []rune(content); len(runes) > maxExcerptLength {
+		return string(runes[:maxExcerptLength]) + "…"
+	}
+	return content
+}
@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+)
+
+// UnsubscribePath is where the links in digest emails point
+const UnsubscribePath = "/api/unsubscribe"
+
+// UnsubscribeSigner issues and verifies the one-click unsubscribe links of
+// digest emails. Links do not expire, so that old emails keep working.
+type UnsubscribeSigner struct {
+	secret  []byte
+	baseURL string
+}
+
+func NewUnsubscribeSigner(secret, baseURL string) *UnsubscribeSigner {
+	return &UnsubscribeSigner{secret: []byte(secret), baseURL: baseURL}
+}
+
+// Link returns the URL that unfollows the post, or turns digests off if
+// postID is zero
+func (s *UnsubscribeSigner) Link(userID, postID int64) string {
+	query := url.Values{}
+	query.Set("user", strconv.FormatInt(userID, 10))
+	if postID != 0 {
+		query.Set("post", strconv.FormatInt(postID, 10))
+	}
+	query.Set("sig", s.signature(userID, postID))
+
+	return s.baseURL + UnsubscribePath + "?" + query.Encode()
+}
+
+// Verify checks that the signature was issued for the user and post
+func (s *UnsubscribeSigner) Verify(userID, postID int64, sig string) bool {
+	expected := s.signature(userID, postID)
+	return hmac.Equal([]byte(expected), []byte(sig))
+}
+
+func (s *UnsubscribeSigner) signature(userID, postID int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte("unsubscribe"))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(userID, 10)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(postID, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
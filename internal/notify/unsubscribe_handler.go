@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+// UnsubscribeHandler serves the signed links of digest emails. Mail clients
+// send a POST for one-click unsubscribe (RFC 8058), people following the link
+// a GET; both unsubscribe without a login.
+type UnsubscribeHandler struct {
+	repo   *storage.SubscriptionRepository
+	signer *UnsubscribeSigner
+}
+
+func NewUnsubscribeHandler(repo *storage.SubscriptionRepository, signer *UnsubscribeSigner) *UnsubscribeHandler {
+	return &UnsubscribeHandler{
+		repo:   repo,
+		signer: signer,
+	}
+}
+
+func (h *UnsubscribeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	userID, err := strconv.ParseInt(query.Get("user"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid unsubscribe link", http.StatusBadRequest)
+		return
+	}
+	var postID int64
+	if postStr := query.Get("post"); postStr != "" {
+		if postID, err = strconv.ParseInt(postStr, 10, 64); err != nil || postID <= 0 {
+			http.Error(w, "Invalid unsubscribe link", http.StatusBadRequest)
+			return
+		}
+	}
+	if !h.signer.Verify(userID, postID, query.Get("sig")) {
+		http.Error(w, "Invalid unsubscribe link", http.StatusForbidden)
+		return
+	}
+
+	ctx := r.Context()
+	message := "You will no longer receive digest emails."
+	if postID != 0 {
+		_, err = h.repo.Unsubscribe(ctx, userID, postID)
+		message = "You no longer follow this thread."
+	} else {
+		err = h.repo.SetDigestFrequency(ctx, userID, storage.DigestOff)
+	}
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to unsubscribe")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info().Int64("user_id", userID).Int64("post_id", postID).Msg("Unsubscribed through email link")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(message + "\n"))
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// AccessToken is a personal API token. Only the hash of the secret is stored;
+// the prefix identifies the token to its owner.
+type AccessToken struct {
+	ID          int64
+	UserID      int64
+	Name        string
+	TokenPrefix string
+	Scopes      []string
+	ExpiresAt   *time.Time
+	LastUsedAt  *time.Time
+	RevokedAt   *time.Time
+	CreatedAt   time.Time
+}
+
+// AccessTokenOwner is an active token together with the user it acts for
+type AccessTokenOwner struct {
+	AccessToken
+	Username string
+	Role     string
+}
+
+const accessTokenColumns = `id, user_id, name, token_prefix, scopes, expires_at, last_used_at, revoked_at, created_at`
+
+type AccessTokenRepository struct {
+	db *DB
+}
+
+func NewAccessTokenRepository(db *DB) *AccessTokenRepository {
+	return &AccessTokenRepository{db: db}
+}
+
+func scanAccessToken(row rowScanner, dest ...interface{}) (*AccessToken, error) {
+	t := &AccessToken{}
+	err := row.Scan(append([]interface{}{
+		&t.ID, &t.UserID, &t.Name, &t.TokenPrefix, pq.Array(&t.Scopes),
+		&t.ExpiresAt, &t.LastUsedAt, &t.RevokedAt, &t.CreatedAt,
+	}, dest...)...)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (r *AccessTokenRepository) CreateAccessToken(ctx context.Context, userID int64, name, prefix, tokenHash string, scopes []string, expiresAt *time.Time) (*AccessToken, error) {
+	query := `
+		INSERT INTO personal_access_tokens (user_id, name, token_prefix, token_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + accessTokenColumns
+
+	return scanAccessToken(r.db.QueryRowContext(ctx, query, userID, name, prefix, tokenHash, pq.Array(scopes), expiresAt))
+}
+
+// GetActiveAccessToken returns the unrevoked, unexpired token with the hash
+// and its owner, or nil if there is none
+func (r *AccessTokenRepository) GetActiveAccessToken(ctx context.Context, tokenHash string) (*AccessTokenOwner, error) {
+	query := `
+		SELECT t.id, t.user_id, t.name, t.token_prefix, t.scopes, t.expires_at, t.last_used_at, t.revoked_at, t.created_at,
+			u.username, u.role
+		FROM personal_access_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1
+			AND t.revoked_at IS NULL
+			AND (t.expires_at IS NULL OR t.expires_at > NOW())
+	`
+
+	owner := &AccessTokenOwner{}
+	token, err := scanAccessToken(r.db.QueryRowContext(ctx, query, tokenHash), &owner.Username, &owner.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	owner.AccessToken = *token
+	return owner, nil
+}
+
+// GetAccessTokensByUserID lists the unrevoked tokens of the user, expired ones included
+func (r *AccessTokenRepository) GetAccessTokensByUserID(ctx context.Context, userID int64) ([]AccessToken, error) {
+	query := `
+		SELECT ` + accessTokenColumns + `
+		FROM personal_access_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []AccessToken
+	for rows.Next() {
+		token, err := scanAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, *token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// CountActiveAccessTokens returns the number of usable tokens of the user
+func (r *AccessTokenRepository) CountActiveAccessTokens(ctx context.Context, userID int64) (int, error) {
+	query := `
+		SELECT COUNT(*) FROM personal_access_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	`
+
+	var count int
+	if err := r.db.QueryRowContext(ctx, query, userID).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// RevokeAccessToken revokes a token of the user and reports whether it existed
+func (r *AccessTokenRepository) RevokeAccessToken(ctx context.Context, userID, id int64) (bool, error) {
+	query := `
+		UPDATE personal_access_tokens SET revoked_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`
+	result, err := r.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// TouchAccessToken records a use of the token. Writes are skipped while the
+// stored time is more recent than the given threshold, so busy tokens do not
+// cause a write on every request.
+func (r *AccessTokenRepository) TouchAccessToken(ctx context.Context, id int64, usedAt, skipAfter time.Time) error {
+	query := `
+		UPDATE personal_access_tokens SET last_used_at = $2
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $3)
+	`
+	_, err := r.db.ExecContext(ctx, query, id, usedAt, skipAfter)
+	return err
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	AttachmentPurposeFile   = "attachment"
+	AttachmentPurposeAvatar = "avatar"
+)
+
+// Attachment is an uploaded file linked to a post, a comment or a user avatar
+type Attachment struct {
+	ID           int64
+	UserID       int64
+	PostID       *int64
+	CommentID    *int64
+	Purpose      string
+	StorageKey   string
+	ThumbnailKey *string
+	OriginalName string
+	ContentType  string
+	SizeBytes    int64
+	Width        *int
+	Height       *int
+	CreatedAt    time.Time
+}
+
+const attachmentColumns = `id, user_id, post_id, comment_id, purpose, storage_key, thumbnail_key,
+		original_name, content_type, size_bytes, width, height, created_at`
+
+func scanAttachment(row rowScanner) (*Attachment, error) {
+	a := &Attachment{}
+	err := row.Scan(
+		&a.ID,
+		&a.UserID,
+		&a.PostID,
+		&a.CommentID,
+		&a.Purpose,
+		&a.StorageKey,
+		&a.ThumbnailKey,
+		&a.OriginalName,
+		&a.ContentType,
+		&a.SizeBytes,
+		&a.Width,
+		&a.Height,
+		&a.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+type AttachmentRepository struct {
+	db *DB
+}
+
+func NewAttachmentRepository(db *DB) *AttachmentRepository {
+	return &AttachmentRepository{db: db}
+}
+
+func (r *AttachmentRepository) CreateAttachment(ctx context.Context, a *Attachment) error {
+	query := `
+		INSERT INTO attachments (user_id, post_id, comment_id, purpose, storage_key, thumbnail_key,
+			original_name, content_type, size_bytes, width, height)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id, created_at
+	`
+
+	return r.db.QueryRowContext(ctx, query,
+		a.UserID,
+		a.PostID,
+		a.CommentID,
+		a.Purpose,
+		a.StorageKey,
+		a.ThumbnailKey,
+		a.OriginalName,
+		a.ContentType,
+		a.SizeBytes,
+		a.Width,
+		a.Height,
+	).Scan(&a.ID, &a.CreatedAt)
+}
+
+func (r *AttachmentRepository) GetAttachmentByID(ctx context.Context, id int64) (*Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE id = $1`
+
+	a, err := scanAttachment(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("attachment not found")
+		}
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (r *AttachmentRepository) GetAttachmentsByPostID(ctx context.Context, postID int64) ([]*Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE post_id = $1 ORDER BY id`
+	return r.list(ctx, query, postID)
+}
+
+func (r *AttachmentRepository) GetAttachmentsByCommentID(ctx context.Context, commentID int64) ([]*Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE comment_id = $1 ORDER BY id`
+	return r.list(ctx, query, commentID)
+}
+
+// GetAvatarsByUserID returns the avatar attachments of the user, oldest first
+func (r *AttachmentRepository) GetAvatarsByUserID(ctx context.Context, userID int64) ([]*Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE user_id = $1 AND purpose = $2 ORDER BY id`
+	return r.list(ctx, query, userID, AttachmentPurposeAvatar)
+}
+
+func (r *AttachmentRepository) DeleteAttachment(ctx context.Context, id int64) error {
+	query := `DELETE FROM attachments WHERE id = $1`
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("attachment not found")
+	}
+
+	return nil
+}
+
+func (r *AttachmentRepository) list(ctx context.Context, query string, args ...interface{}) ([]*Attachment, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []*Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attachments, nil
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Audit actions
+const (
+	AuditActionChatMessageRemoved = "chat_message.removed"
+	AuditActionChatUserMuted      = "chat_user.muted"
+	AuditActionChatUserKicked     = "chat_user.kicked"
+	AuditActionChatUserBanned     = "chat_user.banned"
+	AuditActionChatUserUnmuted    = "chat_user.unmuted"
+	AuditActionChatUserUnbanned   = "chat_user.unbanned"
+)
+
+// Audit target types
+const (
+	AuditTargetChatMessage = "chat_message"
+	AuditTargetUser        = "user"
+)
+
+// AuditEntry records an action taken by a user on behalf of others, such as
+// a moderator removing a message
+type AuditEntry struct {
+	ID         int64
+	ActorID    *int64
+	Action     string
+	TargetType string
+	TargetID   int64
+	Details    map[string]interface{}
+	CreatedAt  time.Time
+}
+
+type AuditRepository struct {
+	db *DB
+}
+
+func NewAuditRepository(db *DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+func (r *AuditRepository) CreateEntry(ctx context.Context, entry *AuditEntry) error {
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return err
+	}
+	if entry.Details == nil {
+		details = []byte("{}")
+	}
+
+	query := `
+		INSERT INTO audit_logs (actor_id, action, target_type, target_id, details)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at
+	`
+
+	return r.db.QueryRowContext(ctx, query, entry.ActorID, entry.Action, entry.TargetType, entry.TargetID, details).
+		Scan(&entry.ID, &entry.CreatedAt)
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// BlockedUser is a user that another user has blocked from messaging them
+type BlockedUser struct {
+	UserID    int64
+	Username  string
+	CreatedAt time.Time
+}
+
+type BlockRepository struct {
+	db *DB
+}
+
+func NewBlockRepository(db *DB) *BlockRepository {
+	return &BlockRepository{db: db}
+}
+
+// BlockUser blocks a user. Blocking twice is not an error.
+func (r *BlockRepository) BlockUser(ctx context.Context, blockerID, blockedID int64) error {
+	query := `
+		INSERT INTO user_blocks (blocker_id, blocked_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+	_, err := r.db.ExecContext(ctx, query, blockerID, blockedID)
+	return err
+}
+
+// UnblockUser removes a block and reports whether it existed
+func (r *BlockRepository) UnblockUser(ctx context.Context, blockerID, blockedID int64) (bool, error) {
+	query := `DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2`
+	result, err := r.db.ExecContext(ctx, query, blockerID, blockedID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func (r *BlockRepository) GetBlockedUsers(ctx context.Context, blockerID int64) ([]BlockedUser, error) {
+	query := `
+		SELECT b.blocked_id, u.username, b.created_at
+		FROM user_blocks b
+		JOIN users u ON u.id = b.blocked_id
+		WHERE b.blocker_id = $1
+		ORDER BY b.created_at DESC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, blockerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocked []BlockedUser
+	for rows.Next() {
+		var b BlockedUser
+		if err := rows.Scan(&b.UserID, &b.Username, &b.CreatedAt); err != nil {
+			return nil, err
+		}
+		blocked = append(blocked, b)
+	}
+
+	return blocked, rows.Err()
+}
+
+// IsBlockedByAny reports whether any of the users has blocked the sender
+func (r *BlockRepository) IsBlockedByAny(ctx context.Context, senderID int64, userIDs []int64) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM user_blocks
+			WHERE blocked_id = $1 AND blocker_id = ANY($2)
+		)
+	`
+
+	var blocked bool
+	if err := r.db.QueryRowContext(ctx, query, senderID, pq.Array(userIDs)).Scan(&blocked); err != nil {
+		return false, err
+	}
+
+	return blocked, nil
+}
@@ -2,16 +2,53 @@ package storage
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type ChatMessage struct {
 	ID        int64
-	UserID    *int64
+	RoomID    int64
+	UserID    int64
+	Username  string
 	Content   string
 	CreatedAt time.Time
+	EditedAt  *time.Time
+	DeletedAt *time.Time
+	DeletedBy *int64
+	ReplyToID *int64
+	// Quote is the message replied to, nil if it was purged
+	Quote    *MessageQuote
+	PinnedAt *time.Time
+}
+
+// MessageQuote is the part of a replied to message shown with the reply
+type MessageQuote struct {
+	UserID   int64
+	Username string
+	Content  string
+	Deleted  bool
 }
 
+// ReactionCount is the number of users that reacted to a message with an emoji
+type ReactionCount struct {
+	Emoji string
+	Count int
+}
+
+// chatMessageColumns and chatMessageJoins select a message m with its author
+// and the message it replies to
+const chatMessageColumns = `m.id, m.room_id, m.user_id, u.username, m.content, m.created_at,
+	m.edited_at, m.deleted_at, m.deleted_by, m.reply_to_id, m.pinned_at,
+	q.user_id AS quote_user_id, qu.username AS quote_username, q.content AS quote_content, q.deleted_at AS quote_deleted_at`
+
+const chatMessageJoins = `JOIN users u ON u.id = m.user_id
+		LEFT JOIN chat_messages q ON q.id = m.reply_to_id
+		LEFT JOIN users qu ON qu.id = q.user_id`
+
 type ChatRepository struct {
 	db *DB
 }
@@ -20,33 +57,95 @@ func NewChatRepository(db *DB) *ChatRepository {
 	return &ChatRepository{db: db}
 }
 
-func (r *ChatRepository) CreateMessage(ctx context.Context, userID *int64, content string) (*ChatMessage, error) {
+func scanChatMessage(row rowScanner) (*ChatMessage, error) {
+	message := &ChatMessage{}
+	var quoteUserID sql.NullInt64
+	var quoteUsername, quoteContent sql.NullString
+	var quoteDeletedAt *time.Time
+	err := row.Scan(&message.ID, &message.RoomID, &message.UserID, &message.Username, &message.Content, &message.CreatedAt,
+		&message.EditedAt, &message.DeletedAt, &message.DeletedBy, &message.ReplyToID, &message.PinnedAt,
+		&quoteUserID, &quoteUsername, &quoteContent, &quoteDeletedAt)
+	if err != nil {
+		return nil, err
+	}
+	if quoteUserID.Valid {
+		message.Quote = &MessageQuote{
+			UserID:   quoteUserID.Int64,
+			Username: quoteUsername.String,
+			Deleted:  quoteDeletedAt != nil,
+		}
+		// The content of deleted messages is kept for the audit log only
+		if !message.Quote.Deleted {
+			message.Quote.Content = quoteContent.String
+		}
+	}
+	return message, nil
+}
+
+// CreateMessage stores a message, replyToID is the message it replies to if set
+func (r *ChatRepository) CreateMessage(ctx context.Context, roomID, userID int64, content string, replyToID *int64) (*ChatMessage, error) {
 	query := `
-		INSERT INTO chat_messages (user_id, content)
-		VALUES ($1, $2)
-		RETURNING id, user_id, content, created_at
+		WITH inserted AS (
+			INSERT INTO chat_messages (room_id, user_id, content, reply_to_id)
+			VALUES ($1, $2, $3, $4)
+			RETURNING *
+		)
+		SELECT ` + chatMessageColumns + `
+		FROM inserted m
+		` + chatMessageJoins + `
 	`
 
-	message := &ChatMessage{}
-	err := r.db.QueryRowContext(ctx, query, userID, content).
-		Scan(&message.ID, &message.UserID, &message.Content, &message.CreatedAt)
+	return scanChatMessage(r.db.QueryRowContext(ctx, query, roomID, userID, content, replyToID))
+}
+
+func (r *ChatRepository) GetRecentMessages(ctx context.Context, roomID int64, limit int) ([]*ChatMessage, error) {
+	query := `
+		SELECT ` + chatMessageColumns + `
+		FROM chat_messages m
+		` + chatMessageJoins + `
+		WHERE m.room_id = $1 AND m.deleted_at IS NULL
+		ORDER BY m.created_at DESC
+		LIMIT $2
+	`
 
+	rows, err := r.db.QueryContext(ctx, query, roomID, limit)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return message, nil
+	var messages []*ChatMessage
+	for rows.Next() {
+		message, err := scanChatMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
 }
 
-func (r *ChatRepository) GetRecentMessages(ctx context.Context, limit int) ([]*ChatMessage, error) {
+// GetMessagesAfter returns the messages of the room with IDs above afterID in
+// ascending order. If there are more than limit, the newest ones are returned.
+func (r *ChatRepository) GetMessagesAfter(ctx context.Context, roomID, afterID int64, limit int) ([]*ChatMessage, error) {
 	query := `
-		SELECT id, user_id, content, created_at
-		FROM chat_messages
-		ORDER BY created_at DESC
-		LIMIT $1
+		SELECT * FROM (
+			SELECT ` + chatMessageColumns + `
+			FROM chat_messages m
+			` + chatMessageJoins + `
+			WHERE m.room_id = $1 AND m.id > $2 AND m.deleted_at IS NULL
+			ORDER BY m.id DESC
+			LIMIT $3
+		) recent
+		ORDER BY id
 	`
 
-	rows, err := r.db.QueryContext(ctx, query, limit)
+	rows, err := r.db.QueryContext(ctx, query, roomID, afterID, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -54,23 +153,335 @@ func (r *ChatRepository) GetRecentMessages(ctx context.Context, limit int) ([]*C
 
 	var messages []*ChatMessage
 	for rows.Next() {
-		message := &ChatMessage{}
-		err := rows.Scan(&message.ID, &message.UserID, &message.Content, &message.CreatedAt)
+		message, err := scanChatMessage(rows)
 		if err != nil {
 			return nil, err
 		}
 		messages = append(messages, message)
 	}
 
-	if err := rows.Err(); err != nil {
+	return messages, rows.Err()
+}
+
+// GetLastMessageTime returns when the user last sent a message to the room,
+// deleted ones included, or nil if they never did
+func (r *ChatRepository) GetLastMessageTime(ctx context.Context, roomID, userID int64) (*time.Time, error) {
+	query := `SELECT MAX(created_at) FROM chat_messages WHERE room_id = $1 AND user_id = $2`
+
+	var last *time.Time
+	if err := r.db.QueryRowContext(ctx, query, roomID, userID).Scan(&last); err != nil {
 		return nil, err
 	}
+	return last, nil
+}
 
-	return messages, nil
+// GetMessage returns a message that has not been deleted, or nil if there is
+// no such message
+func (r *ChatRepository) GetMessage(ctx context.Context, id int64) (*ChatMessage, error) {
+	query := `
+		SELECT ` + chatMessageColumns + `
+		FROM chat_messages m
+		` + chatMessageJoins + `
+		WHERE m.id = $1 AND m.deleted_at IS NULL
+	`
+
+	message, err := scanChatMessage(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// UpdateMessage replaces the content of a message written by the user after
+// editableSince. It returns nil if there is no such message.
+func (r *ChatRepository) UpdateMessage(ctx context.Context, id, userID int64, content string, editableSince time.Time) (*ChatMessage, error) {
+	query := `
+		WITH updated AS (
+			UPDATE chat_messages SET content = $3, edited_at = CURRENT_TIMESTAMP
+			WHERE id = $1 AND user_id = $2 AND created_at > $4 AND deleted_at IS NULL
+			RETURNING *
+		)
+		SELECT ` + chatMessageColumns + `
+		FROM updated m
+		` + chatMessageJoins + `
+	`
+
+	message, err := scanChatMessage(r.db.QueryRowContext(ctx, query, id, userID, content, editableSince))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// DeleteMessage marks a message as deleted by the given user and returns it
+// with its last content, or nil if there is no such message. Deleted
+// messages stay in the table for the audit log, but are no longer pinned.
+func (r *ChatRepository) DeleteMessage(ctx context.Context, id, deletedBy int64) (*ChatMessage, error) {
+	query := `
+		WITH deleted AS (
+			UPDATE chat_messages SET deleted_at = CURRENT_TIMESTAMP, deleted_by = $2, pinned_at = NULL, pinned_by = NULL
+			WHERE id = $1 AND deleted_at IS NULL
+			RETURNING *
+		)
+		SELECT ` + chatMessageColumns + `
+		FROM deleted m
+		` + chatMessageJoins + `
+	`
+
+	message, err := scanChatMessage(r.db.QueryRowContext(ctx, query, id, deletedBy))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// AddReaction adds the reaction of the user to a message and reports whether
+// it is new
+func (r *ChatRepository) AddReaction(ctx context.Context, messageID, userID int64, emoji string) (bool, error) {
+	query := `
+		INSERT INTO chat_message_reactions (message_id, user_id, emoji)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`
+	result, err := r.db.ExecContext(ctx, query, messageID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// RemoveReaction removes the reaction of the user and reports whether it existed
+func (r *ChatRepository) RemoveReaction(ctx context.Context, messageID, userID int64, emoji string) (bool, error) {
+	query := `DELETE FROM chat_message_reactions WHERE message_id = $1 AND user_id = $2 AND emoji = $3`
+	result, err := r.db.ExecContext(ctx, query, messageID, userID, emoji)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// CountUserReactions returns how many different reactions the user added to a message
+func (r *ChatRepository) CountUserReactions(ctx context.Context, messageID, userID int64) (int, error) {
+	query := `SELECT COUNT(*) FROM chat_message_reactions WHERE message_id = $1 AND user_id = $2`
+
+	var count int
+	if err := r.db.QueryRowContext(ctx, query, messageID, userID).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
 }
 
-func (r *ChatRepository) DeleteOldMessages(ctx context.Context, olderThan time.Time) error {
-	query := `DELETE FROM chat_messages WHERE created_at < $1`
-	_, err := r.db.ExecContext(ctx, query, olderThan)
-	return err
+// GetReactions returns the reaction counts of the messages, by message ID.
+// Emojis are ordered by their first use.
+func (r *ChatRepository) GetReactions(ctx context.Context, messageIDs []int64) (map[int64][]ReactionCount, error) {
+	reactions := make(map[int64][]ReactionCount)
+	if len(messageIDs) == 0 {
+		return reactions, nil
+	}
+
+	query := `
+		SELECT message_id, emoji, COUNT(*)
+		FROM chat_message_reactions
+		WHERE message_id = ANY($1)
+		GROUP BY message_id, emoji
+		ORDER BY message_id, MIN(created_at), emoji
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(messageIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var messageID int64
+		var rc ReactionCount
+		if err := rows.Scan(&messageID, &rc.Emoji, &rc.Count); err != nil {
+			return nil, err
+		}
+		reactions[messageID] = append(reactions[messageID], rc)
+	}
+
+	return reactions, rows.Err()
+}
+
+// PinMessage pins a message to its room. It returns nil if there is no such
+// message or it is pinned already.
+func (r *ChatRepository) PinMessage(ctx context.Context, id, pinnedBy int64) (*ChatMessage, error) {
+	query := `
+		WITH pinned AS (
+			UPDATE chat_messages SET pinned_at = CURRENT_TIMESTAMP, pinned_by = $2
+			WHERE id = $1 AND deleted_at IS NULL AND pinned_at IS NULL
+			RETURNING *
+		)
+		SELECT ` + chatMessageColumns + `
+		FROM pinned m
+		` + chatMessageJoins + `
+	`
+
+	message, err := scanChatMessage(r.db.QueryRowContext(ctx, query, id, pinnedBy))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// UnpinMessage unpins a message. It returns nil if there is no such pinned message.
+func (r *ChatRepository) UnpinMessage(ctx context.Context, id int64) (*ChatMessage, error) {
+	query := `
+		WITH unpinned AS (
+			UPDATE chat_messages SET pinned_at = NULL, pinned_by = NULL
+			WHERE id = $1 AND pinned_at IS NOT NULL
+			RETURNING *
+		)
+		SELECT ` + chatMessageColumns + `
+		FROM unpinned m
+		` + chatMessageJoins + `
+	`
+
+	message, err := scanChatMessage(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// GetPinnedMessages returns the pinned messages of the room, newest pin first
+func (r *ChatRepository) GetPinnedMessages(ctx context.Context, roomID int64) ([]*ChatMessage, error) {
+	query := `
+		SELECT ` + chatMessageColumns + `
+		FROM chat_messages m
+		` + chatMessageJoins + `
+		WHERE m.room_id = $1 AND m.pinned_at IS NOT NULL
+		ORDER BY m.pinned_at DESC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*ChatMessage
+	for rows.Next() {
+		message, err := scanChatMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, rows.Err()
+}
+
+// PurgeMessagesOlderThan deletes up to limit messages of the room created
+// before olderThan, oldest first, and returns how many it deleted. If archive
+// is set, it gets the messages first; an error keeps them. Direct messages
+// live in their own table and are kept.
+func (r *ChatRepository) PurgeMessagesOlderThan(ctx context.Context, roomID int64, olderThan time.Time, limit int, archive func([]*ChatMessage) error) (int, error) {
+	query := `
+		SELECT ` + chatMessageColumns + `
+		FROM chat_messages m
+		` + chatMessageJoins + `
+		WHERE m.room_id = $1 AND m.created_at < $2
+		ORDER BY m.id
+		LIMIT $3
+		FOR UPDATE OF m SKIP LOCKED
+	`
+
+	return r.purgeMessages(ctx, archive, query, roomID, olderThan, limit)
+}
+
+// PurgeMessagesBeyond deletes up to limit messages of the room that are not
+// among its newest keep messages, oldest first. Deleted messages count
+// towards keep until they are purged.
+func (r *ChatRepository) PurgeMessagesBeyond(ctx context.Context, roomID int64, keep, limit int, archive func([]*ChatMessage) error) (int, error) {
+	query := `
+		SELECT ` + chatMessageColumns + `
+		FROM chat_messages m
+		` + chatMessageJoins + `
+		WHERE m.room_id = $1 AND m.id <= (
+			SELECT id FROM chat_messages
+			WHERE room_id = $1
+			ORDER BY id DESC
+			OFFSET $2
+			LIMIT 1
+		)
+		ORDER BY m.id
+		LIMIT $3
+		FOR UPDATE OF m SKIP LOCKED
+	`
+
+	return r.purgeMessages(ctx, archive, query, roomID, keep, limit)
+}
+
+// purgeMessages locks the messages selected by the query, archives and
+// deletes them in one transaction. Rows locked by another instance purging
+// the same room are skipped.
+func (r *ChatRepository) purgeMessages(ctx context.Context, archive func([]*ChatMessage) error, query string, args ...interface{}) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	var messages []*ChatMessage
+	var ids []int64
+	for rows.Next() {
+		message, err := scanChatMessage(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		messages = append(messages, message)
+		ids = append(ids, message.ID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	if archive != nil {
+		if err := archive(messages); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM chat_messages WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(messages), nil
 }
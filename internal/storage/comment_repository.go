@@ -3,13 +3,17 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/events"
 )
 
 // Comment represents a comment in the forum
 type Comment struct {
 	ID        int64     `json:"id"`
 	PostID    int64     `json:"post_id"`
+	ParentID  *int64    `json:"parent_id,omitempty"`
 	Content   string    `json:"content"`
 	UserID    int64     `json:"user_id"`
 	CreatedAt time.Time `json:"created_at"`
@@ -22,6 +26,8 @@ type CommentRepository interface {
 	GetCommentByID(ctx context.Context, id int64) (*Comment, error)
 	CreateComment(ctx context.Context, comment *Comment) error
 	DeleteComment(ctx context.Context, id int64) error
+	GetCommentsByUserID(ctx context.Context, userID int64, limit int) ([]Comment, error)
+	CountCommentsByUserID(ctx context.Context, userID int64) (int64, error)
 }
 
 // CommentRepositoryImpl implements CommentRepository
@@ -37,7 +43,7 @@ func NewCommentRepository(db *DB) CommentRepository {
 // GetCommentsByPostID retrieves all comments for a specific post
 func (r *CommentRepositoryImpl) GetCommentsByPostID(ctx context.Context, postID int64) ([]Comment, error) {
 	query := `
-		SELECT id, post_id, content, user_id, created_at, updated_at
+		SELECT id, post_id, parent_id, content, user_id, created_at, updated_at
 		FROM comments
 		WHERE post_id = $1
 		ORDER BY created_at DESC
@@ -55,6 +61,7 @@ func (r *CommentRepositoryImpl) GetCommentsByPostID(ctx context.Context, postID
 		err := rows.Scan(
 			&comment.ID,
 			&comment.PostID,
+			&comment.ParentID,
 			&comment.Content,
 			&comment.UserID,
 			&comment.CreatedAt,
@@ -76,7 +83,7 @@ func (r *CommentRepositoryImpl) GetCommentsByPostID(ctx context.Context, postID
 // GetCommentByID retrieves a comment by its ID
 func (r *CommentRepositoryImpl) GetCommentByID(ctx context.Context, id int64) (*Comment, error) {
 	query := `
-		SELECT id, post_id, content, user_id, created_at, updated_at
+		SELECT id, post_id, parent_id, content, user_id, created_at, updated_at
 		FROM comments
 		WHERE id = $1
 	`
@@ -85,6 +92,7 @@ func (r *CommentRepositoryImpl) GetCommentByID(ctx context.Context, id int64) (*
 	err := r.db.QueryRowContext(ctx, query, id).Scan(
 		&comment.ID,
 		&comment.PostID,
+		&comment.ParentID,
 		&comment.Content,
 		&comment.UserID,
 		&comment.CreatedAt,
@@ -100,11 +108,17 @@ func (r *CommentRepositoryImpl) GetCommentByID(ctx context.Context, id int64) (*
 	return &comment, nil
 }
 
-// CreateComment creates a new comment
+// CreateComment creates a new comment and its comment.created event
 func (r *CommentRepositoryImpl) CreateComment(ctx context.Context, comment *Comment) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
-		INSERT INTO comments (post_id, content, user_id, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO comments (post_id, parent_id, content, user_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING id
 	`
 
@@ -112,24 +126,113 @@ func (r *CommentRepositoryImpl) CreateComment(ctx context.Context, comment *Comm
 	comment.CreatedAt = now
 	comment.UpdatedAt = now
 
-	err := r.db.QueryRowContext(ctx, query,
+	err = tx.QueryRowContext(ctx, query,
 		comment.PostID,
+		comment.ParentID,
 		comment.Content,
 		comment.UserID,
 		comment.CreatedAt,
 		comment.UpdatedAt,
 	).Scan(&comment.ID)
+	if err != nil {
+		return err
+	}
 
-	return err
+	err = insertEvent(ctx, tx, events.CommentCreated, events.CommentCreatedData{
+		ID:        comment.ID,
+		PostID:    comment.PostID,
+		ParentID:  comment.ParentID,
+		Content:   comment.Content,
+		UserID:    comment.UserID,
+		CreatedAt: comment.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// DeleteComment deletes a comment by its ID
+// DeleteComment deletes a comment by its ID and creates its comment.deleted
+// event. Deleting a missing comment is not an error.
 func (r *CommentRepositoryImpl) DeleteComment(ctx context.Context, id int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
 		DELETE FROM comments
 		WHERE id = $1
+		RETURNING post_id, user_id
 	`
 
-	_, err := r.db.ExecContext(ctx, query, id)
-	return err
+	data := events.CommentDeletedData{ID: id}
+	err = tx.QueryRowContext(ctx, query, id).Scan(&data.PostID, &data.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := insertEvent(ctx, tx, events.CommentDeleted, data); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetCommentsByUserID retrieves the most recent comments written by a user
+func (r *CommentRepositoryImpl) GetCommentsByUserID(ctx context.Context, userID int64, limit int) ([]Comment, error) {
+	query := `
+		SELECT id, post_id, parent_id, content, user_id, created_at, updated_at
+		FROM comments
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		var comment Comment
+		err := rows.Scan(
+			&comment.ID,
+			&comment.PostID,
+			&comment.ParentID,
+			&comment.Content,
+			&comment.UserID,
+			&comment.CreatedAt,
+			&comment.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return comments, nil
+}
+
+// CountCommentsByUserID returns the number of comments written by a user
+func (r *CommentRepositoryImpl) CountCommentsByUserID(ctx context.Context, userID int64) (int64, error) {
+	query := `SELECT COUNT(*) FROM comments WHERE user_id = $1`
+
+	var count int64
+	if err := r.db.QueryRowContext(ctx, query, userID).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
 }
@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Conversation is a direct conversation between two users or a small group
+type Conversation struct {
+	ID            int64
+	IsGroup       bool
+	CreatedBy     *int64
+	CreatedAt     time.Time
+	LastMessageAt *time.Time
+	Participants  []ConversationParticipant
+	// UnreadCount is the number of messages from others that the requesting
+	// user has not read yet
+	UnreadCount int
+}
+
+type ConversationParticipant struct {
+	UserID   int64
+	Username string
+}
+
+type DirectMessage struct {
+	ID             int64
+	ConversationID int64
+	UserID         int64
+	Username       string
+	Content        string
+	CreatedAt      time.Time
+}
+
+type ConversationRepository struct {
+	db *DB
+}
+
+func NewConversationRepository(db *DB) *ConversationRepository {
+	return &ConversationRepository{db: db}
+}
+
+// GetOrCreateDirectConversation returns the 1:1 conversation of the two
+// users, creating it on first use
+func (r *ConversationRepository) GetOrCreateDirectConversation(ctx context.Context, userID, otherID int64) (int64, error) {
+	low, high := userID, otherID
+	if low > high {
+		low, high = high, low
+	}
+	directKey := fmt.Sprintf("%d:%d", low, high)
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO conversations (direct_key, created_by)
+		VALUES ($1, $2)
+		ON CONFLICT (direct_key) DO NOTHING
+		RETURNING id
+	`, directKey, userID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRowContext(ctx, `SELECT id FROM conversations WHERE direct_key = $1`, directKey).Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		return id, tx.Commit()
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := addParticipants(ctx, tx, id, []int64{low, high}); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// CreateGroupConversation creates a conversation of the creator and the other users
+func (r *ConversationRepository) CreateGroupConversation(ctx context.Context, createdBy int64, userIDs []int64) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO conversations (created_by) VALUES ($1) RETURNING id
+	`, createdBy).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := addParticipants(ctx, tx, id, append([]int64{createdBy}, userIDs...)); err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+func addParticipants(ctx context.Context, tx *sql.Tx, conversationID int64, userIDs []int64) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO conversation_participants (conversation_id, user_id)
+		SELECT $1, unnest($2::int[])
+		ON CONFLICT DO NOTHING
+	`, conversationID, pq.Array(userIDs))
+	return err
+}
+
+// GetConversationsByUserID lists the conversations of the user with their
+// participants and unread counts, most recently active first
+func (r *ConversationRepository) GetConversationsByUserID(ctx context.Context, userID int64) ([]Conversation, error) {
+	query := `
+		SELECT c.id, c.direct_key IS NULL, c.created_by, c.created_at, c.last_message_at,
+			(SELECT COUNT(*) FROM direct_messages m
+				WHERE m.conversation_id = c.id AND m.id > p.last_read_message_id AND m.user_id <> p.user_id)
+		FROM conversations c
+		JOIN conversation_participants p ON p.conversation_id = c.id
+		WHERE p.user_id = $1
+		ORDER BY COALESCE(c.last_message_at, c.created_at) DESC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var conversations []Conversation
+	index := make(map[int64]int)
+	for rows.Next() {
+		var c Conversation
+		if err := rows.Scan(&c.ID, &c.IsGroup, &c.CreatedBy, &c.CreatedAt, &c.LastMessageAt, &c.UnreadCount); err != nil {
+			return nil, err
+		}
+		index[c.ID] = len(conversations)
+		conversations = append(conversations, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(conversations) == 0 {
+		return conversations, nil
+	}
+
+	ids := make([]int64, 0, len(conversations))
+	for _, c := range conversations {
+		ids = append(ids, c.ID)
+	}
+
+	participants, err := r.db.QueryContext(ctx, `
+		SELECT p.conversation_id, p.user_id, u.username
+		FROM conversation_participants p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.conversation_id = ANY($1)
+		ORDER BY p.joined_at, p.user_id
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer participants.Close()
+
+	for participants.Next() {
+		var conversationID int64
+		var p ConversationParticipant
+		if err := participants.Scan(&conversationID, &p.UserID, &p.Username); err != nil {
+			return nil, err
+		}
+		c := &conversations[index[conversationID]]
+		c.Participants = append(c.Participants, p)
+	}
+
+	return conversations, participants.Err()
+}
+
+// GetParticipantIDs returns the users taking part in the conversation
+func (r *ConversationRepository) GetParticipantIDs(ctx context.Context, conversationID int64) ([]int64, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT user_id FROM conversation_participants WHERE conversation_id = $1
+	`, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// CreateDirectMessage stores a message and marks it as read for its sender
+func (r *ConversationRepository) CreateDirectMessage(ctx context.Context, conversationID, userID int64, content string) (*DirectMessage, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	message := &DirectMessage{}
+	err = tx.QueryRowContext(ctx, `
+		WITH inserted AS (
+			INSERT INTO direct_messages (conversation_id, user_id, content)
+			VALUES ($1, $2, $3)
+			RETURNING id, conversation_id, user_id, content, created_at
+		)
+		SELECT i.id, i.conversation_id, i.user_id, u.username, i.content, i.created_at
+		FROM inserted i
+		JOIN users u ON u.id = i.user_id
+	`, conversationID, userID, content).Scan(
+		&message.ID, &message.ConversationID, &message.UserID, &message.Username, &message.Content, &message.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE conversations SET last_message_at = $2 WHERE id = $1
+	`, conversationID, message.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE conversation_participants SET last_read_message_id = $3
+		WHERE conversation_id = $1 AND user_id = $2
+	`, conversationID, userID, message.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// GetConversationMessages returns up to limit messages older than beforeID,
+// newest first. A zero beforeID starts at the latest message.
+func (r *ConversationRepository) GetConversationMessages(ctx context.Context, conversationID, beforeID int64, limit int) ([]*DirectMessage, error) {
+	query := `
+		SELECT m.id, m.conversation_id, m.user_id, u.username, m.content, m.created_at
+		FROM direct_messages m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.conversation_id = $1 AND ($2 = 0 OR m.id < $2)
+		ORDER BY m.id DESC
+		LIMIT $3
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, conversationID, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*DirectMessage
+	for rows.Next() {
+		message := &DirectMessage{}
+		err := rows.Scan(&message.ID, &message.ConversationID, &message.UserID, &message.Username, &message.Content, &message.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, rows.Err()
+}
+
+// MarkRead moves the read marker of the participant forward to the message.
+// It reports whether the user takes part in the conversation.
+func (r *ConversationRepository) MarkRead(ctx context.Context, conversationID, userID, messageID int64) (bool, error) {
+	query := `
+		UPDATE conversation_participants
+		SET last_read_message_id = GREATEST(last_read_message_id, $3)
+		WHERE conversation_id = $1 AND user_id = $2
+	`
+	result, err := r.db.ExecContext(ctx, query, conversationID, userID, messageID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// FanoutRepository sends chat events between forum-service instances with
+// PostgreSQL NOTIFY
+type FanoutRepository struct {
+	db *DB
+}
+
+func NewFanoutRepository(db *DB) *FanoutRepository {
+	return &FanoutRepository{db: db}
+}
+
+// Notify sends the payload to all listeners of the channel
+func (r *FanoutRepository) Notify(ctx context.Context, channel, payload string) error {
+	_, err := r.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, channel, payload)
+	return err
+}
+
+// StorePayload keeps a payload that is too large for NOTIFY and returns its ID
+func (r *FanoutRepository) StorePayload(ctx context.Context, payload string) (int64, error) {
+	var id int64
+	err := r.db.QueryRowContext(ctx, `
+		INSERT INTO chat_fanout_payloads (payload) VALUES ($1) RETURNING id
+	`, payload).Scan(&id)
+	return id, err
+}
+
+// GetPayload returns a stored payload, or an empty string if it was pruned
+func (r *FanoutRepository) GetPayload(ctx context.Context, id int64) (string, error) {
+	var payload string
+	err := r.db.QueryRowContext(ctx, `SELECT payload FROM chat_fanout_payloads WHERE id = $1`, id).Scan(&payload)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+	return payload, nil
+}
+
+func (r *FanoutRepository) DeleteOldPayloads(ctx context.Context, olderThan time.Time) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM chat_fanout_payloads WHERE created_at < $1`, olderThan)
+	return err
+}
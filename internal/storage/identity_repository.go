@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/events"
+)
+
+// UserIdentity links a subject at an external identity provider to a user
+type UserIdentity struct {
+	ID        int64
+	UserID    int64
+	Provider  string
+	Subject   string
+	Email     string
+	CreatedAt time.Time
+}
+
+// OIDCLoginState is a pending authorization code flow. LinkUserID is set when
+// the flow links an identity to an existing user instead of signing in.
+type OIDCLoginState struct {
+	Provider     string
+	CodeVerifier string
+	Nonce        string
+	LinkUserID   *int64
+	ExpiresAt    time.Time
+}
+
+// ErrIdentityTaken is returned when the external subject is already linked
+var ErrIdentityTaken = errors.New("identity is already linked to an account")
+
+type IdentityRepository struct {
+	db *DB
+}
+
+func NewIdentityRepository(db *DB) *IdentityRepository {
+	return &IdentityRepository{db: db}
+}
+
+// GetIdentity returns the identity of the provider subject, or nil if it is not linked
+func (r *IdentityRepository) GetIdentity(ctx context.Context, provider, subject string) (*UserIdentity, error) {
+	query := `
+		SELECT id, user_id, provider, subject, email, created_at
+		FROM user_identities
+		WHERE provider = $1 AND subject = $2
+	`
+
+	identity := &UserIdentity{}
+	err := r.db.QueryRowContext(ctx, query, provider, subject).
+		Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return identity, nil
+}
+
+func (r *IdentityRepository) GetIdentitiesByUserID(ctx context.Context, userID int64) ([]UserIdentity, error) {
+	query := `
+		SELECT id, user_id, provider, subject, email, created_at
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []UserIdentity
+	for rows.Next() {
+		var identity UserIdentity
+		if err := rows.Scan(&identity.ID, &identity.UserID, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, rows.Err()
+}
+
+// LinkIdentity links the provider subject to an existing user
+func (r *IdentityRepository) LinkIdentity(ctx context.Context, userID int64, provider, subject, email string) error {
+	query := `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+	`
+	result, err := r.db.ExecContext(ctx, query, userID, provider, subject, email)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrIdentityTaken
+	}
+
+	return nil
+}
+
+// CreateUserWithIdentity creates a user without a password together with its
+// first linked identity, and its user.registered event
+func (r *IdentityRepository) CreateUserWithIdentity(ctx context.Context, username, email string, emailVerified bool, provider, subject string) (*User, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO users (username, email, password_hash, role, email_verified)
+		VALUES ($1, $2, '', 'user', $3)
+		RETURNING ` + userColumns
+
+	user, err := scanUser(tx.QueryRowContext(ctx, query, username, email, emailVerified))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+	`, user.ID, provider, subject, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := insertEvent(ctx, tx, events.UserRegistered, userRegisteredData(user)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// UnlinkIdentity removes the link to the provider and reports whether one existed
+func (r *IdentityRepository) UnlinkIdentity(ctx context.Context, userID int64, provider string) (bool, error) {
+	query := `DELETE FROM user_identities WHERE user_id = $1 AND provider = $2`
+	result, err := r.db.ExecContext(ctx, query, userID, provider)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func (r *IdentityRepository) CreateLoginState(ctx context.Context, stateHash string, state *OIDCLoginState) error {
+	query := `
+		INSERT INTO oidc_login_states (state_hash, provider, code_verifier, nonce, link_user_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err := r.db.ExecContext(ctx, query, stateHash, state.Provider, state.CodeVerifier, state.Nonce, state.LinkUserID, state.ExpiresAt)
+	return err
+}
+
+// ConsumeLoginState deletes the state and returns it, so that every state can
+// complete at most one flow. It returns nil if the state is unknown or expired.
+func (r *IdentityRepository) ConsumeLoginState(ctx context.Context, stateHash string) (*OIDCLoginState, error) {
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE state_hash = $1
+		RETURNING provider, code_verifier, nonce, link_user_id, expires_at
+	`
+
+	state := &OIDCLoginState{}
+	err := r.db.QueryRowContext(ctx, query, stateHash).
+		Scan(&state.Provider, &state.CodeVerifier, &state.Nonce, &state.LinkUserID, &state.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if time.Now().After(state.ExpiresAt) {
+		return nil, nil
+	}
+	return state, nil
+}
+
+// DeleteExpiredLoginStates removes abandoned flows
+func (r *IdentityRepository) DeleteExpiredLoginStates(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM oidc_login_states WHERE expires_at < NOW()`)
+	return err
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// LoginThrottle tracks failed sign-in attempts for one key, such as a username or a client IP
+type LoginThrottle struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	BlockedUntil  *time.Time
+	LockedUntil   *time.Time
+}
+
+type LoginThrottleRepository struct {
+	db *DB
+}
+
+func NewLoginThrottleRepository(db *DB) *LoginThrottleRepository {
+	return &LoginThrottleRepository{db: db}
+}
+
+// GetThrottle returns the throttle state for the key, or nil if there were no recent failures
+func (r *LoginThrottleRepository) GetThrottle(ctx context.Context, key string) (*LoginThrottle, error) {
+	query := `
+		SELECT key, failures, last_failure_at, blocked_until, locked_until
+		FROM login_throttles
+		WHERE key = $1
+	`
+
+	t := &LoginThrottle{}
+	err := r.db.QueryRowContext(ctx, query, key).
+		Scan(&t.Key, &t.Failures, &t.LastFailureAt, &t.BlockedUntil, &t.LockedUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// RecordFailure increments the failure counter and returns the new count and
+// whether a lockout is still running. Failures older than resetBefore no
+// longer count, and neither do those before an expired lockout; in both cases
+// the counter restarts.
+func (r *LoginThrottleRepository) RecordFailure(ctx context.Context, key string, now, resetBefore time.Time) (int, bool, error) {
+	query := `
+		INSERT INTO login_throttles (key, failures, last_failure_at)
+		VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE
+				WHEN login_throttles.locked_until <= $2
+					OR (login_throttles.locked_until IS NULL AND login_throttles.last_failure_at < $3)
+				THEN 1
+				ELSE login_throttles.failures + 1
+			END,
+			locked_until = CASE
+				WHEN login_throttles.locked_until <= $2 THEN NULL
+				ELSE login_throttles.locked_until
+			END,
+			last_failure_at = $2
+		RETURNING failures, locked_until IS NOT NULL
+	`
+
+	var failures int
+	var locked bool
+	if err := r.db.QueryRowContext(ctx, query, key, now, resetBefore).Scan(&failures, &locked); err != nil {
+		return 0, false, err
+	}
+
+	return failures, locked, nil
+}
+
+// SetBlocks stores the backoff and lockout deadlines for the key
+func (r *LoginThrottleRepository) SetBlocks(ctx context.Context, key string, blockedUntil, lockedUntil *time.Time) error {
+	query := `UPDATE login_throttles SET blocked_until = $2, locked_until = COALESCE($3, locked_until) WHERE key = $1`
+	_, err := r.db.ExecContext(ctx, query, key, blockedUntil, lockedUntil)
+	return err
+}
+
+// Reset clears the failures recorded for the key
+func (r *LoginThrottleRepository) Reset(ctx context.Context, key string) error {
+	query := `DELETE FROM login_throttles WHERE key = $1`
+	_, err := r.db.ExecContext(ctx, query, key)
+	return err
+}
+
+// DeleteStale removes entries without failures or locks since the given time
+func (r *LoginThrottleRepository) DeleteStale(ctx context.Context, before time.Time) error {
+	query := `
+		DELETE FROM login_throttles
+		WHERE last_failure_at < $1 AND (locked_until IS NULL OR locked_until < $1)
+	`
+	_, err := r.db.ExecContext(ctx, query, before)
+	return err
+}
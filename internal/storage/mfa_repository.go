@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// UserMFA holds the TOTP enrolment of a user. The secret is stored encrypted.
+type UserMFA struct {
+	UserID           int64
+	SecretCiphertext string
+	Enabled          bool
+	LastUsedStep     int64
+	CreatedAt        time.Time
+	EnabledAt        *time.Time
+}
+
+type MFARepository struct {
+	db *DB
+}
+
+func NewMFARepository(db *DB) *MFARepository {
+	return &MFARepository{db: db}
+}
+
+// GetMFA returns the enrolment of the user, or nil if the user never started one
+func (r *MFARepository) GetMFA(ctx context.Context, userID int64) (*UserMFA, error) {
+	query := `
+		SELECT user_id, secret_ciphertext, enabled, last_used_step, created_at, enabled_at
+		FROM user_mfa
+		WHERE user_id = $1
+	`
+
+	m := &UserMFA{}
+	err := r.db.QueryRowContext(ctx, query, userID).
+		Scan(&m.UserID, &m.SecretCiphertext, &m.Enabled, &m.LastUsedStep, &m.CreatedAt, &m.EnabledAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// SavePendingMFA stores a new secret that is not active until EnableMFA is called
+func (r *MFARepository) SavePendingMFA(ctx context.Context, userID int64, secretCiphertext string) error {
+	query := `
+		INSERT INTO user_mfa (user_id, secret_ciphertext, enabled, last_used_step)
+		VALUES ($1, $2, FALSE, 0)
+		ON CONFLICT (user_id) DO UPDATE SET
+			secret_ciphertext = EXCLUDED.secret_ciphertext,
+			enabled = FALSE,
+			last_used_step = 0,
+			created_at = CURRENT_TIMESTAMP,
+			enabled_at = NULL
+		WHERE user_mfa.enabled = FALSE
+	`
+	_, err := r.db.ExecContext(ctx, query, userID, secretCiphertext)
+	return err
+}
+
+// EnableMFA activates the pending secret and replaces the recovery codes
+func (r *MFARepository) EnableMFA(ctx context.Context, userID int64, step int64, recoveryCodeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE user_mfa SET enabled = TRUE, enabled_at = CURRENT_TIMESTAMP, last_used_step = $2
+		WHERE user_id = $1
+	`, userID, step)
+	if err != nil {
+		return err
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReplaceRecoveryCodes invalidates all recovery codes of the user and stores new ones
+func (r *MFARepository) ReplaceRecoveryCodes(ctx context.Context, userID int64, recoveryCodeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int64, hashes []string) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		_, err := tx.ExecContext(ctx, `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DisableMFA removes the enrolment and all recovery codes of the user
+func (r *MFARepository) DisableMFA(ctx context.Context, userID int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseStep records the TOTP time step of an accepted code. It returns false if
+// the step, or a later one, was already used, which prevents code replay.
+func (r *MFARepository) UseStep(ctx context.Context, userID, step int64) (bool, error) {
+	query := `UPDATE user_mfa SET last_used_step = $2 WHERE user_id = $1 AND last_used_step < $2`
+	result, err := r.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// ConsumeRecoveryCode marks a matching unused recovery code as used
+func (r *MFARepository) ConsumeRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error) {
+	query := `
+		UPDATE user_recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE id = (
+			SELECT id FROM user_recovery_codes
+			WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+			LIMIT 1
+		)
+	`
+	result, err := r.db.ExecContext(ctx, query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of the user
+func (r *MFARepository) CountRecoveryCodes(ctx context.Context, userID int64) (int, error) {
+	query := `SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+
+	var count int
+	if err := r.db.QueryRowContext(ctx, query, userID).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
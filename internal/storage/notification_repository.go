@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Notification types
+const (
+	// NotificationPostReply tells the author of a post about a new comment
+	NotificationPostReply = "post_reply"
+	// NotificationCommentReply tells the author of a comment about a reply
+	NotificationCommentReply = "comment_reply"
+	// NotificationMention tells a user they were @mentioned in a comment or
+	// a chat message
+	NotificationMention = "mention"
+)
+
+// NotificationTypes lists the types users can turn off
+var NotificationTypes = []string{NotificationPostReply, NotificationCommentReply, NotificationMention}
+
+// Notification tells a user about content created by someone else. The
+// subject IDs that are set depend on the type.
+type Notification struct {
+	ID            int64
+	UserID        int64
+	Type          string
+	ActorID       *int64
+	ActorUsername *string
+	PostID        *int64
+	CommentID     *int64
+	RoomID        *int64
+	MessageID     *int64
+	Excerpt       string
+	ReadAt        *time.Time
+	CreatedAt     time.Time
+}
+
+const notificationColumns = `n.id, n.user_id, n.type, n.actor_id, a.username, n.post_id, n.comment_id, n.room_id, n.message_id, n.excerpt, n.read_at, n.created_at`
+
+type NotificationRepository struct {
+	db *DB
+}
+
+func NewNotificationRepository(db *DB) *NotificationRepository {
+	return &NotificationRepository{db: db}
+}
+
+func scanNotification(row rowScanner) (*Notification, error) {
+	n := &Notification{}
+	err := row.Scan(&n.ID, &n.UserID, &n.Type, &n.ActorID, &n.ActorUsername, &n.PostID, &n.CommentID, &n.RoomID, &n.MessageID, &n.Excerpt, &n.ReadAt, &n.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// CreateNotification stores a notification unless the user turned its type
+// off or was already notified of the comment, in which case it returns nil
+func (r *NotificationRepository) CreateNotification(ctx context.Context, n *Notification) (*Notification, error) {
+	query := `
+		WITH inserted AS (
+			INSERT INTO notifications (user_id, type, actor_id, post_id, comment_id, room_id, message_id, excerpt)
+			SELECT $1, $2, $3, $4, $5, $6, $7, $8
+			WHERE NOT EXISTS (
+				SELECT 1 FROM notification_preferences
+				WHERE user_id = $1 AND type = $2 AND NOT enabled
+			)
+			ON CONFLICT DO NOTHING
+			RETURNING *
+		)
+		SELECT ` + notificationColumns + `
+		FROM inserted n
+		LEFT JOIN users a ON a.id = n.actor_id
+	`
+
+	created, err := scanNotification(r.db.QueryRowContext(ctx, query,
+		n.UserID, n.Type, n.ActorID, n.PostID, n.CommentID, n.RoomID, n.MessageID, n.Excerpt))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// GetNotifications returns up to limit notifications of the user older than
+// beforeID, or the newest ones if beforeID is zero, newest first
+func (r *NotificationRepository) GetNotifications(ctx context.Context, userID, beforeID int64, unreadOnly bool, limit int) ([]Notification, error) {
+	query := `
+		SELECT ` + notificationColumns + `
+		FROM notifications n
+		LEFT JOIN users a ON a.id = n.actor_id
+		WHERE n.user_id = $1 AND ($2 = 0 OR n.id < $2) AND (NOT $3 OR n.read_at IS NULL)
+		ORDER BY n.id DESC
+		LIMIT $4
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID, beforeID, unreadOnly, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []Notification
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, *n)
+	}
+
+	return notifications, rows.Err()
+}
+
+func (r *NotificationRepository) CountUnread(ctx context.Context, userID int64) (int, error) {
+	query := `SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL`
+
+	var count int
+	if err := r.db.QueryRowContext(ctx, query, userID).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// MarkRead marks the notifications of the user as read, all of them if ids
+// is empty, and returns how many were unread
+func (r *NotificationRepository) MarkRead(ctx context.Context, userID int64, ids []int64) (int64, error) {
+	query := `
+		UPDATE notifications SET read_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND read_at IS NULL AND (cardinality($2::INTEGER[]) = 0 OR id = ANY($2))
+	`
+
+	result, err := r.db.ExecContext(ctx, query, userID, pq.Array(ids))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetPreferences returns whether each notification type is enabled for the user
+func (r *NotificationRepository) GetPreferences(ctx context.Context, userID int64) (map[string]bool, error) {
+	preferences := make(map[string]bool, len(NotificationTypes))
+	for _, t := range NotificationTypes {
+		preferences[t] = true
+	}
+
+	query := `SELECT type, enabled FROM notification_preferences WHERE user_id = $1`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t string
+		var enabled bool
+		if err := rows.Scan(&t, &enabled); err != nil {
+			return nil, err
+		}
+		preferences[t] = enabled
+	}
+
+	return preferences, rows.Err()
+}
+
+func (r *NotificationRepository) SetPreference(ctx context.Context, userID int64, notificationType string, enabled bool) error {
+	query := `
+		INSERT INTO notification_preferences (user_id, type, enabled)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, type) DO UPDATE SET enabled = EXCLUDED.enabled
+	`
+
+	_, err := r.db.ExecContext(ctx, query, userID, notificationType, enabled)
+	return err
+}
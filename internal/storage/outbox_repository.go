@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/events"
+)
+
+// OutboxRepository stores domain events until the relay dispatched them. It
+// implements events.Outbox.
+type OutboxRepository struct {
+	db *DB
+}
+
+func NewOutboxRepository(db *DB) *OutboxRepository {
+	return &OutboxRepository{db: db}
+}
+
+// insertEvent writes an event to the outbox in the transaction of the change
+// it describes, so that the event exists if and only if the change does
+func insertEvent(ctx context.Context, tx *sql.Tx, eventType string, data interface{}) error {
+	e, err := events.New(eventType, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO outbox_events (event_id, event_type, payload, created_at)
+		VALUES ($1, $2, $3, $4)
+	`, e.ID, e.Type, []byte(e.Payload), e.OccurredAt)
+	return err
+}
+
+func (r *OutboxRepository) ClaimEvents(ctx context.Context, limit int, leaseUntil time.Time) ([]events.Record, error) {
+	query := `
+		WITH due AS (
+			SELECT id
+			FROM outbox_events
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		),
+		claimed AS (
+			UPDATE outbox_events SET next_attempt_at = $2
+			FROM due
+			WHERE outbox_events.id = due.id
+			RETURNING outbox_events.id, event_id, event_type, payload, attempts, created_at
+		)
+		SELECT event_id, event_type, payload, attempts, created_at
+		FROM claimed
+		ORDER BY id
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, limit, leaseUntil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []events.Record
+	for rows.Next() {
+		var record events.Record
+		var payload []byte
+		if err := rows.Scan(&record.ID, &record.Type, &payload, &record.Attempts, &record.OccurredAt); err != nil {
+			return nil, err
+		}
+		record.Payload = payload
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+func (r *OutboxRepository) HandledBy(ctx context.Context, eventID string) (map[string]bool, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT subscriber FROM outbox_handled WHERE event_id = $1`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	handled := make(map[string]bool)
+	for rows.Next() {
+		var subscriber string
+		if err := rows.Scan(&subscriber); err != nil {
+			return nil, err
+		}
+		handled[subscriber] = true
+	}
+
+	return handled, rows.Err()
+}
+
+func (r *OutboxRepository) MarkHandled(ctx context.Context, eventID, subscriber string) error {
+	query := `
+		INSERT INTO outbox_handled (event_id, subscriber)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+
+	_, err := r.db.ExecContext(ctx, query, eventID, subscriber)
+	return err
+}
+
+func (r *OutboxRepository) RecordAttempt(ctx context.Context, eventID string, dispatched bool, errMsg string, nextAttemptAt *time.Time) error {
+	status := "pending"
+	switch {
+	case dispatched:
+		status = "dispatched"
+	case nextAttemptAt == nil:
+		status = "dead"
+	}
+
+	query := `
+		UPDATE outbox_events
+		SET status = $2,
+			attempts = attempts + CASE WHEN $3 THEN 0 ELSE 1 END,
+			next_attempt_at = COALESCE($4, next_attempt_at),
+			last_error = $5,
+			dispatched_at = CASE WHEN $3 THEN CURRENT_TIMESTAMP ELSE dispatched_at END
+		WHERE event_id = $1
+	`
+
+	_, err := r.db.ExecContext(ctx, query, eventID, status, dispatched, nextAttemptAt, errMsg)
+	return err
+}
+
+// DeleteDispatched deletes the events dispatched before the time and returns
+// their number. Dead events are kept for inspection.
+func (r *OutboxRepository) DeleteDispatched(ctx context.Context, before time.Time) (int64, error) {
+	query := `DELETE FROM outbox_events WHERE status = 'dispatched' AND dispatched_at < $1`
+
+	result, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -2,9 +2,11 @@ package storage
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"time"
 
+	"github.com/Ryan-Gosusluging/forum/internal/events"
 	"github.com/jackc/pgx/v5"
 )
 
@@ -25,7 +27,14 @@ func NewPostRepository(db *DB) *PostRepository {
 	return &PostRepository{db: db}
 }
 
+// CreatePost creates a post and its post.created event
 func (r *PostRepository) CreatePost(ctx context.Context, title, content string, userID int64) (*Post, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	query := `
 		INSERT INTO posts (title, content, user_id)
 		VALUES ($1, $2, $3)
@@ -33,13 +42,28 @@ func (r *PostRepository) CreatePost(ctx context.Context, title, content string,
 	`
 
 	post := &Post{}
-	err := r.db.QueryRowContext(ctx, query, title, content, userID).
+	err = tx.QueryRowContext(ctx, query, title, content, userID).
 		Scan(&post.ID, &post.Title, &post.Content, &post.UserID, &post.CreatedAt, &post.UpdatedAt)
 
 	if err != nil {
 		return nil, err
 	}
 
+	err = insertEvent(ctx, tx, events.PostCreated, events.PostCreatedData{
+		ID:        post.ID,
+		Title:     post.Title,
+		Content:   post.Content,
+		UserID:    post.UserID,
+		CreatedAt: post.CreatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
 	return post, nil
 }
 
@@ -95,21 +119,69 @@ func (r *PostRepository) GetPosts(ctx context.Context, limit, offset int) ([]*Po
 	return posts, nil
 }
 
+// DeletePost deletes a post and creates its post.deleted event
 func (r *PostRepository) DeletePost(ctx context.Context, id int64) error {
-	query := `DELETE FROM posts WHERE id = $1`
-	result, err := r.db.ExecContext(ctx, query, id)
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	rowsAffected, err := result.RowsAffected()
+	var userID int64
+	err = tx.QueryRowContext(ctx, `DELETE FROM posts WHERE id = $1 RETURNING user_id`, id).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.New("post not found")
+	}
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return errors.New("post not found")
+	if err := insertEvent(ctx, tx, events.PostDeleted, events.PostDeletedData{ID: id, UserID: userID}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *PostRepository) GetPostsByUserID(ctx context.Context, userID int64, limit int) ([]*Post, error) {
+	query := `
+		SELECT id, title, content, user_id, created_at, updated_at
+		FROM posts
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []*Post
+	for rows.Next() {
+		post := &Post{}
+		err := rows.Scan(&post.ID, &post.Title, &post.Content, &post.UserID, &post.CreatedAt, &post.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return posts, nil
+}
+
+func (r *PostRepository) CountPostsByUserID(ctx context.Context, userID int64) (int64, error) {
+	query := `SELECT COUNT(*) FROM posts WHERE user_id = $1`
+
+	var count int64
+	if err := r.db.QueryRowContext(ctx, query, userID).Scan(&count); err != nil {
+		return 0, err
 	}
 
-	return nil
+	return count, nil
 }
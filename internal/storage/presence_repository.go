@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// OnlineUser is a user with at least one open chat connection
+type OnlineUser struct {
+	UserID   int64
+	Username string
+}
+
+// PresenceRepository records which users are connected to which instance.
+// Room 0 stands for being connected at all.
+type PresenceRepository struct {
+	db *DB
+}
+
+func NewPresenceRepository(db *DB) *PresenceRepository {
+	return &PresenceRepository{db: db}
+}
+
+func (r *PresenceRepository) AddPresence(ctx context.Context, instanceID string, userID, roomID int64) error {
+	query := `
+		INSERT INTO chat_presence (instance_id, user_id, room_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (instance_id, user_id, room_id) DO UPDATE SET seen_at = CURRENT_TIMESTAMP
+	`
+	_, err := r.db.ExecContext(ctx, query, instanceID, userID, roomID)
+	return err
+}
+
+func (r *PresenceRepository) RemovePresence(ctx context.Context, instanceID string, userID, roomID int64) error {
+	query := `DELETE FROM chat_presence WHERE instance_id = $1 AND user_id = $2 AND room_id = $3`
+	_, err := r.db.ExecContext(ctx, query, instanceID, userID, roomID)
+	return err
+}
+
+// IsPresent reports whether any instance has seen the user in the room since the given time
+func (r *PresenceRepository) IsPresent(ctx context.Context, userID, roomID int64, since time.Time) (bool, error) {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM chat_presence
+			WHERE user_id = $1 AND room_id = $2 AND seen_at >= $3
+		)
+	`
+
+	var present bool
+	if err := r.db.QueryRowContext(ctx, query, userID, roomID, since).Scan(&present); err != nil {
+		return false, err
+	}
+
+	return present, nil
+}
+
+// GetOnlineUsers lists the users seen in the room since the given time
+func (r *PresenceRepository) GetOnlineUsers(ctx context.Context, roomID int64, since time.Time) ([]OnlineUser, error) {
+	query := `
+		SELECT DISTINCT p.user_id, u.username
+		FROM chat_presence p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.room_id = $1 AND p.seen_at >= $2
+		ORDER BY u.username
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, roomID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []OnlineUser
+	for rows.Next() {
+		var u OnlineUser
+		if err := rows.Scan(&u.UserID, &u.Username); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+// TouchInstancePresence marks all rows of the instance as current
+func (r *PresenceRepository) TouchInstancePresence(ctx context.Context, instanceID string) error {
+	query := `UPDATE chat_presence SET seen_at = CURRENT_TIMESTAMP WHERE instance_id = $1`
+	_, err := r.db.ExecContext(ctx, query, instanceID)
+	return err
+}
+
+func (r *PresenceRepository) DeleteInstancePresence(ctx context.Context, instanceID string) error {
+	query := `DELETE FROM chat_presence WHERE instance_id = $1`
+	_, err := r.db.ExecContext(ctx, query, instanceID)
+	return err
+}
+
+// DeleteStalePresence removes rows that instances stopped refreshing
+func (r *PresenceRepository) DeleteStalePresence(ctx context.Context, olderThan time.Time) error {
+	query := `DELETE FROM chat_presence WHERE seen_at < $1`
+	_, err := r.db.ExecContext(ctx, query, olderThan)
+	return err
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/events"
+)
+
+// Report targets
+const (
+	ReportTargetPost    = "post"
+	ReportTargetComment = "comment"
+)
+
+// Report is a post or comment reported to the moderators
+type Report struct {
+	ID         int64
+	ReporterID int64
+	TargetType string
+	TargetID   int64
+	Reason     string
+	CreatedAt  time.Time
+}
+
+type ReportRepository struct {
+	db *DB
+}
+
+func NewReportRepository(db *DB) *ReportRepository {
+	return &ReportRepository{db: db}
+}
+
+// CreateReport files a report and its report.filed event. It returns nil if
+// the reported post or comment does not exist.
+func (r *ReportRepository) CreateReport(ctx context.Context, reporterID int64, targetType string, targetID int64, reason string) (*Report, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO reports (reporter_id, target_type, target_id, reason)
+		SELECT $1::INTEGER, $2::VARCHAR, $3::INTEGER, $4::TEXT
+		WHERE ($2::VARCHAR = 'post' AND EXISTS (SELECT 1 FROM posts WHERE id = $3::INTEGER))
+			OR ($2::VARCHAR = 'comment' AND EXISTS (SELECT 1 FROM comments WHERE id = $3::INTEGER))
+		RETURNING id, reporter_id, target_type, target_id, reason, created_at
+	`
+
+	report := &Report{}
+	err = tx.QueryRowContext(ctx, query, reporterID, targetType, targetID, reason).
+		Scan(&report.ID, &report.ReporterID, &report.TargetType, &report.TargetID, &report.Reason, &report.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertEvent(ctx, tx, events.ReportFiled, events.ReportFiledData{
+		ID:         report.ID,
+		ReporterID: report.ReporterID,
+		TargetType: report.TargetType,
+		TargetID:   report.TargetID,
+		Reason:     report.Reason,
+		CreatedAt:  report.CreatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
@@ -0,0 +1,331 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Room kinds. Public and category rooms can be read by anyone, private rooms
+// only by their members.
+const (
+	RoomKindPublic   = "public"
+	RoomKindPrivate  = "private"
+	RoomKindCategory = "category"
+)
+
+// Room member roles
+const (
+	RoomRoleOwner  = "owner"
+	RoomRoleMember = "member"
+)
+
+// Room retention policies
+const (
+	// RetentionTTL purges messages after the TTL of the room, or the global
+	// one if the room has none
+	RetentionTTL = "ttl"
+	// RetentionCount keeps the newest MaxMessages messages
+	RetentionCount   = "count"
+	RetentionForever = "forever"
+)
+
+// DefaultRoomSlug is the room created by the migrations for messages sent
+// before rooms existed
+const DefaultRoomSlug = "general"
+
+// ErrRoomTaken is returned when the slug or category is already used by another room
+var ErrRoomTaken = errors.New("room slug or category is already taken")
+
+type Room struct {
+	ID        int64
+	Slug      string
+	Name      string
+	Kind      string
+	Category  *string
+	CreatedBy *int64
+	CreatedAt time.Time
+	// SlowMode is the minimum time between two messages of a user, 0 if
+	// slow mode is off
+	SlowMode  time.Duration
+	Retention RoomRetention
+}
+
+// RoomRetention decides how long the messages of a room are kept
+type RoomRetention struct {
+	Policy string
+	// TTL is 0 for the global TTL
+	TTL         time.Duration
+	MaxMessages int
+}
+
+type RoomMember struct {
+	RoomID   int64
+	UserID   int64
+	Username string
+	Role     string
+	JoinedAt time.Time
+}
+
+const roomColumns = `id, slug, name, kind, category, created_by, created_at, slow_mode_seconds,
+	retention_policy, retention_ttl_seconds, retention_max_messages`
+
+type RoomRepository struct {
+	db *DB
+}
+
+func NewRoomRepository(db *DB) *RoomRepository {
+	return &RoomRepository{db: db}
+}
+
+func scanRoom(row rowScanner) (*Room, error) {
+	room := &Room{}
+	var slowModeSeconds int
+	var ttlSeconds, maxMessages sql.NullInt64
+	err := row.Scan(&room.ID, &room.Slug, &room.Name, &room.Kind, &room.Category, &room.CreatedBy, &room.CreatedAt, &slowModeSeconds,
+		&room.Retention.Policy, &ttlSeconds, &maxMessages)
+	if err != nil {
+		return nil, err
+	}
+	room.SlowMode = time.Duration(slowModeSeconds) * time.Second
+	room.Retention.TTL = time.Duration(ttlSeconds.Int64) * time.Second
+	room.Retention.MaxMessages = int(maxMessages.Int64)
+	return room, nil
+}
+
+// CreateRoom creates a room. If createdBy is set, that user becomes its owner.
+func (r *RoomRepository) CreateRoom(ctx context.Context, slug, name, kind string, category *string, createdBy *int64) (*Room, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO rooms (slug, name, kind, category, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT DO NOTHING
+		RETURNING ` + roomColumns
+
+	room, err := scanRoom(tx.QueryRowContext(ctx, query, slug, name, kind, category, createdBy))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrRoomTaken
+		}
+		return nil, err
+	}
+
+	if createdBy != nil {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO room_members (room_id, user_id, role)
+			VALUES ($1, $2, $3)
+		`, room.ID, *createdBy, RoomRoleOwner)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return room, nil
+}
+
+func (r *RoomRepository) GetRoom(ctx context.Context, id int64) (*Room, error) {
+	query := `SELECT ` + roomColumns + ` FROM rooms WHERE id = $1`
+
+	room, err := scanRoom(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return room, nil
+}
+
+func (r *RoomRepository) GetRoomBySlug(ctx context.Context, slug string) (*Room, error) {
+	query := `SELECT ` + roomColumns + ` FROM rooms WHERE slug = $1`
+
+	room, err := scanRoom(r.db.QueryRowContext(ctx, query, slug))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// SetSlowMode changes the slow mode interval of the room, 0 turns it off. It
+// returns nil if there is no such room.
+func (r *RoomRepository) SetSlowMode(ctx context.Context, id int64, interval time.Duration) (*Room, error) {
+	query := `
+		UPDATE rooms SET slow_mode_seconds = $2
+		WHERE id = $1
+		RETURNING ` + roomColumns
+
+	room, err := scanRoom(r.db.QueryRowContext(ctx, query, id, int(interval/time.Second)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// SetRetention changes the retention policy of the room. It returns nil if
+// there is no such room.
+func (r *RoomRepository) SetRetention(ctx context.Context, id int64, retention RoomRetention) (*Room, error) {
+	var ttlSeconds, maxMessages *int
+	if retention.TTL > 0 {
+		seconds := int(retention.TTL / time.Second)
+		ttlSeconds = &seconds
+	}
+	if retention.MaxMessages > 0 {
+		maxMessages = &retention.MaxMessages
+	}
+
+	query := `
+		UPDATE rooms SET retention_policy = $2, retention_ttl_seconds = $3, retention_max_messages = $4
+		WHERE id = $1
+		RETURNING ` + roomColumns
+
+	room, err := scanRoom(r.db.QueryRowContext(ctx, query, id, retention.Policy, ttlSeconds, maxMessages))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return room, nil
+}
+
+// GetRooms lists all rooms
+func (r *RoomRepository) GetRooms(ctx context.Context) ([]Room, error) {
+	query := `SELECT ` + roomColumns + ` FROM rooms ORDER BY id`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []Room
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, *room)
+	}
+
+	return rooms, rows.Err()
+}
+
+// GetVisibleRooms lists the public and category rooms, and the private rooms
+// the user is a member of. A nil user sees no private rooms.
+func (r *RoomRepository) GetVisibleRooms(ctx context.Context, userID *int64) ([]Room, error) {
+	query := `
+		SELECT ` + roomColumns + `
+		FROM rooms
+		WHERE kind <> 'private'
+			OR id IN (SELECT room_id FROM room_members WHERE user_id = $1)
+		ORDER BY kind, name
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []Room
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, *room)
+	}
+
+	return rooms, rows.Err()
+}
+
+// GetMemberRole returns the role of the user in the room, or an empty string
+// if the user is not a member
+func (r *RoomRepository) GetMemberRole(ctx context.Context, roomID, userID int64) (string, error) {
+	query := `SELECT role FROM room_members WHERE room_id = $1 AND user_id = $2`
+
+	var role string
+	err := r.db.QueryRowContext(ctx, query, roomID, userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return role, nil
+}
+
+func (r *RoomRepository) GetMembers(ctx context.Context, roomID int64) ([]RoomMember, error) {
+	query := `
+		SELECT m.room_id, m.user_id, u.username, m.role, m.joined_at
+		FROM room_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.room_id = $1
+		ORDER BY m.joined_at
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []RoomMember
+	for rows.Next() {
+		var m RoomMember
+		if err := rows.Scan(&m.RoomID, &m.UserID, &m.Username, &m.Role, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+
+	return members, rows.Err()
+}
+
+// AddMember adds the user to the room. Adding an existing member keeps its role.
+func (r *RoomRepository) AddMember(ctx context.Context, roomID, userID int64, role string) error {
+	query := `
+		INSERT INTO room_members (room_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`
+	_, err := r.db.ExecContext(ctx, query, roomID, userID, role)
+	return err
+}
+
+// RemoveMember removes the user from the room and reports whether it was a member
+func (r *RoomRepository) RemoveMember(ctx context.Context, roomID, userID int64) (bool, error) {
+	query := `DELETE FROM room_members WHERE room_id = $1 AND user_id = $2`
+	result, err := r.db.ExecContext(ctx, query, roomID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
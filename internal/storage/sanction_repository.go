@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Chat sanction kinds
+const (
+	SanctionMute = "mute"
+	SanctionKick = "kick"
+	SanctionBan  = "ban"
+)
+
+// ChatSanction restricts a user in the chat. Mutes and bans are active until
+// they expire or are revoked.
+type ChatSanction struct {
+	ID        int64
+	UserID    int64
+	Username  string
+	Kind      string
+	Reason    string
+	CreatedBy *int64
+	CreatedAt time.Time
+	ExpiresAt *time.Time
+	RevokedAt *time.Time
+}
+
+const sanctionColumns = `s.id, s.user_id, u.username, s.kind, s.reason, s.created_by, s.created_at, s.expires_at, s.revoked_at`
+
+// activeSanction matches the mutes and bans in force
+const activeSanction = `s.kind <> 'kick' AND s.revoked_at IS NULL AND (s.expires_at IS NULL OR s.expires_at > CURRENT_TIMESTAMP)`
+
+type SanctionRepository struct {
+	db *DB
+}
+
+func NewSanctionRepository(db *DB) *SanctionRepository {
+	return &SanctionRepository{db: db}
+}
+
+func scanSanction(row rowScanner) (*ChatSanction, error) {
+	s := &ChatSanction{}
+	err := row.Scan(&s.ID, &s.UserID, &s.Username, &s.Kind, &s.Reason, &s.CreatedBy, &s.CreatedAt, &s.ExpiresAt, &s.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (r *SanctionRepository) CreateSanction(ctx context.Context, userID int64, kind, reason string, createdBy *int64, expiresAt *time.Time) (*ChatSanction, error) {
+	query := `
+		WITH inserted AS (
+			INSERT INTO chat_sanctions (user_id, kind, reason, created_by, expires_at)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING *
+		)
+		SELECT ` + sanctionColumns + `
+		FROM inserted s
+		JOIN users u ON u.id = s.user_id
+	`
+
+	return scanSanction(r.db.QueryRowContext(ctx, query, userID, kind, reason, createdBy, expiresAt))
+}
+
+// GetActiveSanctions lists the mutes and bans in force, of the user if
+// userID is set or of everyone otherwise
+func (r *SanctionRepository) GetActiveSanctions(ctx context.Context, userID *int64) ([]ChatSanction, error) {
+	query := `
+		SELECT ` + sanctionColumns + `
+		FROM chat_sanctions s
+		JOIN users u ON u.id = s.user_id
+		WHERE ` + activeSanction + ` AND ($1::INTEGER IS NULL OR s.user_id = $1)
+		ORDER BY s.created_at DESC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sanctions []ChatSanction
+	for rows.Next() {
+		s, err := scanSanction(rows)
+		if err != nil {
+			return nil, err
+		}
+		sanctions = append(sanctions, *s)
+	}
+
+	return sanctions, rows.Err()
+}
+
+// RevokeSanction lifts a mute or ban in force. It returns nil if there is no
+// such sanction.
+func (r *SanctionRepository) RevokeSanction(ctx context.Context, id, revokedBy int64) (*ChatSanction, error) {
+	query := `
+		WITH revoked AS (
+			UPDATE chat_sanctions s SET revoked_at = CURRENT_TIMESTAMP, revoked_by = $2
+			WHERE s.id = $1 AND ` + activeSanction + `
+			RETURNING *
+		)
+		SELECT ` + sanctionColumns + `
+		FROM revoked s
+		JOIN users u ON u.id = s.user_id
+	`
+
+	s, err := scanSanction(r.db.QueryRowContext(ctx, query, id, revokedBy))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// RevokeActiveSanctions lifts all mutes or bans in force of the user and
+// reports how many there were
+func (r *SanctionRepository) RevokeActiveSanctions(ctx context.Context, userID int64, kind string, revokedBy int64) (int64, error) {
+	query := `
+		UPDATE chat_sanctions s SET revoked_at = CURRENT_TIMESTAMP, revoked_by = $3
+		WHERE s.user_id = $1 AND s.kind = $2 AND ` + activeSanction
+
+	result, err := r.db.ExecContext(ctx, query, userID, kind, revokedBy)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
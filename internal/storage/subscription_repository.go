@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Digest frequencies
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// DigestRecipient is a user whose digest email is due
+type DigestRecipient struct {
+	UserID    int64
+	Username  string
+	Email     string
+	Frequency string
+	// DigestedUntil is the end of the activity covered by the last digest,
+	// nil before the first one
+	DigestedUntil *time.Time
+}
+
+// DigestPost is the new activity in a followed post
+type DigestPost struct {
+	PostID        int64
+	Title         string
+	Comments      int
+	LastCommentAt time.Time
+}
+
+// SubscriptionRepository stores the posts users follow and their digest
+// settings
+type SubscriptionRepository struct {
+	db *DB
+}
+
+func NewSubscriptionRepository(db *DB) *SubscriptionRepository {
+	return &SubscriptionRepository{db: db}
+}
+
+func (r *SubscriptionRepository) Subscribe(ctx context.Context, userID, postID int64) error {
+	query := `
+		INSERT INTO post_subscriptions (user_id, post_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`
+
+	_, err := r.db.ExecContext(ctx, query, userID, postID)
+	return err
+}
+
+// Unsubscribe stops following a post and reports whether the user followed it
+func (r *SubscriptionRepository) Unsubscribe(ctx context.Context, userID, postID int64) (bool, error) {
+	query := `DELETE FROM post_subscriptions WHERE user_id = $1 AND post_id = $2`
+
+	result, err := r.db.ExecContext(ctx, query, userID, postID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (r *SubscriptionRepository) IsSubscribed(ctx context.Context, userID, postID int64) (bool, error) {
+	query := `SELECT EXISTS (SELECT 1 FROM post_subscriptions WHERE user_id = $1 AND post_id = $2)`
+
+	var subscribed bool
+	if err := r.db.QueryRowContext(ctx, query, userID, postID).Scan(&subscribed); err != nil {
+		return false, err
+	}
+	return subscribed, nil
+}
+
+func (r *SubscriptionRepository) GetDigestFrequency(ctx context.Context, userID int64) (string, error) {
+	query := `
+		SELECT COALESCE((SELECT frequency FROM email_digests WHERE user_id = $1), $2)
+	`
+
+	var frequency string
+	if err := r.db.QueryRowContext(ctx, query, userID, DigestDaily).Scan(&frequency); err != nil {
+		return "", err
+	}
+	return frequency, nil
+}
+
+func (r *SubscriptionRepository) SetDigestFrequency(ctx context.Context, userID int64, frequency string) error {
+	query := `
+		INSERT INTO email_digests (user_id, frequency)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET frequency = EXCLUDED.frequency
+	`
+
+	_, err := r.db.ExecContext(ctx, query, userID, frequency)
+	return err
+}
+
+// GetDueDigests returns up to limit users after afterID, by ID, that follow
+// posts and whose last digest is at least a period old at now. Only verified
+// addresses get digests.
+func (r *SubscriptionRepository) GetDueDigests(ctx context.Context, now time.Time, afterID int64, limit int) ([]DigestRecipient, error) {
+	query := `
+		SELECT u.id, u.username, u.email, COALESCE(d.frequency, 'daily'), d.digested_until
+		FROM users u
+		LEFT JOIN email_digests d ON d.user_id = u.id
+		WHERE u.id > $2 AND u.email_verified
+			AND COALESCE(d.frequency, 'daily') <> 'off'
+			AND EXISTS (SELECT 1 FROM post_subscriptions s WHERE s.user_id = u.id)
+			AND (d.digested_until IS NULL OR d.digested_until <= $1::TIMESTAMPTZ -
+				CASE d.frequency WHEN 'weekly' THEN INTERVAL '7 days' ELSE INTERVAL '1 day' END)
+		ORDER BY u.id
+		LIMIT $3
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, now, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []DigestRecipient
+	for rows.Next() {
+		var d DigestRecipient
+		if err := rows.Scan(&d.UserID, &d.Username, &d.Email, &d.Frequency, &d.DigestedUntil); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, d)
+	}
+
+	return recipients, rows.Err()
+}
+
+// GetDigestActivity returns the followed posts of the user with comments of
+// others in (since, until], most recently active first. Comments older than
+// the subscription are left out.
+func (r *SubscriptionRepository) GetDigestActivity(ctx context.Context, userID int64, since, until time.Time) ([]DigestPost, error) {
+	query := `
+		SELECT p.id, p.title, COUNT(c.id), MAX(c.created_at)
+		FROM post_subscriptions s
+		JOIN posts p ON p.id = s.post_id
+		JOIN comments c ON c.post_id = s.post_id
+		WHERE s.user_id = $1 AND c.user_id <> $1
+			AND c.created_at > GREATEST($2::TIMESTAMPTZ, s.created_at) AND c.created_at <= $3
+		GROUP BY p.id, p.title
+		ORDER BY MAX(c.created_at) DESC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID, since, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []DigestPost
+	for rows.Next() {
+		var p DigestPost
+		if err := rows.Scan(&p.PostID, &p.Title, &p.Comments, &p.LastCommentAt); err != nil {
+			return nil, err
+		}
+		posts = append(posts, p)
+	}
+
+	return posts, rows.Err()
+}
+
+// ClaimDigest moves the digest of the user from previous to until and
+// reports whether it did. Only one instance wins the claim of a digest.
+func (r *SubscriptionRepository) ClaimDigest(ctx context.Context, userID int64, previous *time.Time, until time.Time) (bool, error) {
+	query := `
+		INSERT INTO email_digests (user_id, digested_until)
+		VALUES ($1, $3)
+		ON CONFLICT (user_id) DO UPDATE SET digested_until = EXCLUDED.digested_until
+		WHERE email_digests.digested_until IS NOT DISTINCT FROM $2
+	`
+
+	result, err := r.db.ExecContext(ctx, query, userID, previous, until)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+)
+
+// ErrTokenInvalid is returned when a one-time token is unknown, used or expired
+var ErrTokenInvalid = errors.New("token is invalid or expired")
+
+// UserToken is a hashed one-time token issued to a user
+type UserToken struct {
+	ID        int64
+	UserID    int64
+	Purpose   string
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+type TokenRepository struct {
+	db *DB
+}
+
+func NewTokenRepository(db *DB) *TokenRepository {
+	return &TokenRepository{db: db}
+}
+
+// CreateToken stores a new token hash. Earlier unused tokens with the same
+// purpose are invalidated so that only the latest link works.
+func (r *TokenRepository) CreateToken(ctx context.Context, userID int64, purpose, tokenHash string, expiresAt time.Time) error {
+	invalidate := `
+		UPDATE user_tokens SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL
+	`
+	if _, err := r.db.ExecContext(ctx, invalidate, userID, purpose); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO user_tokens (user_id, purpose, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err := r.db.ExecContext(ctx, query, userID, purpose, tokenHash, expiresAt)
+	return err
+}
+
+// GetActiveToken returns an unused, unexpired token without consuming it
+func (r *TokenRepository) GetActiveToken(ctx context.Context, purpose, tokenHash string) (*UserToken, error) {
+	query := `
+		SELECT id, user_id, purpose, token_hash, expires_at, used_at, created_at
+		FROM user_tokens
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+	`
+
+	token := &UserToken{}
+	err := r.db.QueryRowContext(ctx, query, tokenHash, purpose).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Purpose,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// ConsumeToken marks a valid token as used and returns it. A token can be consumed only once.
+func (r *TokenRepository) ConsumeToken(ctx context.Context, purpose, tokenHash string) (*UserToken, error) {
+	query := `
+		UPDATE user_tokens SET used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		RETURNING id, user_id, purpose, token_hash, expires_at, used_at, created_at
+	`
+
+	token := &UserToken{}
+	err := r.db.QueryRowContext(ctx, query, tokenHash, purpose).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Purpose,
+		&token.TokenHash,
+		&token.ExpiresAt,
+		&token.UsedAt,
+		&token.CreatedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// DeleteExpiredTokens removes tokens that expired before the given time
+func (r *TokenRepository) DeleteExpiredTokens(ctx context.Context, before time.Time) error {
+	query := `DELETE FROM user_tokens WHERE expires_at < $1`
+	_, err := r.db.ExecContext(ctx, query, before)
+	return err
+}
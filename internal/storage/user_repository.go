@@ -2,21 +2,76 @@ package storage
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"time"
 
-	"github.com/jackc/pgx/v5"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/Ryan-Gosusluging/forum/internal/events"
+	"github.com/lib/pq"
 )
 
+// ErrUserNotFound is returned by the lookups and updates of a missing user
+var ErrUserNotFound = errors.New("user not found")
+
 type User struct {
-	ID           int64
-	Username     string
-	Email        string
-	PasswordHash string
-	Role         string
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID            int64
+	Username      string
+	Email         string
+	PasswordHash  string
+	Role          string
+	DisplayName   string
+	Bio           string
+	Location      string
+	Website       string
+	Signature     string
+	AvatarURL     string
+	EmailVerified bool
+	TokenVersion  int
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// ProfileUpdate holds the editable profile fields. Nil fields are left unchanged.
+type ProfileUpdate struct {
+	DisplayName *string
+	Bio         *string
+	Location    *string
+	Website     *string
+	Signature   *string
+	AvatarURL   *string
+}
+
+const userColumns = `id, username, email, password_hash, role,
+		display_name, bio, location, website, signature, avatar_url,
+		email_verified, token_version, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner) (*User, error) {
+	user := &User{}
+	err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.PasswordHash,
+		&user.Role,
+		&user.DisplayName,
+		&user.Bio,
+		&user.Location,
+		&user.Website,
+		&user.Signature,
+		&user.AvatarURL,
+		&user.EmailVerified,
+		&user.TokenVersion,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
 type UserRepository struct {
@@ -27,44 +82,55 @@ func NewUserRepository(db *DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
-func (r *UserRepository) CreateUser(ctx context.Context, username, email, password string) (*User, error) {
-	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// CreateUser stores a new user and its user.registered event. The password
+// must already be hashed.
+func (r *UserRepository) CreateUser(ctx context.Context, username, email, passwordHash string) (*User, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
 	query := `
 		INSERT INTO users (username, email, password_hash, role)
 		VALUES ($1, $2, $3, 'user')
-		RETURNING id, username, email, password_hash, role, created_at, updated_at
-	`
-
-	user := &User{}
-	err = r.db.QueryRowContext(ctx, query, username, email, string(hashedPassword)).
-		Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.Role, &user.CreatedAt, &user.UpdatedAt)
+		RETURNING ` + userColumns
 
+	user, err := scanUser(tx.QueryRowContext(ctx, query, username, email, passwordHash))
 	if err != nil {
 		return nil, err
 	}
 
+	if err := insertEvent(ctx, tx, events.UserRegistered, userRegisteredData(user)); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
 	return user, nil
 }
 
+func userRegisteredData(user *User) events.UserRegisteredData {
+	return events.UserRegisteredData{
+		ID:        user.ID,
+		Username:  user.Username,
+		CreatedAt: user.CreatedAt,
+	}
+}
+
 func (r *UserRepository) GetUserByID(ctx context.Context, id int64) (*User, error) {
 	query := `
-		SELECT id, username, email, password_hash, role, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE id = $1
 	`
 
-	user := &User{}
-	err := r.db.QueryRowContext(ctx, query, id).
-		Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.Role, &user.CreatedAt, &user.UpdatedAt)
-
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, id))
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, errors.New("user not found")
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -74,18 +140,100 @@ func (r *UserRepository) GetUserByID(ctx context.Context, id int64) (*User, erro
 
 func (r *UserRepository) GetUserByUsername(ctx context.Context, username string) (*User, error) {
 	query := `
-		SELECT id, username, email, password_hash, role, created_at, updated_at
+		SELECT ` + userColumns + `
 		FROM users
 		WHERE username = $1
 	`
 
-	user := &User{}
-	err := r.db.QueryRowContext(ctx, query, username).
-		Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.Role, &user.CreatedAt, &user.UpdatedAt)
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, username))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r *UserRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE LOWER(email) = LOWER($1)
+	`
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, email))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// GetUsersByIDs returns the users with the given IDs. Unknown IDs are skipped.
+func (r *UserRepository) GetUsersByIDs(ctx context.Context, ids []int64) ([]*User, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
 
+	query := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE id = ANY($1)
+		ORDER BY id
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(ids))
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return nil, errors.New("user not found")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+// UpdateProfile applies the non-nil fields of update and returns the updated user.
+func (r *UserRepository) UpdateProfile(ctx context.Context, id int64, update ProfileUpdate) (*User, error) {
+	query := `
+		UPDATE users SET
+			display_name = COALESCE($2, display_name),
+			bio = COALESCE($3, bio),
+			location = COALESCE($4, location),
+			website = COALESCE($5, website),
+			signature = COALESCE($6, signature),
+			avatar_url = COALESCE($7, avatar_url),
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING ` + userColumns
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, id,
+		update.DisplayName,
+		update.Bio,
+		update.Location,
+		update.Website,
+		update.Signature,
+		update.AvatarURL,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -93,6 +241,50 @@ func (r *UserRepository) GetUserByUsername(ctx context.Context, username string)
 	return user, nil
 }
 
-func (r *UserRepository) VerifyPassword(hashedPassword, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+// UpdatePassword replaces the password hash and bumps the token version,
+// which revokes every session issued before the change.
+func (r *UserRepository) UpdatePassword(ctx context.Context, id int64, passwordHash string) (*User, error) {
+	query := `
+		UPDATE users SET
+			password_hash = $2,
+			token_version = token_version + 1,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING ` + userColumns
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, id, passwordHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, id int64) error {
+	query := `UPDATE users SET email_verified = TRUE, updated_at = CURRENT_TIMESTAMP WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// GetTokenVersion returns the current token version of a user
+func (r *UserRepository) GetTokenVersion(ctx context.Context, id int64) (int, error) {
+	query := `SELECT token_version FROM users WHERE id = $1`
+
+	var version int
+	if err := r.db.QueryRowContext(ctx, query, id).Scan(&version); err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+// UpdatePasswordHash stores an upgraded hash of the same password. Sessions
+// stay valid, unlike UpdatePassword.
+func (r *UserRepository) UpdatePasswordHash(ctx context.Context, id int64, passwordHash string) error {
+	query := `UPDATE users SET password_hash = $2 WHERE id = $1`
+	_, err := r.db.ExecContext(ctx, query, id, passwordHash)
+	return err
 }
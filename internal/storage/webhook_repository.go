@@ -0,0 +1,400 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/events"
+	"github.com/lib/pq"
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// WebhookEndpoint is a URL events are posted to. An endpoint without events
+// receives all of them.
+type WebhookEndpoint struct {
+	ID        int64
+	URL       string
+	Secret    string
+	Events    []string
+	Active    bool
+	CreatedBy *int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// WebhookEvent is the body of every delivery
+type WebhookEvent struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// WebhookDelivery is an event queued for one endpoint
+type WebhookDelivery struct {
+	ID             int64
+	EndpointID     int64
+	EventID        string
+	EventType      string
+	Payload        json.RawMessage
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastStatusCode *int
+	LastError      string
+	CreatedAt      time.Time
+	DeliveredAt    *time.Time
+}
+
+// WebhookAttempt is one try to deliver a delivery. StatusCode is nil if no
+// response was received.
+type WebhookAttempt struct {
+	ID         int64
+	DeliveryID int64
+	StatusCode *int
+	Error      string
+	DurationMs int
+	CreatedAt  time.Time
+}
+
+const webhookEndpointColumns = `id, url, secret, events, active, created_by, created_at, updated_at`
+
+const webhookDeliveryColumns = `id, endpoint_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, delivered_at`
+
+type WebhookRepository struct {
+	db *DB
+}
+
+func NewWebhookRepository(db *DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+func scanWebhookEndpoint(row rowScanner) (*WebhookEndpoint, error) {
+	e := &WebhookEndpoint{}
+	err := row.Scan(
+		&e.ID, &e.URL, &e.Secret, pq.Array(&e.Events), &e.Active,
+		&e.CreatedBy, &e.CreatedAt, &e.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func scanWebhookDelivery(row rowScanner) (*WebhookDelivery, error) {
+	d := &WebhookDelivery{}
+	var payload []byte
+	err := row.Scan(
+		&d.ID, &d.EndpointID, &d.EventID, &d.EventType, &payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	d.Payload = payload
+	return d, nil
+}
+
+func (r *WebhookRepository) CreateEndpoint(ctx context.Context, url, secret string, events []string, active bool, createdBy int64) (*WebhookEndpoint, error) {
+	query := `
+		INSERT INTO webhook_endpoints (url, secret, events, active, created_by)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + webhookEndpointColumns
+
+	return scanWebhookEndpoint(r.db.QueryRowContext(ctx, query, url, secret, pq.Array(events), active, createdBy))
+}
+
+// GetEndpoint returns the endpoint, or nil if it does not exist
+func (r *WebhookRepository) GetEndpoint(ctx context.Context, id int64) (*WebhookEndpoint, error) {
+	query := `SELECT ` + webhookEndpointColumns + ` FROM webhook_endpoints WHERE id = $1`
+
+	endpoint, err := scanWebhookEndpoint(r.db.QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return endpoint, err
+}
+
+func (r *WebhookRepository) GetEndpoints(ctx context.Context) ([]WebhookEndpoint, error) {
+	query := `SELECT ` + webhookEndpointColumns + ` FROM webhook_endpoints ORDER BY id`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var endpoints []WebhookEndpoint
+	for rows.Next() {
+		endpoint, err := scanWebhookEndpoint(rows)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, *endpoint)
+	}
+
+	return endpoints, rows.Err()
+}
+
+// UpdateEndpoint changes the URL, events and active flag of the endpoint and
+// returns it, or nil if it does not exist
+func (r *WebhookRepository) UpdateEndpoint(ctx context.Context, id int64, url string, events []string, active bool) (*WebhookEndpoint, error) {
+	query := `
+		UPDATE webhook_endpoints
+		SET url = $2, events = $3, active = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+		RETURNING ` + webhookEndpointColumns
+
+	endpoint, err := scanWebhookEndpoint(r.db.QueryRowContext(ctx, query, id, url, pq.Array(events), active))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return endpoint, err
+}
+
+// DeleteEndpoint deletes the endpoint with its deliveries and reports whether
+// it existed
+func (r *WebhookRepository) DeleteEndpoint(ctx context.Context, id int64) (bool, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM webhook_endpoints WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// EnqueueEvent queues a delivery of the event for every active endpoint that
+// subscribes to it. An event is queued once per endpoint however often it
+// is enqueued.
+func (r *WebhookRepository) EnqueueEvent(ctx context.Context, e *events.Event) error {
+	payload, err := json.Marshal(WebhookEvent{
+		ID:        e.ID,
+		Type:      e.Type,
+		CreatedAt: e.OccurredAt,
+		Data:      e.Payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO webhook_deliveries (endpoint_id, event_id, event_type, payload)
+		SELECT id, $1, $2, $3
+		FROM webhook_endpoints
+		WHERE active AND (cardinality(events) = 0 OR $2 = ANY(events))
+		ON CONFLICT (endpoint_id, event_id) DO NOTHING
+	`
+
+	_, err = r.db.ExecContext(ctx, query, e.ID, e.Type, payload)
+	return err
+}
+
+// ClaimDueDeliveries returns up to limit pending deliveries that are due,
+// with their endpoints, and leases them until leaseUntil so that concurrent
+// dispatchers skip them. A delivery whose dispatcher dies is retried once the
+// lease ends.
+func (r *WebhookRepository) ClaimDueDeliveries(ctx context.Context, limit int, leaseUntil time.Time) ([]WebhookDelivery, map[int64]*WebhookEndpoint, error) {
+	query := `
+		WITH due AS (
+			SELECT d.id
+			FROM webhook_deliveries d
+			JOIN webhook_endpoints e ON e.id = d.endpoint_id
+			WHERE d.status = 'pending' AND d.next_attempt_at <= NOW() AND e.active
+			ORDER BY d.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		UPDATE webhook_deliveries SET next_attempt_at = $2
+		FROM due
+		WHERE webhook_deliveries.id = due.id
+		RETURNING webhook_deliveries.*
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, limit, leaseUntil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	var endpointIDs []int64
+	seen := make(map[int64]bool)
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+		if !seen[delivery.EndpointID] {
+			seen[delivery.EndpointID] = true
+			endpointIDs = append(endpointIDs, delivery.EndpointID)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(deliveries) == 0 {
+		return nil, nil, nil
+	}
+
+	endpointRows, err := r.db.QueryContext(ctx,
+		`SELECT `+webhookEndpointColumns+` FROM webhook_endpoints WHERE id = ANY($1)`, pq.Array(endpointIDs))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer endpointRows.Close()
+
+	endpoints := make(map[int64]*WebhookEndpoint, len(endpointIDs))
+	for endpointRows.Next() {
+		endpoint, err := scanWebhookEndpoint(endpointRows)
+		if err != nil {
+			return nil, nil, err
+		}
+		endpoints[endpoint.ID] = endpoint
+	}
+
+	return deliveries, endpoints, endpointRows.Err()
+}
+
+// RecordAttempt logs an attempt of the delivery and moves it on: a delivered
+// delivery is done, a failed one is retried at nextAttemptAt or, if that is
+// nil, given up on.
+func (r *WebhookRepository) RecordAttempt(ctx context.Context, deliveryID int64, statusCode *int, errMsg string, duration time.Duration, delivered bool, nextAttemptAt *time.Time) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO webhook_delivery_attempts (delivery_id, status_code, error, duration_ms)
+		VALUES ($1, $2, $3, $4)
+	`, deliveryID, statusCode, errMsg, int(duration/time.Millisecond))
+	if err != nil {
+		return err
+	}
+
+	status := DeliveryPending
+	switch {
+	case delivered:
+		status = DeliveryDelivered
+	case nextAttemptAt == nil:
+		status = DeliveryDead
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $2,
+			attempts = attempts + 1,
+			next_attempt_at = COALESCE($3, next_attempt_at),
+			last_status_code = $4,
+			last_error = $5,
+			delivered_at = CASE WHEN $6 THEN CURRENT_TIMESTAMP ELSE delivered_at END
+		WHERE id = $1
+	`, deliveryID, status, nextAttemptAt, statusCode, errMsg, delivered)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetDeliveries pages through the deliveries of the endpoint, newest first.
+// beforeID is the ID of the last delivery of the previous page, or zero; an
+// empty status lists all of them.
+func (r *WebhookRepository) GetDeliveries(ctx context.Context, endpointID int64, status string, beforeID int64, limit int) ([]WebhookDelivery, error) {
+	query := `
+		SELECT ` + webhookDeliveryColumns + `
+		FROM webhook_deliveries
+		WHERE endpoint_id = $1
+			AND ($2 = '' OR status = $2)
+			AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC
+		LIMIT $4
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, endpointID, status, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// GetDelivery returns the delivery of the endpoint, or nil if there is none
+func (r *WebhookRepository) GetDelivery(ctx context.Context, endpointID, id int64) (*WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE id = $1 AND endpoint_id = $2`
+
+	delivery, err := scanWebhookDelivery(r.db.QueryRowContext(ctx, query, id, endpointID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return delivery, err
+}
+
+// GetAttempts returns the attempts of the deliveries, oldest first, by delivery
+func (r *WebhookRepository) GetAttempts(ctx context.Context, deliveryIDs []int64) (map[int64][]WebhookAttempt, error) {
+	attempts := make(map[int64][]WebhookAttempt)
+	if len(deliveryIDs) == 0 {
+		return attempts, nil
+	}
+
+	query := `
+		SELECT id, delivery_id, status_code, error, duration_ms, created_at
+		FROM webhook_delivery_attempts
+		WHERE delivery_id = ANY($1)
+		ORDER BY id
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, pq.Array(deliveryIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a WebhookAttempt
+		if err := rows.Scan(&a.ID, &a.DeliveryID, &a.StatusCode, &a.Error, &a.DurationMs, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		attempts[a.DeliveryID] = append(attempts[a.DeliveryID], a)
+	}
+
+	return attempts, rows.Err()
+}
+
+// RetryDelivery queues a dead delivery of the endpoint again with a fresh
+// attempt budget and reports whether there was one
+func (r *WebhookRepository) RetryDelivery(ctx context.Context, endpointID, id int64) (bool, error) {
+	query := `
+		UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND endpoint_id = $2 AND status = 'dead'
+	`
+
+	result, err := r.db.ExecContext(ctx, query, id, endpointID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
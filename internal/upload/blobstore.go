@@ -0,0 +1,44 @@
+package upload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Ryan-Gosusluging/forum/pkg/config"
+)
+
+// ErrBlobNotFound is returned when a blob does not exist in the store
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobInfo describes a stored blob
+type BlobInfo struct {
+	ContentType string
+	Size        int64
+}
+
+// BlobStore stores uploaded files by key
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, *BlobInfo, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// NewBlobStore creates the blob store selected by cfg.BlobStore
+func NewBlobStore(cfg *config.Config) (BlobStore, error) {
+	switch cfg.BlobStore {
+	case "local":
+		return NewLocalStore(cfg.UploadDir)
+	case "s3":
+		return NewS3Store(S3Options{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+		})
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", cfg.BlobStore)
+	}
+}
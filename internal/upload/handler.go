@@ -0,0 +1,484 @@
+package upload
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+	"github.com/Ryan-Gosusluging/forum/pkg/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// multipartOverhead leaves room for form fields and boundaries on top of the file size limit
+const multipartOverhead = 64 << 10
+
+type AttachmentResponse struct {
+	ID           int64  `json:"id"`
+	UserID       int64  `json:"user_id"`
+	PostID       *int64 `json:"post_id,omitempty"`
+	CommentID    *int64 `json:"comment_id,omitempty"`
+	Purpose      string `json:"purpose"`
+	OriginalName string `json:"original_name"`
+	ContentType  string `json:"content_type"`
+	SizeBytes    int64  `json:"size_bytes"`
+	Width        *int   `json:"width,omitempty"`
+	Height       *int   `json:"height,omitempty"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// Handler serves /api/uploads and /api/attachments
+type Handler struct {
+	service     *Service
+	repo        *storage.AttachmentRepository
+	postRepo    *storage.PostRepository
+	commentRepo storage.CommentRepository
+	auth        *auth.Service
+	authClient  proto.AuthServiceClient
+}
+
+func NewHandler(service *Service, repo *storage.AttachmentRepository, postRepo *storage.PostRepository, commentRepo storage.CommentRepository, auth *auth.Service, authClient proto.AuthServiceClient) *Handler {
+	return &Handler{
+		service:     service,
+		repo:        repo,
+		postRepo:    postRepo,
+		commentRepo: commentRepo,
+		auth:        auth,
+		authClient:  authClient,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/api/uploads" && r.Method == http.MethodPost:
+		h.handleUpload(w, r)
+	case r.URL.Path == "/api/attachments" && r.Method == http.MethodGet:
+		h.handleListAttachments(w, r)
+	case strings.HasPrefix(r.URL.Path, "/api/attachments/") && r.Method == http.MethodGet:
+		h.handleGetAttachment(w, r)
+	case strings.HasPrefix(r.URL.Path, "/api/attachments/") && r.Method == http.MethodDelete:
+		h.handleDeleteAttachment(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) handleUpload(w http.ResponseWriter, r *http.Request) {
+	claims, err := h.auth.Authenticate(r, "")
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	userID := claims.UserId
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.service.maxBytes+multipartOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Expected multipart/form-data", http.StatusBadRequest)
+		return
+	}
+
+	req := UploadRequest{UserID: userID, Purpose: storage.AttachmentPurposeFile}
+	ctx := r.Context()
+
+	// Form fields must precede the file part so that the target can be checked before storing
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			http.Error(w, "Missing file", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Invalid multipart body", http.StatusBadRequest)
+			return
+		}
+
+		if part.FormName() == "file" {
+			req.OriginalName = part.FileName()
+			req.Body = part
+			break
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, 64))
+		if err != nil {
+			http.Error(w, "Invalid multipart body", http.StatusBadRequest)
+			return
+		}
+		if err := setUploadField(&req, part.FormName(), string(value)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	scope := auth.ScopePostsWrite
+	if req.Purpose == storage.AttachmentPurposeAvatar {
+		scope = auth.ScopeProfileWrite
+	}
+	if !auth.HasScope(claims, scope) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if status, msg := h.checkTarget(r, &req); status != http.StatusOK {
+		http.Error(w, msg, status)
+		return
+	}
+
+	attachment, err := h.service.Upload(ctx, req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		case errors.Is(err, ErrUnsupportedType):
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		default:
+			logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to store upload")
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if attachment.Purpose == storage.AttachmentPurposeAvatar {
+		avatarURL := "/api/attachments/" + strconv.FormatInt(attachment.ID, 10)
+		_, err := h.authClient.UpdateProfile(ctx, &proto.UpdateProfileRequest{
+			Token:     auth.TokenFromRequest(r),
+			AvatarUrl: &avatarURL,
+		})
+		if err != nil {
+			logger.Error().Err(err).Int64("user_id", userID).Msg("Failed to set avatar")
+			if err := h.service.Delete(ctx, attachment); err != nil {
+				logger.Error().Err(err).Int64("attachment_id", attachment.ID).Msg("Failed to delete attachment")
+			}
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.deleteOldAvatars(r, attachment)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(h.toResponse(attachment)); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
+
+// deleteOldAvatars removes the avatars the user had before the current one,
+// records and blobs. Failures are only logged, the upload itself succeeded.
+func (h *Handler) deleteOldAvatars(r *http.Request, current *storage.Attachment) {
+	ctx := r.Context()
+	avatars, err := h.repo.GetAvatarsByUserID(ctx, current.UserID)
+	if err != nil {
+		logger.Error().Err(err).Int64("user_id", current.UserID).Msg("Failed to get old avatars")
+		return
+	}
+
+	for _, avatar := range avatars {
+		if avatar.ID == current.ID {
+			continue
+		}
+		if err := h.service.Delete(ctx, avatar); err != nil {
+			logger.Error().Err(err).Int64("attachment_id", avatar.ID).Msg("Failed to delete old avatar")
+		}
+	}
+}
+
+func setUploadField(req *UploadRequest, name, value string) error {
+	switch name {
+	case "purpose":
+		if value != storage.AttachmentPurposeFile && value != storage.AttachmentPurposeAvatar {
+			return errors.New("Invalid purpose")
+		}
+		req.Purpose = value
+	case "post_id":
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return errors.New("Invalid post ID")
+		}
+		req.PostID = &id
+	case "comment_id":
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return errors.New("Invalid comment ID")
+		}
+		req.CommentID = &id
+	}
+	return nil
+}
+
+// checkTarget verifies that the uploader owns the post or comment the file is attached to
+func (h *Handler) checkTarget(r *http.Request, req *UploadRequest) (int, string) {
+	ctx := r.Context()
+
+	if req.Purpose == storage.AttachmentPurposeAvatar {
+		if req.PostID != nil || req.CommentID != nil {
+			return http.StatusBadRequest, "Avatars cannot be attached to posts or comments"
+		}
+		return http.StatusOK, ""
+	}
+
+	switch {
+	case req.PostID != nil && req.CommentID != nil:
+		return http.StatusBadRequest, "Specify either post_id or comment_id"
+	case req.PostID != nil:
+		post, err := h.postRepo.GetPostByID(ctx, *req.PostID)
+		if err != nil {
+			return http.StatusNotFound, "Post not found"
+		}
+		if post.UserID != req.UserID {
+			return http.StatusForbidden, "Forbidden"
+		}
+	case req.CommentID != nil:
+		comment, err := h.commentRepo.GetCommentByID(ctx, *req.CommentID)
+		if err != nil || comment == nil {
+			return http.StatusNotFound, "Comment not found"
+		}
+		if comment.UserID != req.UserID {
+			return http.StatusForbidden, "Forbidden"
+		}
+	default:
+		return http.StatusBadRequest, "post_id or comment_id is required"
+	}
+
+	return http.StatusOK, ""
+}
+
+func (h *Handler) handleListAttachments(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var (
+		attachments []*storage.Attachment
+		err         error
+	)
+
+	if postIDStr := r.URL.Query().Get("post_id"); postIDStr != "" {
+		postID, parseErr := strconv.ParseInt(postIDStr, 10, 64)
+		if parseErr != nil {
+			http.Error(w, "Invalid post ID", http.StatusBadRequest)
+			return
+		}
+		attachments, err = h.repo.GetAttachmentsByPostID(ctx, postID)
+	} else if commentIDStr := r.URL.Query().Get("comment_id"); commentIDStr != "" {
+		commentID, parseErr := strconv.ParseInt(commentIDStr, 10, 64)
+		if parseErr != nil {
+			http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+			return
+		}
+		attachments, err = h.repo.GetAttachmentsByCommentID(ctx, commentID)
+	} else {
+		http.Error(w, "post_id or comment_id is required", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get attachments")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]AttachmentResponse, len(attachments))
+	for i, attachment := range attachments {
+		response[i] = h.toResponse(attachment)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
+
+// handleGetAttachment redirects to a freshly signed URL, so stable links such
+// as avatar URLs keep working after earlier signatures expire
+func (h *Handler) handleGetAttachment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/attachments/"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+		return
+	}
+
+	attachment, err := h.repo.GetAttachmentByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+
+	visible, err := h.canView(r, attachment)
+	if err != nil {
+		logger.Error().Err(err).Int64("attachment_id", id).Msg("Failed to check attachment visibility")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !visible {
+		// Not found rather than forbidden, so IDs cannot be probed
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+
+	target := h.service.SignedURL(attachment)
+	if r.URL.Query().Get("thumbnail") == "1" && attachment.ThumbnailKey != nil {
+		target = h.service.SignedThumbnailURL(attachment)
+	}
+
+	w.Header().Set("Cache-Control", "private, max-age=60")
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// canView reports whether the caller may see the attachment. The uploader
+// always may; anyone else only while it is attached to an existing post or
+// comment, or is the current avatar of its owner.
+func (h *Handler) canView(r *http.Request, attachment *storage.Attachment) (bool, error) {
+	if claims, err := h.auth.Authenticate(r, ""); err == nil && claims.UserId == attachment.UserID {
+		return true, nil
+	}
+
+	ctx := r.Context()
+	switch {
+	case attachment.Purpose == storage.AttachmentPurposeAvatar:
+		resp, err := h.authClient.GetUser(ctx, &proto.GetUserRequest{UserId: attachment.UserID})
+		if err != nil {
+			if status.Code(err) == codes.NotFound {
+				return false, nil
+			}
+			return false, err
+		}
+		return resp.User.GetAvatarUrl() == "/api/attachments/"+strconv.FormatInt(attachment.ID, 10), nil
+	case attachment.PostID != nil:
+		// GetPostByID fails for missing posts
+		_, err := h.postRepo.GetPostByID(ctx, *attachment.PostID)
+		return err == nil, nil
+	case attachment.CommentID != nil:
+		comment, err := h.commentRepo.GetCommentByID(ctx, *attachment.CommentID)
+		if err != nil {
+			return false, err
+		}
+		return comment != nil, nil
+	}
+
+	return false, nil
+}
+
+func (h *Handler) handleDeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	claims, err := h.auth.Authenticate(r, auth.ScopePostsWrite)
+	if err != nil {
+		authError(w, err)
+		return
+	}
+	userID := claims.UserId
+
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/attachments/"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid attachment ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	attachment, err := h.repo.GetAttachmentByID(ctx, id)
+	if err != nil {
+		http.Error(w, "Attachment not found", http.StatusNotFound)
+		return
+	}
+
+	if attachment.UserID != userID {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := h.service.Delete(ctx, attachment); err != nil {
+		logger.Error().Err(err).Int64("attachment_id", id).Msg("Failed to delete attachment")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) toResponse(attachment *storage.Attachment) AttachmentResponse {
+	return AttachmentResponse{
+		ID:           attachment.ID,
+		UserID:       attachment.UserID,
+		PostID:       attachment.PostID,
+		CommentID:    attachment.CommentID,
+		Purpose:      attachment.Purpose,
+		OriginalName: attachment.OriginalName,
+		ContentType:  attachment.ContentType,
+		SizeBytes:    attachment.SizeBytes,
+		Width:        attachment.Width,
+		Height:       attachment.Height,
+		URL:          h.service.SignedURL(attachment),
+		ThumbnailURL: h.service.SignedThumbnailURL(attachment),
+		CreatedAt:    attachment.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// FileHandler serves blobs under /files/ to holders of a valid signed URL
+type FileHandler struct {
+	store  BlobStore
+	signer *URLSigner
+}
+
+func NewFileHandler(store BlobStore, signer *URLSigner) *FileHandler {
+	return &FileHandler{
+		store:  store,
+		signer: signer,
+	}
+}
+
+func (h *FileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, FilesPrefix)
+	query := r.URL.Query()
+	if key == "" || !h.signer.Verify(key, query.Get("expires"), query.Get("sig")) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	body, info, err := h.store.Get(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, ErrBlobNotFound) {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		logger.Error().Err(err).Str("key", key).Msg("Failed to read blob")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", info.ContentType)
+	if info.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	if !strings.HasPrefix(info.ContentType, "image/") {
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		logger.Debug().Err(err).Str("key", key).Msg("Failed to stream blob")
+	}
+}
+
+// authError writes the response for a failed Authenticate call
+func authError(w http.ResponseWriter, err error) {
+	if errors.Is(err, auth.ErrMissingScope) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
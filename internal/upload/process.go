@@ -0,0 +1,387 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	maxImagePixels  = 40_000_000
+	thumbnailSize   = 320
+	avatarSize      = 256
+	avatarThumbSize = 64
+	jpegQuality     = 88
+	sniffLength     = 512
+)
+
+var (
+	// ErrTooLarge is returned when an upload exceeds the configured size limit
+	ErrTooLarge = errors.New("file is too large")
+	// ErrUnsupportedType is returned for content types that may not be uploaded
+	ErrUnsupportedType = errors.New("unsupported file type")
+)
+
+// allowedTypes maps the sniffed content types accepted for uploads to a file extension
+var allowedTypes = map[string]string{
+	"image/jpeg":                ".jpg",
+	"image/png":                 ".png",
+	"image/gif":                 ".gif",
+	"application/pdf":           ".pdf",
+	"application/zip":           ".zip",
+	"text/plain; charset=utf-8": ".txt",
+}
+
+// Processed is the result of preparing an upload for storage
+type Processed struct {
+	Data          []byte
+	ContentType   string
+	Extension     string
+	Width         int
+	Height        int
+	Thumbnail     []byte
+	ThumbnailType string
+}
+
+// IsImage reports whether the processed file is an image
+func (p *Processed) IsImage() bool {
+	return p.Width > 0 && p.Height > 0
+}
+
+// sniff detects the content type from the file contents and checks it against the allow list
+func sniff(data []byte) (string, string, error) {
+	head := data
+	if len(head) > sniffLength {
+		head = head[:sniffLength]
+	}
+
+	contentType := http.DetectContentType(head)
+	ext, ok := allowedTypes[contentType]
+	if !ok {
+		return "", "", fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+	return contentType, ext, nil
+}
+
+// processFile validates an attachment and re-encodes it if it is an image.
+// Re-encoding drops all metadata, including EXIF blocks with location data.
+func processFile(data []byte) (*Processed, error) {
+	contentType, ext, err := sniff(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isImageType(contentType) {
+		return &Processed{Data: data, ContentType: contentType, Extension: ext}, nil
+	}
+
+	img, err := decodeImage(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	out, outType, outExt, err := encodeImage(img, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	thumb, err := encodeJPEG(fit(img, thumbnailSize))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	return &Processed{
+		Data:          out,
+		ContentType:   outType,
+		Extension:     outExt,
+		Width:         bounds.Dx(),
+		Height:        bounds.Dy(),
+		Thumbnail:     thumb,
+		ThumbnailType: "image/jpeg",
+	}, nil
+}
+
+// processAvatar accepts only images and produces a square avatar with a small thumbnail
+func processAvatar(data []byte) (*Processed, error) {
+	contentType, _, err := sniff(data)
+	if err != nil {
+		return nil, err
+	}
+	if !isImageType(contentType) {
+		return nil, fmt.Errorf("%w: avatars must be images", ErrUnsupportedType)
+	}
+
+	img, err := decodeImage(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	square := resize(cropSquare(img), avatarSize, avatarSize)
+	out, err := encodePNG(square)
+	if err != nil {
+		return nil, err
+	}
+
+	thumb, err := encodePNG(resize(square, avatarThumbSize, avatarThumbSize))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Processed{
+		Data:          out,
+		ContentType:   "image/png",
+		Extension:     ".png",
+		Width:         avatarSize,
+		Height:        avatarSize,
+		Thumbnail:     thumb,
+		ThumbnailType: "image/png",
+	}, nil
+}
+
+func isImageType(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+func decodeImage(data []byte, contentType string) (image.Image, error) {
+	// Check the dimensions before decoding to reject decompression bombs
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid image: %v", ErrUnsupportedType, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("%w: image dimensions %dx%d", ErrTooLarge, cfg.Width, cfg.Height)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid image: %v", ErrUnsupportedType, err)
+	}
+
+	if contentType == "image/jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+	return img, nil
+}
+
+func encodeImage(img image.Image, contentType string) ([]byte, string, string, error) {
+	switch contentType {
+	case "image/jpeg":
+		out, err := encodeJPEG(img)
+		return out, "image/jpeg", ".jpg", err
+	case "image/gif":
+		var buf bytes.Buffer
+		if err := gif.Encode(&buf, img, nil); err != nil {
+			return nil, "", "", err
+		}
+		return buf.Bytes(), "image/gif", ".gif", nil
+	default:
+		out, err := encodePNG(img)
+		return out, "image/png", ".png", err
+	}
+}
+
+func encodeJPEG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodePNG(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// flatten draws the image onto a white background, since JPEG has no alpha channel
+func flatten(img image.Image) image.Image {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Over)
+	return dst
+}
+
+// fit scales the image down so that it fits in a size x size box, keeping the aspect ratio
+func fit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return img
+	}
+	if w >= h {
+		return resize(img, size, max(1, h*size/w))
+	}
+	return resize(img, max(1, w*size/h), size)
+}
+
+func cropSquare(img image.Image) image.Image {
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+
+	dst := image.NewNRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(dst, dst.Bounds(), img, image.Point{X: x, Y: y}, draw.Src)
+	return dst
+}
+
+// resize scales the image with box filtering: each destination pixel is the
+// average of the source pixels it covers.
+func resize(img image.Image, width, height int) image.Image {
+	src := image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(src, src.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := y * sh / height
+		y1 := max(y0+1, (y+1)*sh/height)
+		for x := 0; x < width; x++ {
+			x0 := x * sw / width
+			x1 := max(x0+1, (x+1)*sw/width)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				off := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint64(src.Pix[off])
+					g += uint64(src.Pix[off+1])
+					b += uint64(src.Pix[off+2])
+					a += uint64(src.Pix[off+3])
+					off += 4
+					n++
+				}
+			}
+
+			off := dst.PixOffset(x, y)
+			dst.Pix[off] = uint8(r / n)
+			dst.Pix[off+1] = uint8(g / n)
+			dst.Pix[off+2] = uint8(b / n)
+			dst.Pix[off+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+// jpegOrientation reads the EXIF orientation tag from a JPEG file. It returns 1
+// (no transformation) when the tag is missing or cannot be parsed.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return 1
+		}
+		marker := data[pos+1]
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if marker == 0xDA || length < 2 || pos+2+length > len(data) {
+			return 1
+		}
+
+		segment := data[pos+4 : pos+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		pos += 2 + length
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			value := int(order.Uint16(tiff[entry+8:]))
+			if value >= 1 && value <= 8 {
+				return value
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// applyOrientation rotates and flips the image so that it displays upright
+// once the EXIF orientation tag has been stripped.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
@@ -0,0 +1,155 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+// Service stores uploads in a BlobStore and records them as attachments
+type Service struct {
+	store    BlobStore
+	repo     *storage.AttachmentRepository
+	signer   *URLSigner
+	maxBytes int64
+}
+
+func NewService(store BlobStore, repo *storage.AttachmentRepository, signer *URLSigner, maxBytes int64) *Service {
+	return &Service{
+		store:    store,
+		repo:     repo,
+		signer:   signer,
+		maxBytes: maxBytes,
+	}
+}
+
+// UploadRequest describes a file to be stored
+type UploadRequest struct {
+	UserID       int64
+	PostID       *int64
+	CommentID    *int64
+	Purpose      string
+	OriginalName string
+	Body         io.Reader
+}
+
+// Upload validates, processes and stores a file, then records it as an attachment
+func (s *Service) Upload(ctx context.Context, req UploadRequest) (*storage.Attachment, error) {
+	data, err := io.ReadAll(io.LimitReader(req.Body, s.maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > s.maxBytes {
+		return nil, ErrTooLarge
+	}
+
+	var processed *Processed
+	if req.Purpose == storage.AttachmentPurposeAvatar {
+		processed, err = processAvatar(data)
+	} else {
+		processed, err = processFile(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := randomName()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := path.Join("attachments", time.Now().UTC().Format("2006/01"))
+	if req.Purpose == storage.AttachmentPurposeAvatar {
+		dir = path.Join("avatars", fmt.Sprint(req.UserID))
+	}
+
+	attachment := &storage.Attachment{
+		UserID:       req.UserID,
+		PostID:       req.PostID,
+		CommentID:    req.CommentID,
+		Purpose:      req.Purpose,
+		StorageKey:   path.Join(dir, name+processed.Extension),
+		OriginalName: path.Base(req.OriginalName),
+		ContentType:  processed.ContentType,
+		SizeBytes:    int64(len(processed.Data)),
+	}
+
+	if err := s.store.Put(ctx, attachment.StorageKey, bytes.NewReader(processed.Data), attachment.SizeBytes, processed.ContentType); err != nil {
+		return nil, fmt.Errorf("failed to store file: %w", err)
+	}
+
+	if processed.IsImage() {
+		attachment.Width = &processed.Width
+		attachment.Height = &processed.Height
+
+		thumbKey := path.Join("thumbnails", attachment.StorageKey)
+		if processed.ThumbnailType == "image/jpeg" {
+			thumbKey = thumbKey[:len(thumbKey)-len(path.Ext(thumbKey))] + ".jpg"
+		}
+		if err := s.store.Put(ctx, thumbKey, bytes.NewReader(processed.Thumbnail), int64(len(processed.Thumbnail)), processed.ThumbnailType); err != nil {
+			s.removeBlobs(ctx, attachment.StorageKey)
+			return nil, fmt.Errorf("failed to store thumbnail: %w", err)
+		}
+		attachment.ThumbnailKey = &thumbKey
+	}
+
+	if err := s.repo.CreateAttachment(ctx, attachment); err != nil {
+		s.removeBlobs(ctx, attachment.StorageKey, attachment.ThumbnailKey)
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+// Delete removes the attachment record and its blobs
+func (s *Service) Delete(ctx context.Context, attachment *storage.Attachment) error {
+	if err := s.repo.DeleteAttachment(ctx, attachment.ID); err != nil {
+		return err
+	}
+	s.removeBlobs(ctx, attachment.StorageKey, attachment.ThumbnailKey)
+	return nil
+}
+
+// SignedURL returns a signed, expiring URL for the attachment file
+func (s *Service) SignedURL(attachment *storage.Attachment) string {
+	return s.signer.Sign(attachment.StorageKey)
+}
+
+// SignedThumbnailURL returns a signed URL for the thumbnail, or an empty string if there is none
+func (s *Service) SignedThumbnailURL(attachment *storage.Attachment) string {
+	if attachment.ThumbnailKey == nil {
+		return ""
+	}
+	return s.signer.Sign(*attachment.ThumbnailKey)
+}
+
+func (s *Service) removeBlobs(ctx context.Context, key string, thumbKey ...*string) {
+	keys := []string{key}
+	for _, k := range thumbKey {
+		if k != nil {
+			keys = append(keys, *k)
+		}
+	}
+
+	for _, k := range keys {
+		if err := s.store.Delete(ctx, k); err != nil {
+			logger.Error().Err(err).Str("key", k).Msg("Failed to delete blob")
+		}
+	}
+}
+
+func randomName() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,53 @@
+package upload
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// FilesPrefix is the URL prefix under which blobs are served
+const FilesPrefix = "/files/"
+
+// URLSigner issues and verifies expiring signed URLs for stored blobs
+type URLSigner struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+func NewURLSigner(secret string, ttl time.Duration) *URLSigner {
+	return &URLSigner{secret: []byte(secret), ttl: ttl}
+}
+
+// Sign returns a URL path for the blob key that stays valid for the configured TTL
+func (s *URLSigner) Sign(key string) string {
+	expires := time.Now().Add(s.ttl).Unix()
+
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("sig", s.signature(key, expires))
+
+	return FilesPrefix + key + "?" + query.Encode()
+}
+
+// Verify checks that the signature matches the key and has not expired
+func (s *URLSigner) Verify(key, expiresParam, sig string) bool {
+	expires, err := strconv.ParseInt(expiresParam, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+
+	expected := s.signature(key, expires)
+	return hmac.Equal([]byte(expected), []byte(sig))
+}
+
+func (s *URLSigner) signature(key string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(key))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
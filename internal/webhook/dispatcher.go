@@ -0,0 +1,202 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/config"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+// Headers of every delivery. The signature is the hex HMAC-SHA256, keyed with
+// the endpoint secret, of the timestamp, a dot and the body; receivers should
+// reject old timestamps to stop replays.
+const (
+	HeaderEvent     = "X-Forum-Event"
+	HeaderEventID   = "X-Forum-Event-Id"
+	HeaderDelivery  = "X-Forum-Delivery"
+	HeaderTimestamp = "X-Forum-Timestamp"
+	HeaderSignature = "X-Forum-Signature"
+)
+
+// maxErrorLength caps the response excerpt kept with a failed attempt
+const maxErrorLength = 500
+
+// Dispatcher posts queued deliveries to their endpoints
+type Dispatcher struct {
+	repo   *storage.WebhookRepository
+	client *http.Client
+	cfg    *config.Config
+}
+
+func NewDispatcher(repo *storage.WebhookRepository, cfg *config.Config) *Dispatcher {
+	return &Dispatcher{
+		repo: repo,
+		client: &http.Client{
+			Timeout: cfg.WebhookTimeout,
+			// A redirect is a failed delivery, the endpoint URL should be fixed
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		cfg: cfg,
+	}
+}
+
+// Run sends the due deliveries every WebhookPollInterval until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.WebhookPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.dispatchDue(ctx)
+		}
+	}
+}
+
+// dispatchDue sends due deliveries, WebhookBatchSize at a time, until none
+// are left
+func (d *Dispatcher) dispatchDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		// The lease outlasts the requests of the batch, so another dispatcher
+		// does not pick them up while they are in flight
+		lease := time.Now().Add(2*d.cfg.WebhookTimeout + time.Minute)
+		deliveries, endpoints, err := d.repo.ClaimDueDeliveries(ctx, d.cfg.WebhookBatchSize, lease)
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to claim webhook deliveries")
+			return
+		}
+
+		var wg sync.WaitGroup
+		for i := range deliveries {
+			endpoint := endpoints[deliveries[i].EndpointID]
+			if endpoint == nil {
+				continue
+			}
+			wg.Add(1)
+			go func(delivery *storage.WebhookDelivery) {
+				defer wg.Done()
+				d.deliver(ctx, endpoint, delivery)
+			}(&deliveries[i])
+		}
+		wg.Wait()
+
+		if len(deliveries) < d.cfg.WebhookBatchSize {
+			return
+		}
+	}
+}
+
+// deliver makes one attempt and records its outcome. Any 2xx response is a
+// success; everything else is retried until the attempts run out.
+func (d *Dispatcher) deliver(ctx context.Context, endpoint *storage.WebhookEndpoint, delivery *storage.WebhookDelivery) {
+	start := time.Now()
+	statusCode, err := d.post(ctx, endpoint, delivery)
+	duration := time.Since(start)
+
+	delivered := err == nil
+	var errMsg string
+	var nextAttemptAt *time.Time
+	if !delivered {
+		errMsg = err.Error()
+		if attempt := delivery.Attempts + 1; attempt < d.cfg.WebhookMaxAttempts {
+			next := time.Now().Add(d.backoff(attempt))
+			nextAttemptAt = &next
+		}
+	}
+
+	// Record the attempt even if ctx was cancelled in the meantime
+	if err := d.repo.RecordAttempt(context.Background(), delivery.ID, statusCode, errMsg, duration, delivered, nextAttemptAt); err != nil {
+		logger.Error().Err(err).Int64("delivery_id", delivery.ID).Msg("Failed to record webhook attempt")
+		return
+	}
+
+	switch {
+	case delivered:
+		logger.Debug().Int64("delivery_id", delivery.ID).Int64("endpoint_id", endpoint.ID).Msg("Webhook delivered")
+	case nextAttemptAt == nil:
+		logger.Warn().Str("error", errMsg).Int64("delivery_id", delivery.ID).Int64("endpoint_id", endpoint.ID).
+			Msg("Webhook delivery failed for good")
+	default:
+		logger.Info().Str("error", errMsg).Int64("delivery_id", delivery.ID).Int64("endpoint_id", endpoint.ID).
+			Time("next_attempt_at", *nextAttemptAt).Msg("Webhook delivery failed, will retry")
+	}
+}
+
+// post sends the delivery and returns the response status, nil if there was
+// no response
+func (d *Dispatcher) post(ctx context.Context, endpoint *storage.WebhookEndpoint, delivery *storage.WebhookDelivery) (*int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return nil, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Forum-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderEventID, delivery.EventID)
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, "sha256="+Sign(endpoint.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	statusCode := resp.StatusCode
+	if statusCode >= 200 && statusCode < 300 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		return &statusCode, nil
+	}
+
+	excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorLength))
+	if len(excerpt) > 0 {
+		return &statusCode, fmt.Errorf("endpoint responded %d: %s", statusCode, bytes.ToValidUTF8(excerpt, nil))
+	}
+	return &statusCode, fmt.Errorf("endpoint responded %d", statusCode)
+}
+
+// backoff is the delay before the attempt after the given one:
+// WebhookBackoffBase doubled for every failed attempt, capped at
+// WebhookBackoffMax, plus up to 10% jitter so that deliveries that failed
+// together do not all come back at once
+func (d *Dispatcher) backoff(attempt int) time.Duration {
+	delay := d.cfg.WebhookBackoffBase
+	for i := 1; i < attempt && delay < d.cfg.WebhookBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > d.cfg.WebhookBackoffMax {
+		delay = d.cfg.WebhookBackoffMax
+	}
+	if jitter := int64(delay / 10); jitter > 0 {
+		delay += time.Duration(rand.Int63n(jitter))
+	}
+	return delay
+}
+
+// Sign returns the hex signature of a delivery body sent at timestamp
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
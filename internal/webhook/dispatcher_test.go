@@ -0,0 +1,168 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/config"
+)
+
+func TestSign(t *testing.T) {
+	const (
+		secret    = "whsec_test"
+		timestamp = "1700000000"
+	)
+	body := []byte(`{"id":"evt_1"}`)
+
+	// Computed independently as HMAC-SHA256 of "1700000000.{"id":"evt_1"}"
+	want := "c89214b5b5da833daed6f0b8c5bb6bd58cea9022bd80ccc78230f3942d632925"
+	if got := Sign(secret, timestamp, body); got != want {
+		t.Fatalf("Sign = %s, want %s", got, want)
+	}
+
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		body      []byte
+	}{
+		{name: "other secret", secret: "whsec_other", timestamp: timestamp, body: body},
+		{name: "other timestamp", secret: secret, timestamp: "1700000001", body: body},
+		{name: "other body", secret: secret, timestamp: timestamp, body: []byte(`{"id":"evt_2"}`)},
+		// The dot keeps the timestamp and the body apart
+		{name: "digit moved into the body", secret: secret, timestamp: "170000000", body: []byte(`0{"id":"evt_1"}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sign(tt.secret, tt.timestamp, tt.body); got == want {
+				t.Fatal("signature did not change")
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	cfg := &config.Config{
+		WebhookBackoffBase: 30 * time.Second,
+		WebhookBackoffMax:  10 * time.Minute,
+	}
+	d := &Dispatcher{cfg: cfg}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{attempt: 1, want: 30 * time.Second},
+		{attempt: 2, want: time.Minute},
+		{attempt: 3, want: 2 * time.Minute},
+		{attempt: 5, want: 8 * time.Minute},
+		{attempt: 6, want: 10 * time.Minute},
+		{attempt: 50, want: 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		// Jitter adds up to 10%, so check the range over several draws
+		for i := 0; i < 20; i++ {
+			got := d.backoff(tt.attempt)
+			if got < tt.want || got >= tt.want+tt.want/10 {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", tt.attempt, got, tt.want, tt.want+tt.want/10)
+			}
+		}
+	}
+}
+
+func TestPostSignsDelivery(t *testing.T) {
+	delivery := &storage.WebhookDelivery{
+		ID:        7,
+		EventID:   "evt_1",
+		EventType: "post.created",
+		Payload:   json.RawMessage(`{"id":"evt_1","type":"post.created"}`),
+	}
+
+	received := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- body
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	d := NewDispatcher(nil, &config.Config{WebhookTimeout: 5 * time.Second})
+	endpoint := &storage.WebhookEndpoint{ID: 1, URL: server.URL, Secret: "whsec_test"}
+
+	statusCode, err := d.post(context.Background(), endpoint, delivery)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	if statusCode == nil || *statusCode != http.StatusNoContent {
+		t.Fatalf("status = %v, want 204", statusCode)
+	}
+
+	r, body := <-received, <-bodies
+	if string(body) != string(delivery.Payload) {
+		t.Fatalf("body = %s, want the payload", body)
+	}
+	want := map[string]string{
+		HeaderEvent:    "post.created",
+		HeaderEventID:  "evt_1",
+		HeaderDelivery: "7",
+		"Content-Type": "application/json",
+	}
+	for name, value := range want {
+		if got := r.Header.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+
+	timestamp := r.Header.Get(HeaderTimestamp)
+	if signature := r.Header.Get(HeaderSignature); signature != "sha256="+Sign("whsec_test", timestamp, body) {
+		t.Fatalf("signature %q does not match the body sent at %s", signature, timestamp)
+	}
+}
+
+func TestPostFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantErr    string
+		wantStatus int
+	}{
+		{name: "server error", status: http.StatusInternalServerError, body: "boom", wantErr: "endpoint responded 500: boom", wantStatus: 500},
+		{name: "client error without body", status: http.StatusGone, wantErr: "endpoint responded 410", wantStatus: 410},
+		{name: "redirect", status: http.StatusFound, wantErr: "endpoint responded 302", wantStatus: 302},
+		{name: "long body is cut", status: http.StatusBadGateway, body: strings.Repeat("x", 2*maxErrorLength), wantErr: "endpoint responded 502: " + strings.Repeat("x", maxErrorLength), wantStatus: 502},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.status == http.StatusFound {
+					w.Header().Set("Location", "/elsewhere")
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+
+			d := NewDispatcher(nil, &config.Config{WebhookTimeout: 5 * time.Second})
+			endpoint := &storage.WebhookEndpoint{ID: 1, URL: server.URL, Secret: "whsec_test"}
+
+			statusCode, err := d.post(context.Background(), endpoint, &storage.WebhookDelivery{ID: 1, Payload: json.RawMessage(`{}`)})
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+			if statusCode == nil || *statusCode != tt.wantStatus {
+				t.Fatalf("status = %v, want %d", statusCode, tt.wantStatus)
+			}
+		})
+	}
+}
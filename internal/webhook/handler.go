@@ -0,0 +1,442 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ryan-Gosusluging/forum/internal/auth"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+	"github.com/Ryan-Gosusluging/forum/pkg/logger"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+	maxURLLength    = 2048
+)
+
+type EndpointResponse struct {
+	ID     int64    `json:"id"`
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Active bool     `json:"active"`
+	// Secret signs the deliveries. It is only returned when the endpoint
+	// is created.
+	Secret    string `json:"secret,omitempty"`
+	CreatedBy *int64 `json:"created_by,omitempty"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type AttemptResponse struct {
+	StatusCode *int   `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int    `json:"duration_ms"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type DeliveryResponse struct {
+	ID             int64             `json:"id"`
+	EventID        string            `json:"event_id"`
+	EventType      string            `json:"event_type"`
+	Payload        json.RawMessage   `json:"payload"`
+	Status         string            `json:"status"`
+	Attempts       []AttemptResponse `json:"attempts"`
+	NextAttemptAt  *string           `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int              `json:"last_status_code,omitempty"`
+	LastError      string            `json:"last_error,omitempty"`
+	CreatedAt      string            `json:"created_at"`
+	DeliveredAt    *string           `json:"delivered_at,omitempty"`
+}
+
+type EndpointRequest struct {
+	URL string `json:"url"`
+	// Events to receive, all of them if empty
+	Events []string `json:"events"`
+	// Active defaults to true
+	Active *bool `json:"active"`
+}
+
+// Handler lets admins manage webhook endpoints and inspect their deliveries
+// under /api/admin/webhooks
+type Handler struct {
+	repo *storage.WebhookRepository
+	auth *auth.Service
+}
+
+func NewHandler(repo *storage.WebhookRepository, auth *auth.Service) *Handler {
+	return &Handler{
+		repo: repo,
+		auth: auth,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/webhooks"), "/")
+	var parts []string
+	if path != "" {
+		parts = strings.Split(path, "/")
+	}
+
+	var endpointID, deliveryID int64
+	if len(parts) > 0 {
+		var err error
+		if endpointID, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+			http.Error(w, "Invalid webhook ID", http.StatusBadRequest)
+			return
+		}
+	}
+	if len(parts) > 2 {
+		var err error
+		if deliveryID, err = strconv.ParseInt(parts[2], 10, 64); err != nil {
+			http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		h.handleListEndpoints(w, r)
+	case len(parts) == 0 && r.Method == http.MethodPost:
+		h.handleCreateEndpoint(w, r, userID)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		h.handleGetEndpoint(w, r, endpointID)
+	case len(parts) == 1 && r.Method == http.MethodPut:
+		h.handleUpdateEndpoint(w, r, endpointID)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		h.handleDeleteEndpoint(w, r, endpointID)
+	case len(parts) == 2 && parts[1] == "deliveries" && r.Method == http.MethodGet:
+		h.handleListDeliveries(w, r, endpointID)
+	case len(parts) == 3 && parts[1] == "deliveries" && r.Method == http.MethodGet:
+		h.handleGetDelivery(w, r, endpointID, deliveryID)
+	case len(parts) == 4 && parts[1] == "deliveries" && parts[3] == "retry" && r.Method == http.MethodPost:
+		h.handleRetryDelivery(w, r, endpointID, deliveryID)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) handleListEndpoints(w http.ResponseWriter, r *http.Request) {
+	endpoints, err := h.repo.GetEndpoints(r.Context())
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to get webhook endpoints")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]EndpointResponse, len(endpoints))
+	for i := range endpoints {
+		response[i] = toEndpointResponse(&endpoints[i])
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) handleCreateEndpoint(w http.ResponseWriter, r *http.Request, userID int64) {
+	var req EndpointRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if msg := validateEndpoint(&req); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to generate webhook secret")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	active := req.Active == nil || *req.Active
+	endpoint, err := h.repo.CreateEndpoint(r.Context(), req.URL, secret, req.Events, active, userID)
+	if err != nil {
+		logger.Error().Err(err).Msg("Failed to create webhook endpoint")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info().Int64("endpoint_id", endpoint.ID).Int64("user_id", userID).Msg("Webhook endpoint created")
+	response := toEndpointResponse(endpoint)
+	response.Secret = endpoint.Secret
+	writeJSON(w, http.StatusCreated, response)
+}
+
+func (h *Handler) handleGetEndpoint(w http.ResponseWriter, r *http.Request, id int64) {
+	endpoint, err := h.repo.GetEndpoint(r.Context(), id)
+	if err != nil {
+		logger.Error().Err(err).Int64("endpoint_id", id).Msg("Failed to get webhook endpoint")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if endpoint == nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, toEndpointResponse(endpoint))
+}
+
+func (h *Handler) handleUpdateEndpoint(w http.ResponseWriter, r *http.Request, id int64) {
+	var req EndpointRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if msg := validateEndpoint(&req); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	active := req.Active == nil || *req.Active
+
+	endpoint, err := h.repo.UpdateEndpoint(r.Context(), id, req.URL, req.Events, active)
+	if err != nil {
+		logger.Error().Err(err).Int64("endpoint_id", id).Msg("Failed to update webhook endpoint")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if endpoint == nil {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, toEndpointResponse(endpoint))
+}
+
+func (h *Handler) handleDeleteEndpoint(w http.ResponseWriter, r *http.Request, id int64) {
+	deleted, err := h.repo.DeleteEndpoint(r.Context(), id)
+	if err != nil {
+		logger.Error().Err(err).Int64("endpoint_id", id).Msg("Failed to delete webhook endpoint")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+		return
+	}
+
+	logger.Info().Int64("endpoint_id", id).Msg("Webhook endpoint deleted")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListDeliveries pages through the delivery log of the endpoint, newest
+// first. The before parameter is the ID of the last delivery of the previous
+// page; status filters by pending, delivered or dead.
+func (h *Handler) handleListDeliveries(w http.ResponseWriter, r *http.Request, endpointID int64) {
+	query := r.URL.Query()
+	limit := defaultPageSize
+	if limitStr := query.Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		if limit > maxPageSize {
+			limit = maxPageSize
+		}
+	}
+
+	var before int64
+	if beforeStr := query.Get("before"); beforeStr != "" {
+		var err error
+		before, err = strconv.ParseInt(beforeStr, 10, 64)
+		if err != nil || before <= 0 {
+			http.Error(w, "Invalid before parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	status := query.Get("status")
+	switch status {
+	case "", storage.DeliveryPending, storage.DeliveryDelivered, storage.DeliveryDead:
+	default:
+		http.Error(w, "Status must be pending, delivered or dead", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	deliveries, err := h.repo.GetDeliveries(ctx, endpointID, status, before, limit)
+	if err != nil {
+		logger.Error().Err(err).Int64("endpoint_id", endpointID).Msg("Failed to get webhook deliveries")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	ids := make([]int64, len(deliveries))
+	for i := range deliveries {
+		ids[i] = deliveries[i].ID
+	}
+	attempts, err := h.repo.GetAttempts(ctx, ids)
+	if err != nil {
+		logger.Error().Err(err).Int64("endpoint_id", endpointID).Msg("Failed to get webhook delivery attempts")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]DeliveryResponse, len(deliveries))
+	for i := range deliveries {
+		response[i] = toDeliveryResponse(&deliveries[i], attempts[deliveries[i].ID])
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *Handler) handleGetDelivery(w http.ResponseWriter, r *http.Request, endpointID, id int64) {
+	ctx := r.Context()
+	delivery, err := h.repo.GetDelivery(ctx, endpointID, id)
+	if err != nil {
+		logger.Error().Err(err).Int64("delivery_id", id).Msg("Failed to get webhook delivery")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if delivery == nil {
+		http.Error(w, "Delivery not found", http.StatusNotFound)
+		return
+	}
+
+	attempts, err := h.repo.GetAttempts(ctx, []int64{id})
+	if err != nil {
+		logger.Error().Err(err).Int64("delivery_id", id).Msg("Failed to get webhook delivery attempts")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, toDeliveryResponse(delivery, attempts[id]))
+}
+
+// handleRetryDelivery queues a dead delivery again
+func (h *Handler) handleRetryDelivery(w http.ResponseWriter, r *http.Request, endpointID, id int64) {
+	retried, err := h.repo.RetryDelivery(r.Context(), endpointID, id)
+	if err != nil {
+		logger.Error().Err(err).Int64("delivery_id", id).Msg("Failed to retry webhook delivery")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if !retried {
+		http.Error(w, "No dead delivery with this ID", http.StatusNotFound)
+		return
+	}
+
+	logger.Info().Int64("delivery_id", id).Int64("endpoint_id", endpointID).Msg("Webhook delivery requeued")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// requireAdmin authenticates a site admin signed in with a session. API
+// tokens cannot manage webhooks, whatever their scopes.
+func (h *Handler) requireAdmin(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	claims, err := h.auth.AuthenticateSession(r)
+	if err != nil {
+		if errors.Is(err, auth.ErrSessionRequired) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		} else {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		}
+		return 0, false
+	}
+	if claims.Role != auth.RoleAdmin {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return 0, false
+	}
+	return claims.UserId, true
+}
+
+// validateEndpoint checks the request and returns the problem, if any
+func validateEndpoint(req *EndpointRequest) string {
+	req.URL = strings.TrimSpace(req.URL)
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || len(req.URL) > maxURLLength {
+		return "URL must be an absolute http or https URL"
+	}
+
+	if req.Events == nil {
+		req.Events = []string{}
+	}
+	for _, event := range req.Events {
+		if !knownEvent(event) {
+			return "Unknown event: " + event
+		}
+	}
+	return ""
+}
+
+func knownEvent(event string) bool {
+	for _, known := range Events {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+func toEndpointResponse(e *storage.WebhookEndpoint) EndpointResponse {
+	events := e.Events
+	if events == nil {
+		events = []string{}
+	}
+	return EndpointResponse{
+		ID:        e.ID,
+		URL:       e.URL,
+		Events:    events,
+		Active:    e.Active,
+		CreatedBy: e.CreatedBy,
+		CreatedAt: e.CreatedAt.Format(time.RFC3339),
+		UpdatedAt: e.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+func toDeliveryResponse(d *storage.WebhookDelivery, attempts []storage.WebhookAttempt) DeliveryResponse {
+	response := DeliveryResponse{
+		ID:             d.ID,
+		EventID:        d.EventID,
+		EventType:      d.EventType,
+		Payload:        d.Payload,
+		Status:         d.Status,
+		Attempts:       make([]AttemptResponse, len(attempts)),
+		LastStatusCode: d.LastStatusCode,
+		LastError:      d.LastError,
+		CreatedAt:      d.CreatedAt.Format(time.RFC3339),
+	}
+	if d.Status == storage.DeliveryPending {
+		next := d.NextAttemptAt.Format(time.RFC3339)
+		response.NextAttemptAt = &next
+	}
+	if d.DeliveredAt != nil {
+		delivered := d.DeliveredAt.Format(time.RFC3339)
+		response.DeliveredAt = &delivered
+	}
+	for i, a := range attempts {
+		response.Attempts[i] = AttemptResponse{
+			StatusCode: a.StatusCode,
+			Error:      a.Error,
+			DurationMs: a.DurationMs,
+			CreatedAt:  a.CreatedAt.Format(time.RFC3339),
+		}
+	}
+	return response
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Error().Err(err).Msg("Failed to encode response")
+	}
+}
@@ -0,0 +1,21 @@
+package webhook
+
+import (
+	"github.com/Ryan-Gosusluging/forum/internal/events"
+	"github.com/Ryan-Gosusluging/forum/internal/storage"
+)
+
+// Events are the event types endpoints can subscribe to
+var Events = []string{
+	events.PostCreated,
+	events.PostDeleted,
+	events.CommentCreated,
+	events.CommentDeleted,
+	events.UserRegistered,
+	events.ReportFiled,
+}
+
+// Subscribe queues the deliveries of the events of the bus
+func Subscribe(bus *events.Bus, repo *storage.WebhookRepository) {
+	bus.Subscribe("webhooks", repo.EnqueueEvent, Events...)
+}
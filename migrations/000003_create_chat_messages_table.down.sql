@@ -1 +0,0 @@
-DROP TABLE IF EXISTS chat_messages; 
\ No newline at end of file
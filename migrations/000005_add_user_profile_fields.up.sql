@@ -0,0 +1,7 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS display_name VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS bio TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS location VARCHAR(100) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS website VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS signature TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS avatar_url VARCHAR(512) NOT NULL DEFAULT '';
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS attachments (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    post_id INTEGER REFERENCES posts(id) ON DELETE CASCADE,
+    comment_id INTEGER REFERENCES comments(id) ON DELETE CASCADE,
+    purpose VARCHAR(20) NOT NULL DEFAULT 'attachment',
+    storage_key VARCHAR(512) NOT NULL UNIQUE,
+    thumbnail_key VARCHAR(512),
+    original_name VARCHAR(255) NOT NULL DEFAULT '',
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    width INTEGER,
+    height INTEGER,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT attachments_single_parent CHECK (post_id IS NULL OR comment_id IS NULL)
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachments_user_id ON attachments(user_id);
+CREATE INDEX IF NOT EXISTS idx_attachments_post_id ON attachments(post_id);
+CREATE INDEX IF NOT EXISTS idx_attachments_comment_id ON attachments(comment_id);
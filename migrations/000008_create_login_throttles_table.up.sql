@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS login_throttles (
+    key VARCHAR(320) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    blocked_until TIMESTAMP WITH TIME ZONE,
+    locked_until TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_throttles_last_failure_at ON login_throttles(last_failure_at);
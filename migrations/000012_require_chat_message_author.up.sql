@@ -0,0 +1,4 @@
+-- Only registered users may send chat messages. Databases created before the
+-- duplicate chat_messages migration was removed may still allow NULL authors.
+DELETE FROM chat_messages WHERE user_id IS NULL;
+ALTER TABLE chat_messages ALTER COLUMN user_id SET NOT NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_chat_messages_room_id_created_at;
+ALTER TABLE chat_messages DROP COLUMN IF EXISTS room_id;
+DROP TABLE IF EXISTS room_members;
+DROP TABLE IF EXISTS rooms;
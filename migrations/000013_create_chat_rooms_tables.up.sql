@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS rooms (
+    id SERIAL PRIMARY KEY,
+    slug VARCHAR(50) NOT NULL UNIQUE,
+    name VARCHAR(100) NOT NULL,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('public', 'private', 'category')),
+    category VARCHAR(50) UNIQUE,
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK ((kind = 'category') = (category IS NOT NULL))
+);
+
+CREATE TABLE IF NOT EXISTS room_members (
+    room_id INTEGER NOT NULL REFERENCES rooms(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL DEFAULT 'member' CHECK (role IN ('owner', 'member')),
+    joined_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (room_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_room_members_user_id ON room_members(user_id);
+
+-- Messages sent before rooms existed belong to the default room
+INSERT INTO rooms (slug, name, kind) VALUES ('general', 'General', 'public')
+ON CONFLICT (slug) DO NOTHING;
+
+ALTER TABLE chat_messages ADD COLUMN IF NOT EXISTS room_id INTEGER REFERENCES rooms(id) ON DELETE CASCADE;
+UPDATE chat_messages SET room_id = (SELECT id FROM rooms WHERE slug = 'general') WHERE room_id IS NULL;
+ALTER TABLE chat_messages ALTER COLUMN room_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_chat_messages_room_id_created_at ON chat_messages(room_id, created_at);
@@ -0,0 +1,9 @@
+-- NOTIFY payloads are limited to 8000 bytes. Larger chat events are stored
+-- here and only their ID is sent; rows are pruned shortly afterwards.
+CREATE TABLE IF NOT EXISTS chat_fanout_payloads (
+    id BIGSERIAL PRIMARY KEY,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_fanout_payloads_created_at ON chat_fanout_payloads(created_at);
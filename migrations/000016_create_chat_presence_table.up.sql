@@ -0,0 +1,12 @@
+-- One row per user, room and forum-service instance with open connections.
+-- room_id 0 records that the user is connected at all. Instances refresh
+-- seen_at periodically, so rows of crashed instances expire.
+CREATE TABLE IF NOT EXISTS chat_presence (
+    instance_id VARCHAR(32) NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    room_id INTEGER NOT NULL DEFAULT 0,
+    seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (instance_id, user_id, room_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_presence_room_id ON chat_presence(room_id, user_id);
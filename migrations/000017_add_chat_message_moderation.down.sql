@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS audit_logs;
+
+DELETE FROM chat_messages WHERE deleted_at IS NOT NULL;
+ALTER TABLE chat_messages DROP COLUMN IF EXISTS deleted_by;
+ALTER TABLE chat_messages DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE chat_messages DROP COLUMN IF EXISTS edited_at;
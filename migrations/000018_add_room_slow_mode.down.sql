@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_chat_messages_room_user;
+ALTER TABLE rooms DROP COLUMN IF EXISTS slow_mode_seconds;
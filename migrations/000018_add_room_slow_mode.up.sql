@@ -0,0 +1,6 @@
+-- In slow mode each user may send one message per interval in the room.
+-- 0 turns slow mode off.
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS slow_mode_seconds INTEGER NOT NULL DEFAULT 0
+    CHECK (slow_mode_seconds >= 0);
+
+CREATE INDEX IF NOT EXISTS idx_chat_messages_room_user ON chat_messages(room_id, user_id, created_at);
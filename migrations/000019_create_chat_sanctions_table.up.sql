@@ -0,0 +1,15 @@
+-- Mutes and bans last until expires_at, or until revoked if it is NULL.
+-- Kicks are recorded for the history only.
+CREATE TABLE IF NOT EXISTS chat_sanctions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    kind VARCHAR(16) NOT NULL CHECK (kind IN ('mute', 'kick', 'ban')),
+    reason TEXT NOT NULL DEFAULT '',
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    revoked_by INTEGER REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_sanctions_active ON chat_sanctions(user_id, kind) WHERE revoked_at IS NULL;
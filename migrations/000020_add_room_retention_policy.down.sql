@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_chat_messages_room_id;
+ALTER TABLE rooms DROP CONSTRAINT IF EXISTS rooms_retention_count_check;
+ALTER TABLE rooms DROP COLUMN IF EXISTS retention_max_messages;
+ALTER TABLE rooms DROP COLUMN IF EXISTS retention_ttl_seconds;
+ALTER TABLE rooms DROP COLUMN IF EXISTS retention_policy;
@@ -0,0 +1,11 @@
+-- ttl purges messages older than retention_ttl_seconds, or CHAT_MESSAGE_TTL
+-- if it is NULL. count keeps the newest retention_max_messages messages.
+-- forever keeps everything.
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS retention_policy VARCHAR(16) NOT NULL DEFAULT 'ttl'
+    CHECK (retention_policy IN ('ttl', 'count', 'forever'));
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS retention_ttl_seconds INTEGER CHECK (retention_ttl_seconds > 0);
+ALTER TABLE rooms ADD COLUMN IF NOT EXISTS retention_max_messages INTEGER CHECK (retention_max_messages > 0);
+ALTER TABLE rooms ADD CONSTRAINT rooms_retention_count_check
+    CHECK (retention_policy <> 'count' OR retention_max_messages IS NOT NULL);
+
+CREATE INDEX IF NOT EXISTS idx_chat_messages_room_id ON chat_messages(room_id, id);
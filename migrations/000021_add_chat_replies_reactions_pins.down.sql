@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS chat_message_reactions;
+
+DROP INDEX IF EXISTS idx_chat_messages_pinned;
+DROP INDEX IF EXISTS idx_chat_messages_reply_to_id;
+ALTER TABLE chat_messages DROP COLUMN IF EXISTS pinned_by;
+ALTER TABLE chat_messages DROP COLUMN IF EXISTS pinned_at;
+ALTER TABLE chat_messages DROP COLUMN IF EXISTS reply_to_id;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS notification_preferences;
+DROP TABLE IF EXISTS notifications;
+
+DROP INDEX IF EXISTS idx_comments_parent_id;
+ALTER TABLE comments DROP COLUMN IF EXISTS parent_id;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS email_digests;
+DROP TABLE IF EXISTS post_subscriptions;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS post_subscriptions (
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    post_id INTEGER NOT NULL REFERENCES posts(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, post_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_subscriptions_post_id ON post_subscriptions(post_id);
+
+-- Users without a row get daily digests. digested_until is the end of the
+-- activity covered by the last digest.
+CREATE TABLE IF NOT EXISTS email_digests (
+    user_id INTEGER PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    frequency VARCHAR(16) NOT NULL DEFAULT 'daily' CHECK (frequency IN ('off', 'daily', 'weekly')),
+    digested_until TIMESTAMP WITH TIME ZONE
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_notifications_user_comment;
+DROP INDEX IF EXISTS idx_webhook_deliveries_endpoint_event;
+DROP TABLE IF EXISTS outbox_handled;
+DROP TABLE IF EXISTS outbox_events;
@@ -0,0 +1,29 @@
+-- Domain events, written in the transaction of the change and dispatched to
+-- the subscribers by the relay of the forum service
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_id VARCHAR(64) NOT NULL UNIQUE,
+    event_type VARCHAR(64) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'dispatched', 'dead')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    dispatched_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_events_due ON outbox_events(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_outbox_events_dispatched_at ON outbox_events(dispatched_at) WHERE status = 'dispatched';
+
+-- Subscribers that handled an event are skipped when it is dispatched again
+CREATE TABLE IF NOT EXISTS outbox_handled (
+    event_id VARCHAR(64) NOT NULL REFERENCES outbox_events(event_id) ON DELETE CASCADE,
+    subscriber VARCHAR(64) NOT NULL,
+    handled_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (event_id, subscriber)
+);
+
+-- Redelivered events must not queue a webhook or notify a user twice
+CREATE UNIQUE INDEX IF NOT EXISTS idx_webhook_deliveries_endpoint_event ON webhook_deliveries(endpoint_id, event_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_notifications_user_comment ON notifications(user_id, comment_id) WHERE comment_id IS NOT NULL;
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,7 +18,118 @@ type Config struct {
 	AuthServicePort  int
 	ForumServicePort int
 	JWTSecret        string
-	ChatMessageTTL   time.Duration
+	ChatMessageTTL   time.Duration // for rooms without a TTL of their own
+	ChatBroker       string
+
+	// The pong timeout must be longer than the ping interval
+	ChatPingInterval    time.Duration
+	ChatPongTimeout     time.Duration
+	ChatWriteTimeout    time.Duration
+	ChatMaxMessageBytes int64
+	ChatSendBuffer      int
+	ChatReplayLimit     int
+	ChatTypingTimeout   time.Duration
+	ChatEditWindow      time.Duration
+
+	// Each user and each address may send a burst of frames, then one per
+	// refill interval
+	ChatUserBurst       int
+	ChatUserRefill      time.Duration
+	ChatIPBurst         int
+	ChatIPRefill        time.Duration
+	ChatDuplicateWindow time.Duration
+
+	ChatRetentionInterval  time.Duration
+	ChatRetentionBatchSize int
+	// ChatArchive is "none", or "blob" to archive purged messages to the
+	// blob store before deleting them
+	ChatArchive string
+
+	BlobStore       string
+	UploadDir       string
+	UploadMaxBytes  int64
+	UploadURLSecret string
+	UploadURLTTL    time.Duration
+	S3Endpoint      string
+	S3Region        string
+	S3Bucket        string
+	S3AccessKey     string
+	S3SecretKey     string
+
+	PublicBaseURL        string
+	MailDriver           string
+	MailFrom             string
+	MailDir              string
+	SMTPHost             string
+	SMTPPort             int
+	SMTPUsername         string
+	SMTPPassword         string
+	PasswordResetTTL     time.Duration
+	EmailVerificationTTL time.Duration
+
+	// The digest job mails the digests that are due every DigestInterval.
+	// Their unsubscribe links are signed with UnsubscribeSecret.
+	DigestInterval    time.Duration
+	DigestBatchSize   int
+	UnsubscribeSecret string
+
+	// The webhook dispatcher polls for due deliveries every
+	// WebhookPollInterval. Failed deliveries are retried with exponential
+	// backoff from WebhookBackoffBase up to WebhookBackoffMax and given up
+	// on after WebhookMaxAttempts.
+	WebhookPollInterval time.Duration
+	WebhookBatchSize    int
+	WebhookTimeout      time.Duration
+	WebhookMaxAttempts  int
+	WebhookBackoffBase  time.Duration
+	WebhookBackoffMax   time.Duration
+
+	// The outbox relay dispatches due events every OutboxPollInterval.
+	// Failed dispatches are retried with exponential backoff from
+	// OutboxBackoffBase up to OutboxBackoffMax and given up on after
+	// OutboxMaxAttempts. Dispatched events are kept for OutboxRetention.
+	OutboxPollInterval time.Duration
+	OutboxBatchSize    int
+	OutboxMaxAttempts  int
+	OutboxBackoffBase  time.Duration
+	OutboxBackoffMax   time.Duration
+	OutboxRetention    time.Duration
+
+	PasswordMinLength     int
+	PasswordMaxLength     int
+	PasswordMinClasses    int
+	PasswordBlocklistPath string
+	PasswordHashAlgorithm string
+	BcryptCost            int
+	Argon2MemoryKiB       int
+	Argon2Iterations      int
+	Argon2Parallelism     int
+
+	LoginBackoffThreshold   int
+	LoginBackoffBase        time.Duration
+	LoginBackoffMax         time.Duration
+	LoginLockoutThreshold   int
+	LoginIPLockoutThreshold int
+	LoginLockoutDuration    time.Duration
+	LoginFailureWindow      time.Duration
+
+	MFAIssuer        string
+	MFAEncryptionKey string
+	MFARequiredRoles []string
+	MFAChallengeTTL  time.Duration
+
+	OIDCProviders []OIDCProviderConfig
+	OIDCStateTTL  time.Duration
+}
+
+// OIDCProviderConfig describes an external OpenID Connect identity provider
+type OIDCProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
 }
 
 func NewConfig() *Config {
@@ -32,9 +144,114 @@ func NewConfig() *Config {
 		ForumServicePort: getEnvAsInt("FORUM_SERVICE_PORT", 8080),
 		JWTSecret:        getEnv("JWT_SECRET", "your-secret-key"),
 		ChatMessageTTL:   getEnvAsDuration("CHAT_MESSAGE_TTL", 24*time.Hour),
+		ChatBroker:       getEnv("CHAT_BROKER", "memory"),
+
+		ChatPingInterval:    getEnvAsDuration("CHAT_PING_INTERVAL", 30*time.Second),
+		ChatPongTimeout:     getEnvAsDuration("CHAT_PONG_TIMEOUT", 60*time.Second),
+		ChatWriteTimeout:    getEnvAsDuration("CHAT_WRITE_TIMEOUT", 10*time.Second),
+		ChatMaxMessageBytes: int64(getEnvAsInt("CHAT_MAX_MESSAGE_BYTES", 16<<10)),
+		ChatSendBuffer:      getEnvAsInt("CHAT_SEND_BUFFER", 256),
+		ChatReplayLimit:     getEnvAsInt("CHAT_REPLAY_LIMIT", 100),
+		ChatTypingTimeout:   getEnvAsDuration("CHAT_TYPING_TIMEOUT", 5*time.Second),
+		ChatEditWindow:      getEnvAsDuration("CHAT_EDIT_WINDOW", 15*time.Minute),
+
+		ChatUserBurst:       getEnvAsInt("CHAT_USER_BURST", 10),
+		ChatUserRefill:      getEnvAsDuration("CHAT_USER_REFILL", time.Second),
+		ChatIPBurst:         getEnvAsInt("CHAT_IP_BURST", 30),
+		ChatIPRefill:        getEnvAsDuration("CHAT_IP_REFILL", 200*time.Millisecond),
+		ChatDuplicateWindow: getEnvAsDuration("CHAT_DUPLICATE_WINDOW", 30*time.Second),
+
+		ChatRetentionInterval:  getEnvAsDuration("CHAT_RETENTION_INTERVAL", time.Hour),
+		ChatRetentionBatchSize: getEnvAsInt("CHAT_RETENTION_BATCH_SIZE", 500),
+		ChatArchive:            getEnv("CHAT_ARCHIVE", "none"),
+
+		BlobStore:       getEnv("BLOB_STORE", "local"),
+		UploadDir:       getEnv("UPLOAD_DIR", "./uploads"),
+		UploadMaxBytes:  int64(getEnvAsInt("UPLOAD_MAX_BYTES", 10<<20)),
+		UploadURLSecret: getEnv("UPLOAD_URL_SECRET", "your-upload-secret"),
+		UploadURLTTL:    getEnvAsDuration("UPLOAD_URL_TTL", 15*time.Minute),
+		S3Endpoint:      getEnv("S3_ENDPOINT", "http://localhost:9000"),
+		S3Region:        getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:        getEnv("S3_BUCKET", "forum"),
+		S3AccessKey:     getEnv("S3_ACCESS_KEY", "minioadmin"),
+		S3SecretKey:     getEnv("S3_SECRET_KEY", "minioadmin"),
+
+		PublicBaseURL:        getEnv("PUBLIC_BASE_URL", "http://localhost:8080"),
+		MailDriver:           getEnv("MAIL_DRIVER", "file"),
+		MailFrom:             getEnv("MAIL_FROM", "Forum <no-reply@localhost>"),
+		MailDir:              getEnv("MAIL_DIR", "./mail"),
+		SMTPHost:             getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:             getEnvAsInt("SMTP_PORT", 25),
+		SMTPUsername:         getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:         getEnv("SMTP_PASSWORD", ""),
+		PasswordResetTTL:     getEnvAsDuration("PASSWORD_RESET_TTL", time.Hour),
+		EmailVerificationTTL: getEnvAsDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+
+		DigestInterval:    getEnvAsDuration("DIGEST_INTERVAL", time.Hour),
+		DigestBatchSize:   getEnvAsInt("DIGEST_BATCH_SIZE", 100),
+		UnsubscribeSecret: getEnv("UNSUBSCRIBE_SECRET", "your-unsubscribe-secret"),
+
+		WebhookPollInterval: getEnvAsDuration("WEBHOOK_POLL_INTERVAL", 5*time.Second),
+		WebhookBatchSize:    getEnvAsInt("WEBHOOK_BATCH_SIZE", 50),
+		WebhookTimeout:      getEnvAsDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		WebhookMaxAttempts:  getEnvAsInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookBackoffBase:  getEnvAsDuration("WEBHOOK_BACKOFF_BASE", 30*time.Second),
+		WebhookBackoffMax:   getEnvAsDuration("WEBHOOK_BACKOFF_MAX", 6*time.Hour),
+
+		OutboxPollInterval: getEnvAsDuration("OUTBOX_POLL_INTERVAL", time.Second),
+		OutboxBatchSize:    getEnvAsInt("OUTBOX_BATCH_SIZE", 100),
+		OutboxMaxAttempts:  getEnvAsInt("OUTBOX_MAX_ATTEMPTS", 10),
+		OutboxBackoffBase:  getEnvAsDuration("OUTBOX_BACKOFF_BASE", 5*time.Second),
+		OutboxBackoffMax:   getEnvAsDuration("OUTBOX_BACKOFF_MAX", 30*time.Minute),
+		OutboxRetention:    getEnvAsDuration("OUTBOX_RETENTION", 7*24*time.Hour),
+
+		PasswordMinLength:     getEnvAsInt("PASSWORD_MIN_LENGTH", 10),
+		PasswordMaxLength:     getEnvAsInt("PASSWORD_MAX_LENGTH", 128),
+		PasswordMinClasses:    getEnvAsInt("PASSWORD_MIN_CLASSES", 2),
+		PasswordBlocklistPath: getEnv("PASSWORD_BLOCKLIST_PATH", ""),
+		PasswordHashAlgorithm: getEnv("PASSWORD_HASH_ALGORITHM", "argon2id"),
+		BcryptCost:            getEnvAsInt("BCRYPT_COST", 10),
+		Argon2MemoryKiB:       getEnvAsInt("ARGON2_MEMORY_KIB", 64*1024),
+		Argon2Iterations:      getEnvAsInt("ARGON2_ITERATIONS", 3),
+		Argon2Parallelism:     getEnvAsInt("ARGON2_PARALLELISM", 2),
+
+		LoginBackoffThreshold:   getEnvAsInt("LOGIN_BACKOFF_THRESHOLD", 3),
+		LoginBackoffBase:        getEnvAsDuration("LOGIN_BACKOFF_BASE", time.Second),
+		LoginBackoffMax:         getEnvAsDuration("LOGIN_BACKOFF_MAX", 5*time.Minute),
+		LoginLockoutThreshold:   getEnvAsInt("LOGIN_LOCKOUT_THRESHOLD", 10),
+		LoginIPLockoutThreshold: getEnvAsInt("LOGIN_IP_LOCKOUT_THRESHOLD", 50),
+		LoginLockoutDuration:    getEnvAsDuration("LOGIN_LOCKOUT_DURATION", 30*time.Minute),
+		LoginFailureWindow:      getEnvAsDuration("LOGIN_FAILURE_WINDOW", time.Hour),
+
+		MFAIssuer:        getEnv("MFA_ISSUER", "Forum"),
+		MFAEncryptionKey: getEnv("MFA_ENCRYPTION_KEY", "your-mfa-encryption-key"),
+		MFARequiredRoles: getEnvAsList("MFA_REQUIRED_ROLES", []string{"admin"}),
+		MFAChallengeTTL:  getEnvAsDuration("MFA_CHALLENGE_TTL", 5*time.Minute),
+
+		OIDCProviders: getOIDCProviders(),
+		OIDCStateTTL:  getEnvAsDuration("OIDC_STATE_TTL", 10*time.Minute),
 	}
 }
 
+// getOIDCProviders reads the providers named in OIDC_PROVIDERS. Each provider
+// is configured with OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET,
+// _REDIRECT_URL and optionally _SCOPES.
+func getOIDCProviders() []OIDCProviderConfig {
+	var providers []OIDCProviderConfig
+	for _, name := range getEnvAsList("OIDC_PROVIDERS", nil) {
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providers = append(providers, OIDCProviderConfig{
+			Name:         strings.ToLower(name),
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  getEnv(prefix+"REDIRECT_URL", ""),
+			Scopes:       getEnvAsList(prefix+"SCOPES", []string{"openid", "email", "profile"}),
+		})
+	}
+	return providers
+}
+
 func (c *Config) GetDBURL() string {
 	return fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=%s",
 		c.DBUser,
@@ -70,3 +287,18 @@ func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
 	}
 	return defaultValue
 }
+
+func getEnvAsList(key string, defaultValue []string) []string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
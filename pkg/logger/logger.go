@@ -19,6 +19,10 @@ func Info() *zerolog.Event {
 	return log.Info()
 }
 
+func Warn() *zerolog.Event {
+	return log.Warn()
+}
+
 func Error() *zerolog.Event {
 	return log.Error()
 }